@@ -0,0 +1,169 @@
+package xmlsec
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+)
+
+// CertificatePolicy restricts the certificates that VerifyTrusted accepts,
+// in addition to the checks that the chain of the signing certificate leads
+// to a trusted certificate and that its certificates are valid. A zero value
+// does not restrict that aspect of the certificates. Whatever its fields, a
+// policy rejects signatures whose certificate and chain are not known.
+type CertificatePolicy struct {
+	// RequireDigitalSignature rejects a signing certificate whose key usage
+	// extension does not include digitalSignature. A certificate without a
+	// key usage extension may be used for any purpose, as in RFC 5280, and
+	// is accepted.
+	RequireDigitalSignature bool
+
+	// ExtKeyUsages lists the extended key usages that the signing
+	// certificate must all have. A certificate with the any extended key
+	// usage has them all.
+	ExtKeyUsages []x509.ExtKeyUsage
+
+	// MinRSAKeySize is the minimum size in bits of the modulus of the RSA
+	// keys of the certificates in the chain.
+	MinRSAKeySize int
+
+	// MinECDSAKeySize is the minimum size in bits of the curve of the ECDSA
+	// keys of the certificates in the chain, for example 256 for P-256.
+	MinECDSAKeySize int
+
+	// MaxChainDepth is the maximum number of certificates in the chain above
+	// the signing certificate, counting the trusted certificate. For
+	// example, a signing certificate issued directly by a trusted root has a
+	// depth of 1.
+	MaxChainDepth int
+}
+
+// CertificatePolicyError is returned from VerifyTrusted when the certificate
+// of the signature, or a certificate of its chain, does not satisfy the
+// CertificatePolicy in SignatureOptions.
+type CertificatePolicyError struct {
+	// Rule names the rule of the policy that failed: "certificate",
+	// "chain", "key usage", "extended key usage", "key size" or "chain
+	// depth".
+	Rule string
+
+	// Certificate is the certificate that does not satisfy the rule. It is
+	// nil if the signature was not verified with a certificate.
+	Certificate *x509.Certificate
+
+	// Reason describes why the certificate does not satisfy the rule.
+	Reason string
+}
+
+func (e CertificatePolicyError) Error() string {
+	if e.Certificate == nil {
+		return fmt.Sprintf("the signature does not satisfy the %s policy: %s", e.Rule, e.Reason)
+	}
+	return fmt.Sprintf("certificate %q does not satisfy the %s policy: %s",
+		e.Certificate.Subject.String(), e.Rule, e.Reason)
+}
+
+// check returns a CertificatePolicyError if the certificates of result, as
+// verified by VerifyTrusted, do not satisfy the policy.
+func (p *CertificatePolicy) check(result *VerificationResult) error {
+	leaf := result.Certificate
+	if leaf == nil {
+		return CertificatePolicyError{
+			Rule:   "certificate",
+			Reason: "the signature was not verified with a certificate",
+		}
+	}
+	if len(result.Chain) == 0 {
+		return CertificatePolicyError{
+			Rule:        "chain",
+			Certificate: leaf,
+			Reason:      "the chain to a trusted certificate is not known",
+		}
+	}
+
+	if p.RequireDigitalSignature && leaf.KeyUsage != 0 && leaf.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		return CertificatePolicyError{
+			Rule:        "key usage",
+			Certificate: leaf,
+			Reason:      "digitalSignature is not allowed",
+		}
+	}
+
+	for _, usage := range p.ExtKeyUsages {
+		if !hasExtKeyUsage(leaf, usage) {
+			return CertificatePolicyError{
+				Rule:        "extended key usage",
+				Certificate: leaf,
+				Reason:      fmt.Sprintf("extended key usage %s is missing", extKeyUsageName(usage)),
+			}
+		}
+	}
+
+	for _, cert := range result.Chain {
+		if err := p.checkKeySize(cert); err != nil {
+			return err
+		}
+	}
+
+	if depth := len(result.Chain) - 1; p.MaxChainDepth > 0 && depth > p.MaxChainDepth {
+		return CertificatePolicyError{
+			Rule:        "chain depth",
+			Certificate: leaf,
+			Reason:      fmt.Sprintf("the chain has a depth of %d, more than %d", depth, p.MaxChainDepth),
+		}
+	}
+	return nil
+}
+
+// checkKeySize returns a CertificatePolicyError if the key of cert is
+// smaller than the policy allows.
+func (p *CertificatePolicy) checkKeySize(cert *x509.Certificate) error {
+	switch publicKey := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if size := publicKey.N.BitLen(); size < p.MinRSAKeySize {
+			return CertificatePolicyError{
+				Rule:        "key size",
+				Certificate: cert,
+				Reason:      fmt.Sprintf("the RSA key has %d bits, less than %d", size, p.MinRSAKeySize),
+			}
+		}
+	case *ecdsa.PublicKey:
+		if size := publicKey.Curve.Params().BitSize; size < p.MinECDSAKeySize {
+			return CertificatePolicyError{
+				Rule:        "key size",
+				Certificate: cert,
+				Reason:      fmt.Sprintf("the ECDSA key has %d bits, less than %d", size, p.MinECDSAKeySize),
+			}
+		}
+	}
+	return nil
+}
+
+// hasExtKeyUsage reports whether cert may be used for usage.
+func hasExtKeyUsage(cert *x509.Certificate, usage x509.ExtKeyUsage) bool {
+	for _, u := range cert.ExtKeyUsage {
+		if u == usage || u == x509.ExtKeyUsageAny {
+			return true
+		}
+	}
+	return false
+}
+
+// extKeyUsageNames names the common extended key usages in errors.
+var extKeyUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageServerAuth:      "serverAuth",
+	x509.ExtKeyUsageClientAuth:      "clientAuth",
+	x509.ExtKeyUsageCodeSigning:     "codeSigning",
+	x509.ExtKeyUsageEmailProtection: "emailProtection",
+	x509.ExtKeyUsageTimeStamping:    "timeStamping",
+	x509.ExtKeyUsageOCSPSigning:     "OCSPSigning",
+}
+
+// extKeyUsageName returns the name of usage for errors.
+func extKeyUsageName(usage x509.ExtKeyUsage) string {
+	if name, ok := extKeyUsageNames[usage]; ok {
+		return name
+	}
+	return fmt.Sprintf("%d", usage)
+}
@@ -0,0 +1,134 @@
+package xmlsec
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+
+	. "gopkg.in/check.v1"
+)
+
+type CertificatePolicyTest struct {
+	Chain ChainTest
+}
+
+var _ = Suite(&CertificatePolicyTest{})
+
+func (testSuite *CertificatePolicyTest) SetUpSuite(c *C) {
+	testSuite.Chain.SetUpSuite(c)
+}
+
+func (testSuite *CertificatePolicyTest) SetUpTest(c *C) {
+	testSuite.Chain.SetUpTest(c)
+}
+
+// verify signs a document with the key of chain and verifies it with
+// policy.
+func (testSuite *CertificatePolicyTest) verify(c *C, chain *testChain, policy CertificatePolicy) error {
+	signed, err := Sign(chain.LeafKeyPEM, testSuite.Chain.DocStr, SignatureOptions{
+		Certificates: []*x509.Certificate{chain.Leaf, chain.Intermediate},
+	})
+	c.Assert(err, IsNil)
+	return VerifyTrusted([][]byte{chain.RootPEM}, signed, SignatureOptions{CertificatePolicy: &policy})
+}
+
+func (testSuite *CertificatePolicyTest) TestKeyUsage(c *C) {
+	err := testSuite.verify(c, testSuite.Chain.Chain, CertificatePolicy{RequireDigitalSignature: true})
+	c.Assert(err, IsNil)
+
+	chain := newTestChain(c, func(leaf *x509.Certificate) {
+		leaf.KeyUsage = x509.KeyUsageKeyEncipherment
+	})
+	err = testSuite.verify(c, chain, CertificatePolicy{})
+	c.Assert(err, IsNil)
+	err = testSuite.verify(c, chain, CertificatePolicy{RequireDigitalSignature: true})
+	c.Assert(err, ErrorMatches, `certificate "CN=go-xmlsec test signer" does not satisfy the key usage policy: digitalSignature is not allowed`)
+	c.Assert(err.(CertificatePolicyError).Certificate.Equal(chain.Leaf), Equals, true)
+}
+
+func (testSuite *CertificatePolicyTest) TestExtKeyUsage(c *C) {
+	policy := CertificatePolicy{ExtKeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection}}
+
+	err := testSuite.verify(c, testSuite.Chain.Chain, policy)
+	c.Assert(err, ErrorMatches, `.* extended key usage policy: extended key usage emailProtection is missing`)
+	c.Assert(err.(CertificatePolicyError).Rule, Equals, "extended key usage")
+
+	chain := newTestChain(c, func(leaf *x509.Certificate) {
+		leaf.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection}
+	})
+	err = testSuite.verify(c, chain, policy)
+	c.Assert(err, IsNil)
+}
+
+func (testSuite *CertificatePolicyTest) TestKeySize(c *C) {
+	chain := testSuite.Chain.Chain
+
+	err := testSuite.verify(c, chain, CertificatePolicy{MinRSAKeySize: 2048, MinECDSAKeySize: 384})
+	c.Assert(err, IsNil)
+
+	err = testSuite.verify(c, chain, CertificatePolicy{MinRSAKeySize: 3072})
+	c.Assert(err, ErrorMatches, `.* key size policy: the RSA key has 2048 bits, less than 3072`)
+	c.Assert(err.(CertificatePolicyError).Certificate.Equal(chain.Leaf), Equals, true)
+}
+
+func (testSuite *CertificatePolicyTest) TestChainDepth(c *C) {
+	chain := testSuite.Chain.Chain
+
+	err := testSuite.verify(c, chain, CertificatePolicy{MaxChainDepth: 2})
+	c.Assert(err, IsNil)
+
+	err = testSuite.verify(c, chain, CertificatePolicy{MaxChainDepth: 1})
+	c.Assert(err, ErrorMatches, `.* chain depth policy: the chain has a depth of 2, more than 1`)
+}
+
+func (testSuite *CertificatePolicyTest) TestSHA1Intermediate(c *C) {
+	chain := *testSuite.Chain.Chain
+	template := *chain.Intermediate
+	template.SignatureAlgorithm = x509.SHA1WithRSA
+	der, err := x509.CreateCertificate(rand.Reader, &template, chain.Root, chain.IntermediateKey.Public(), chain.RootKey)
+	c.Assert(err, IsNil)
+	chain.Intermediate, err = x509.ParseCertificate(der)
+	c.Assert(err, IsNil)
+
+	// crypto/x509 rejects this chain, but xmlsec accepts it, and so must
+	// the policy.
+	c.Assert(chain.Leaf.CheckSignatureFrom(chain.Intermediate), IsNil)
+	c.Assert(chain.Intermediate.CheckSignatureFrom(chain.Root), NotNil)
+	err = testSuite.verify(c, &chain, CertificatePolicy{})
+	c.Assert(err, IsNil)
+	err = testSuite.verify(c, &chain, CertificatePolicy{MaxChainDepth: 2})
+	c.Assert(err, IsNil)
+}
+
+func (testSuite *CertificatePolicyTest) TestWithoutCertificate(c *C) {
+	chain := testSuite.Chain.Chain
+	doc, err := InsertSignatureTemplate(testSuite.Chain.DocStr, SignatureTemplate{
+		SignatureMethod: "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256",
+		References: []ReferenceTemplate{{
+			DigestMethod: "http://www.w3.org/2001/04/xmlenc#sha256",
+			Transforms: []TransformTemplate{
+				{Algorithm: "http://www.w3.org/2000/09/xmldsig#enveloped-signature"},
+			},
+		}},
+		KeyInfo: &KeyInfoTemplate{KeyValue: true},
+	}, TemplateLocation{}, SignatureOptions{})
+	c.Assert(err, IsNil)
+	signed, err := Sign(chain.LeafKeyPEM, doc, SignatureOptions{})
+	c.Assert(err, IsNil)
+
+	// VerifyTrusted does not take the key from a KeyValue, with or
+	// without a policy
+	leafPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: chain.Leaf.Raw})
+	c.Assert(Verify(leafPEM, signed, SignatureOptions{}), IsNil)
+	for _, policy := range []*CertificatePolicy{nil, {MinRSAKeySize: 4096, MaxChainDepth: 1}} {
+		err = VerifyTrusted([][]byte{chain.RootPEM}, signed, SignatureOptions{CertificatePolicy: policy})
+		c.Assert(err, Equals, ErrVerificationFailed)
+	}
+
+	// and a policy rejects a result without a certificate or chain
+	policy := CertificatePolicy{}
+	err = policy.check(&VerificationResult{})
+	c.Assert(err, ErrorMatches, `the signature does not satisfy the certificate policy: the signature was not verified with a certificate`)
+	err = policy.check(&VerificationResult{Certificate: chain.Leaf})
+	c.Assert(err, ErrorMatches, `.* chain policy: the chain to a trusted certificate is not known`)
+}
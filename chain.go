@@ -0,0 +1,97 @@
+package xmlsec
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+)
+
+// maxChainLength bounds the length of the chains built by buildChain, which
+// protects against loops of certificates that issue each other.
+const maxChainLength = 16
+
+// buildChain sets the Chain of result, whose Certificate has been verified
+// using the certificates trusted and intermediates.
+func (result *VerificationResult) buildChain(trusted, intermediates []*x509.Certificate) {
+	if result.Certificate == nil {
+		return
+	}
+	intermediates = append(intermediates[:len(intermediates):len(intermediates)], result.keyInfoCertificates...)
+	result.Chain = buildChain(result.Certificate, trusted, intermediates)
+}
+
+// buildChain returns the chain of certificates from leaf to one of the
+// trusted certificates, starting with leaf, using the certificates in
+// intermediates to link them. It returns nil if there is no such chain.
+//
+// The chain has already been checked by xmlsec, so we only look for it
+// here; this is not a substitute for certificate verification.
+func buildChain(leaf *x509.Certificate, trusted, intermediates []*x509.Certificate) []*x509.Certificate {
+	chain := []*x509.Certificate{leaf}
+	for len(chain) <= maxChainLength {
+		cert := chain[len(chain)-1]
+		if containsCertificate(trusted, cert) {
+			return chain
+		}
+		issuer := findIssuer(cert, trusted)
+		if issuer == nil {
+			issuer = findIssuer(cert, intermediates)
+		}
+		if issuer == nil || issuer.Equal(cert) {
+			return nil
+		}
+		chain = append(chain, issuer)
+	}
+	return nil
+}
+
+// findIssuer returns the certificate of candidates that issued cert, or nil.
+//
+// We only check the signature itself, rather than using CheckSignatureFrom,
+// which also applies the policy of crypto/x509: it rejects SHA-1 signatures
+// and issuers without CA basic constraints that OpenSSL has accepted.
+func findIssuer(cert *x509.Certificate, candidates []*x509.Certificate) *x509.Certificate {
+	for _, candidate := range candidates {
+		if bytes.Equal(candidate.RawSubject, cert.RawIssuer) &&
+			candidate.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil {
+			return candidate
+		}
+	}
+	return nil
+}
+
+// containsCertificate reports whether certs contains cert.
+func containsCertificate(certs []*x509.Certificate, cert *x509.Certificate) bool {
+	for _, c := range certs {
+		if c.Equal(cert) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePEMCertificates returns the certificates of the PEM encoded
+// certificates certs, skipping those that cannot be parsed.
+func parsePEMCertificates(certs [][]byte) []*x509.Certificate {
+	var rv []*x509.Certificate
+	for _, cert := range certs {
+		if parsed := parsePEMCertificate(cert); parsed != nil {
+			rv = append(rv, parsed)
+		}
+	}
+	return rv
+}
+
+// parsePEMCertificate returns the PEM encoded certificate cert, or nil if it
+// cannot be parsed.
+func parsePEMCertificate(cert []byte) *x509.Certificate {
+	block, _ := pem.Decode(cert)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil
+	}
+	parsed, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil
+	}
+	return parsed
+}
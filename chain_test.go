@@ -0,0 +1,351 @@
+package xmlsec
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+// testChain is a certificate chain of a signing key, issued by an
+// intermediate CA which is issued by a root CA.
+type testChain struct {
+	RootKey         *rsa.PrivateKey
+	Root            *x509.Certificate
+	RootPEM         []byte
+	Intermediate    *x509.Certificate
+	IntermediateKey *rsa.PrivateKey
+	Leaf            *x509.Certificate
+	LeafKey         *rsa.PrivateKey
+	LeafKeyPEM      []byte
+}
+
+// newTestChain returns a new certificate chain. modify, if not nil, may
+// change the template of the leaf certificate before it is issued.
+func newTestChain(c *C, modify func(leaf *x509.Certificate)) *testChain {
+	newCert := func(template, parent *x509.Certificate, key *rsa.PrivateKey, parentKey *rsa.PrivateKey) *x509.Certificate {
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+		c.Assert(err, IsNil)
+		cert, err := x509.ParseCertificate(der)
+		c.Assert(err, IsNil)
+		return cert
+	}
+	newKey := func() *rsa.PrivateKey {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		c.Assert(err, IsNil)
+		return key
+	}
+
+	chain := &testChain{}
+	chain.RootKey = newKey()
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "go-xmlsec test root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	chain.Root = newCert(rootTemplate, rootTemplate, chain.RootKey, chain.RootKey)
+	chain.RootPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: chain.Root.Raw})
+
+	chain.IntermediateKey = newKey()
+	chain.Intermediate = newCert(&x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "go-xmlsec test intermediate"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}, chain.Root, chain.IntermediateKey, chain.RootKey)
+
+	chain.LeafKey = newKey()
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "go-xmlsec test signer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		SubjectKeyId: []byte{1, 2, 3, 4},
+	}
+	if modify != nil {
+		modify(leafTemplate)
+	}
+	chain.Leaf = newCert(leafTemplate, chain.Intermediate, chain.LeafKey, chain.IntermediateKey)
+	chain.LeafKeyPEM = pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(chain.LeafKey),
+	})
+	return chain
+}
+
+type ChainTest struct {
+	Chain  *testChain
+	DocStr []byte
+}
+
+var _ = Suite(&ChainTest{})
+
+func (testSuite *ChainTest) SetUpSuite(c *C) {
+	testSuite.Chain = newTestChain(c, nil)
+}
+
+func (testSuite *ChainTest) SetUpTest(c *C) {
+	doc := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Envelope xmlns="urn:envelope"><Data>Hello, World!</Data></Envelope>
+`)
+	var err error
+	testSuite.DocStr, err = InsertSignatureTemplate(doc, SignatureTemplate{
+		SignatureMethod: "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256",
+		References: []ReferenceTemplate{{
+			DigestMethod: "http://www.w3.org/2001/04/xmlenc#sha256",
+			Transforms: []TransformTemplate{
+				{Algorithm: "http://www.w3.org/2000/09/xmldsig#enveloped-signature"},
+			},
+		}},
+	}, TemplateLocation{}, SignatureOptions{})
+	c.Assert(err, IsNil)
+}
+
+func (testSuite *ChainTest) TestSignWithChain(c *C) {
+	chain := testSuite.Chain
+
+	// without the intermediate the signer cannot be trusted
+	signed, err := Sign(chain.LeafKeyPEM, testSuite.DocStr, SignatureOptions{
+		Certificates: []*x509.Certificate{chain.Leaf},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(strings.Count(string(signed), "<X509Certificate>"), Equals, 1)
+	err = VerifyTrusted([][]byte{chain.RootPEM}, signed, SignatureOptions{})
+	c.Assert(err, Equals, ErrVerificationFailed)
+
+	signed, err = Sign(chain.LeafKeyPEM, testSuite.DocStr, SignatureOptions{
+		Certificates: []*x509.Certificate{chain.Leaf, chain.Intermediate},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(strings.Count(string(signed), "<X509Certificate>"), Equals, 2)
+	c.Assert(strings.Contains(string(signed), "<X509SubjectName>"), Equals, false)
+
+	result, err := VerifyTrustedDetailed([][]byte{chain.RootPEM}, signed, SignatureOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(result.Certificate.Subject.CommonName, Equals, "go-xmlsec test signer")
+}
+
+func (testSuite *ChainTest) TestSignWithSignerAndChain(c *C) {
+	chain := testSuite.Chain
+
+	signed, err := SignWithSigner(chain.LeafKey, chain.Leaf, testSuite.DocStr, SignatureOptions{
+		Certificates: []*x509.Certificate{chain.Leaf, chain.Intermediate},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(strings.Count(string(signed), "<X509Certificate>"), Equals, 2)
+
+	err = VerifyTrusted([][]byte{chain.RootPEM}, signed, SignatureOptions{})
+	c.Assert(err, IsNil)
+}
+
+func (testSuite *ChainTest) TestVerifyTrustedChain(c *C) {
+	chain := testSuite.Chain
+	intermediatePEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: chain.Intermediate.Raw})
+	assertChain := func(result *VerificationResult) {
+		c.Assert(result.Certificate.Equal(chain.Leaf), Equals, true)
+		c.Assert(result.Chain, HasLen, 3)
+		c.Assert(result.Chain[0].Equal(chain.Leaf), Equals, true)
+		c.Assert(result.Chain[1].Equal(chain.Intermediate), Equals, true)
+		c.Assert(result.Chain[2].Equal(chain.Root), Equals, true)
+	}
+
+	signed, err := Sign(chain.LeafKeyPEM, testSuite.DocStr, SignatureOptions{
+		Certificates: []*x509.Certificate{chain.Leaf, chain.Intermediate},
+	})
+	c.Assert(err, IsNil)
+	result, err := VerifyTrustedDetailed([][]byte{chain.RootPEM}, signed, SignatureOptions{})
+	c.Assert(err, IsNil)
+	assertChain(result)
+
+	signed, err = Sign(chain.LeafKeyPEM, testSuite.DocStr, SignatureOptions{
+		Certificates: []*x509.Certificate{chain.Leaf},
+	})
+	c.Assert(err, IsNil)
+	result, err = VerifyTrustedDetailed([][]byte{chain.RootPEM}, signed, SignatureOptions{
+		Intermediates: [][]byte{intermediatePEM},
+	})
+	c.Assert(err, IsNil)
+	assertChain(result)
+
+	keyStore, err := NewKeyStore()
+	c.Assert(err, IsNil)
+	defer keyStore.Close()
+	c.Assert(keyStore.AddTrustedCertificate(chain.RootPEM), IsNil)
+	c.Assert(keyStore.AddIntermediateCertificate(intermediatePEM), IsNil)
+	result, err = VerifyTrustedDetailed(nil, signed, SignatureOptions{KeyStore: keyStore})
+	c.Assert(err, IsNil)
+	assertChain(result)
+}
+
+func (testSuite *ChainTest) TestIntermediates(c *C) {
+	chain := testSuite.Chain
+	intermediatePEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: chain.Intermediate.Raw})
+
+	signed, err := Sign(chain.LeafKeyPEM, testSuite.DocStr, SignatureOptions{
+		Certificates: []*x509.Certificate{chain.Leaf},
+	})
+	c.Assert(err, IsNil)
+
+	err = VerifyTrusted([][]byte{chain.RootPEM}, signed, SignatureOptions{
+		Intermediates: [][]byte{intermediatePEM},
+	})
+	c.Assert(err, IsNil)
+
+	// an intermediate does not make the chain trusted without its root
+	err = VerifyTrusted(nil, signed, SignatureOptions{
+		Intermediates: [][]byte{intermediatePEM},
+	})
+	c.Assert(err, Equals, ErrVerificationFailed)
+
+	keyStore, err := NewKeyStore()
+	c.Assert(err, IsNil)
+	defer keyStore.Close()
+	c.Assert(keyStore.AddIntermediateCertificate(intermediatePEM), IsNil)
+	c.Assert(VerifyTrusted(nil, signed, SignatureOptions{KeyStore: keyStore}), Equals, ErrVerificationFailed)
+	c.Assert(keyStore.AddTrustedCertificate(chain.RootPEM), IsNil)
+	c.Assert(VerifyTrusted(nil, signed, SignatureOptions{KeyStore: keyStore}), IsNil)
+}
+
+func (testSuite *ChainTest) TestX509DataOptions(c *C) {
+	chain := testSuite.Chain
+
+	signed, err := Sign(chain.LeafKeyPEM, testSuite.DocStr, SignatureOptions{
+		Certificates: []*x509.Certificate{chain.Leaf, chain.Intermediate},
+		X509Data: X509DataOptions{
+			IssuerSerial: true,
+			SubjectName:  true,
+			SKI:          true,
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(strings.Count(string(signed), "<X509Certificate>"), Equals, 2)
+	c.Assert(strings.Count(string(signed), "<X509SubjectName>"), Equals, 1)
+	c.Assert(strings.Count(string(signed), "<X509IssuerSerial>"), Equals, 1)
+	c.Assert(strings.Count(string(signed), "<X509SKI>"), Equals, 1)
+	c.Assert(strings.Contains(string(signed), "<X509SubjectName>CN=go-xmlsec test signer</X509SubjectName>"), Equals, true)
+	c.Assert(strings.Contains(string(signed), "<X509IssuerName>CN=go-xmlsec test intermediate</X509IssuerName>"), Equals, true)
+	c.Assert(strings.Contains(string(signed), "<X509SerialNumber>3</X509SerialNumber>"), Equals, true)
+	c.Assert(strings.Contains(string(signed), "<X509SKI>AQIDBA==</X509SKI>"), Equals, true)
+
+	err = VerifyTrusted([][]byte{chain.RootPEM}, signed, SignatureOptions{})
+	c.Assert(err, IsNil)
+
+	withoutSKI := newTestChain(c, func(leaf *x509.Certificate) { leaf.SubjectKeyId = nil })
+	_, err = Sign(withoutSKI.LeafKeyPEM, testSuite.DocStr, SignatureOptions{
+		Certificates: []*x509.Certificate{withoutSKI.Leaf},
+		X509Data:     X509DataOptions{SKI: true},
+	})
+	c.Assert(err, ErrorMatches, "certificate does not have a subject key identifier")
+}
+
+// newCRL returns a DER encoded CRL issued by issuer that revokes the
+// certificates with the serial numbers serials.
+func newCRL(c *C, issuer *x509.Certificate, issuerKey crypto.Signer, serials ...*big.Int) []byte {
+	var entries []x509.RevocationListEntry
+	for _, serial := range serials {
+		entries = append(entries, x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: time.Now().Add(-time.Minute),
+		})
+	}
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(1),
+		ThisUpdate:                time.Now().Add(-time.Hour),
+		NextUpdate:                time.Now().Add(time.Hour),
+		RevokedCertificateEntries: entries,
+	}, issuer, issuerKey)
+	c.Assert(err, IsNil)
+	return crl
+}
+
+func (testSuite *ChainTest) TestCRL(c *C) {
+	chain := testSuite.Chain
+	signed, err := Sign(chain.LeafKeyPEM, testSuite.DocStr, SignatureOptions{
+		Certificates: []*x509.Certificate{chain.Leaf, chain.Intermediate},
+	})
+	c.Assert(err, IsNil)
+	roots := [][]byte{chain.RootPEM}
+
+	otherCRL := newCRL(c, chain.Intermediate, chain.IntermediateKey, big.NewInt(42))
+	err = VerifyTrusted(roots, signed, SignatureOptions{CRLs: [][]byte{otherCRL}})
+	c.Assert(err, IsNil)
+
+	crl := newCRL(c, chain.Intermediate, chain.IntermediateKey, big.NewInt(42), chain.Leaf.SerialNumber)
+	crlPEM := pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crl})
+	for _, crl := range [][]byte{crl, crlPEM} {
+		err = VerifyTrusted(roots, signed, SignatureOptions{CRLs: [][]byte{crl}})
+		c.Assert(err, DeepEquals, CertificateRevokedError{
+			Issuer:       "CN=go-xmlsec test intermediate",
+			SerialNumber: big.NewInt(3),
+		})
+		c.Assert(err, ErrorMatches, "certificate 3 issued by CN=go-xmlsec test intermediate is revoked")
+	}
+
+	keyStore, err := NewKeyStore()
+	c.Assert(err, IsNil)
+	defer keyStore.Close()
+	c.Assert(keyStore.AddTrustedCertificate(chain.RootPEM), IsNil)
+	c.Assert(VerifyTrusted(nil, signed, SignatureOptions{KeyStore: keyStore}), IsNil)
+	c.Assert(keyStore.AddCRL(crlPEM), IsNil)
+	_, ok := VerifyTrusted(nil, signed, SignatureOptions{KeyStore: keyStore}).(CertificateRevokedError)
+	c.Assert(ok, Equals, true)
+
+	c.Assert(keyStore.AddCRL([]byte("not a CRL")), NotNil)
+}
+
+func (testSuite *ChainTest) TestEmbeddedCRL(c *C) {
+	chain := testSuite.Chain
+	signed, err := Sign(chain.LeafKeyPEM, testSuite.DocStr, SignatureOptions{
+		Certificates: []*x509.Certificate{chain.Leaf, chain.Intermediate},
+	})
+	c.Assert(err, IsNil)
+
+	// X509Data is not signed, so the CRL can be added after signing. xmlsec
+	// only accepts embedded CRLs issued by a trusted certificate.
+	crl := newCRL(c, chain.Root, chain.RootKey, chain.Intermediate.SerialNumber)
+	signed = []byte(strings.Replace(string(signed), "</X509Data>",
+		"<X509CRL>"+base64.StdEncoding.EncodeToString(crl)+"</X509CRL></X509Data>", 1))
+
+	err = VerifyTrusted([][]byte{chain.RootPEM}, signed, SignatureOptions{})
+	c.Assert(err, DeepEquals, CertificateRevokedError{
+		Issuer:       "CN=go-xmlsec test root",
+		SerialNumber: big.NewInt(2),
+	})
+}
+
+func (testSuite *ChainTest) TestVerificationTime(c *C) {
+	signedAt := time.Now().Add(-45 * time.Minute)
+	expired := newTestChain(c, func(leaf *x509.Certificate) {
+		leaf.NotAfter = time.Now().Add(-30 * time.Minute)
+	})
+	signed, err := Sign(expired.LeafKeyPEM, testSuite.DocStr, SignatureOptions{
+		Certificates: []*x509.Certificate{expired.Leaf, expired.Intermediate},
+	})
+	c.Assert(err, IsNil)
+	roots := [][]byte{expired.RootPEM}
+
+	err = VerifyTrusted(roots, signed, SignatureOptions{})
+	c.Assert(err, Equals, ErrVerificationFailed)
+
+	err = VerifyTrusted(roots, signed, SignatureOptions{VerificationTime: signedAt})
+	c.Assert(err, IsNil)
+
+	err = VerifyTrusted(roots, signed, SignatureOptions{VerificationTime: time.Now().Add(-2 * time.Hour)})
+	c.Assert(err, Equals, ErrVerificationFailed)
+}
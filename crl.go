@@ -0,0 +1,139 @@
+package xmlsec
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"unsafe"
+)
+
+// #include <xmlsec/xmlsec.h>
+// #include <xmlsec/keysmngr.h>
+// #include <xmlsec/xmltree.h>
+// #include <xmlsec/errors.h>
+// #include <xmlsec/openssl/x509.h>
+// #include <openssl/x509.h>
+//
+// // MY_xmlSecKeysMngrAdoptCrl adds the DER encoded CRL der to the X509
+// // certificates store of keysMngr.
+// static int MY_xmlSecKeysMngrAdoptCrl(xmlSecKeysMngrPtr keysMngr, const unsigned char *der, long size) {
+//   xmlSecKeyDataStorePtr store;
+//   X509_CRL *crl;
+//
+//   store = xmlSecKeysMngrGetDataStore(keysMngr, xmlSecOpenSSLX509StoreId);
+//   if (store == NULL) {
+//     xmlSecError(XMLSEC_ERRORS_HERE, NULL, "xmlSecKeysMngrGetDataStore",
+//       XMLSEC_ERRORS_R_XMLSEC_FAILED, XMLSEC_ERRORS_NO_MESSAGE);
+//     return -1;
+//   }
+//   crl = d2i_X509_CRL(NULL, &der, size);
+//   if (crl == NULL) {
+//     xmlSecError(XMLSEC_ERRORS_HERE, NULL, "d2i_X509_CRL",
+//       XMLSEC_ERRORS_R_CRYPTO_FAILED, XMLSEC_ERRORS_NO_MESSAGE);
+//     return -1;
+//   }
+//   if (xmlSecOpenSSLX509StoreAdoptCrl(store, crl) < 0) {
+//     X509_CRL_free(crl);
+//     return -1;
+//   }
+//   return 0;
+// }
+import "C"
+
+// CertificateRevokedError is returned from VerifyTrusted when the
+// certificate of the signature, or a certificate of its chain, is revoked
+// by one of the CRLs in SignatureOptions or in the signature.
+type CertificateRevokedError struct {
+	// Issuer is the distinguished name of the issuer of the CRL that
+	// revokes the certificate, if known.
+	Issuer string
+
+	// SerialNumber is the serial number of the revoked certificate, if
+	// known.
+	SerialNumber *big.Int
+}
+
+func (e CertificateRevokedError) Error() string {
+	if e.SerialNumber == nil {
+		return "certificate is revoked"
+	}
+	return fmt.Sprintf("certificate %s issued by %s is revoked", e.SerialNumber, e.Issuer)
+}
+
+// parseCRL returns the certificate revocation list crl, which may be PEM or
+// DER encoded.
+func parseCRL(crl []byte) (*x509.RevocationList, error) {
+	if block, _ := pem.Decode(crl); block != nil {
+		if block.Type != "X509 CRL" {
+			return nil, fmt.Errorf("unexpected PEM block %q in CRL", block.Type)
+		}
+		crl = block.Bytes
+	}
+	return x509.ParseRevocationList(crl)
+}
+
+// loadCRL adds the PEM or DER encoded certificate revocation list crl to the
+// certificates store of keysMngr, so that the certificates it revokes are
+// rejected.
+//
+// This function must be called between startProcessingXML() and
+// stopProcessingXML().
+func loadCRL(keysMngr *C.xmlSecKeysMngr, crl []byte) (*x509.RevocationList, error) {
+	revocationList, err := parseCRL(crl)
+	if err != nil {
+		return nil, err
+	}
+	der := revocationList.Raw
+	if rv := C.MY_xmlSecKeysMngrAdoptCrl(keysMngr,
+		(*C.uchar)(unsafe.Pointer(&der[0])),
+		C.long(len(der))); rv < 0 {
+		return nil, mustPopError()
+	}
+	return revocationList, nil
+}
+
+// revocationError returns the error for the signature in signatureNode when
+// xmlsec has found that its certificate is revoked. We look for the revoked
+// certificate ourselves to describe it.
+func revocationError(signatureNode *C.xmlNode, opts SignatureOptions) error {
+	var crls []*x509.RevocationList
+	for _, crl := range opts.CRLs {
+		if revocationList, err := parseCRL(crl); err == nil {
+			crls = append(crls, revocationList)
+		}
+	}
+	if opts.KeyStore != nil {
+		crls = append(crls, opts.KeyStore.crls...)
+	}
+
+	keyInfo := readKeyInfo(C.xmlSecFindChild(signatureNode,
+		(*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeKeyInfo)),
+		(*C.xmlChar)(unsafe.Pointer(&C.xmlSecDSigNs))))
+	crls = append(crls, keyInfo.CRLs...)
+	if err := revokedCertificate(keyInfo.Certificates, crls); err != nil {
+		return err
+	}
+	return CertificateRevokedError{}
+}
+
+// revokedCertificate returns a CertificateRevokedError for the first of
+// certs that is revoked by one of crls, or nil.
+func revokedCertificate(certs []*x509.Certificate, crls []*x509.RevocationList) error {
+	for _, cert := range certs {
+		for _, crl := range crls {
+			if string(crl.RawIssuer) != string(cert.RawIssuer) {
+				continue
+			}
+			for _, entry := range crl.RevokedCertificateEntries {
+				if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+					return CertificateRevokedError{
+						Issuer:       crl.Issuer.String(),
+						SerialNumber: cert.SerialNumber,
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
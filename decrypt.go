@@ -2,6 +2,7 @@ package xmlsec
 
 import (
 	"fmt"
+	"strings"
 	"unsafe"
 )
 
@@ -9,7 +10,11 @@ import (
 // #include <xmlsec/xmltree.h>
 // #include <xmlsec/xmlenc.h>
 // #include <xmlsec/templates.h>
+// #include <xmlsec/keyinfo.h>
 // #include <xmlsec/crypto.h>
+//
+// static inline xmlSecKeyDataId MY_xmlSecKeyDataNameId(void) { return xmlSecKeyDataNameId; }
+// static inline xmlSecKeyDataId MY_xmlSecKeyDataEncryptedKeyId(void) { return xmlSecKeyDataEncryptedKeyId; }
 import "C"
 
 // #include <libxml/parser.h>
@@ -17,60 +22,274 @@ import "C"
 // #include <libxml/xmlmemory.h>
 import "C"
 
+// DecryptOptions represents additional, less commonly used, options for
+// DecryptWithOptions.
+type DecryptOptions struct {
+	// Password is used to decrypt privateKey if it is encrypted. It is
+	// ignored for unencrypted keys.
+	Password string
+
+	// KeyStore, if not nil, holds the private keys used to decrypt the
+	// document, in which case the privateKey argument of
+	// DecryptWithOptions must be empty. The keys named by the KeyName
+	// elements of the document are tried first, then the other keys in
+	// the store.
+	KeyStore *KeyStore
+
+	// KeyResolver, if not nil, is asked for the private key that decrypts
+	// the document, in which case the privateKey argument may be empty.
+	// Keys it returns are decrypted with Password.
+	KeyResolver KeyResolver
+
+	// Parser configures the parser of the document to decrypt. The zero
+	// value is suitable for untrusted documents.
+	Parser ParserOptions
+}
+
+// DecryptionResult describes a document decrypted by DecryptDetailed.
+type DecryptionResult struct {
+	// Plaintext is the decrypted document.
+	Plaintext []byte
+
+	// KeyName is the name of the key in DecryptOptions.KeyStore that
+	// decrypted the document, if any.
+	KeyName string
+}
+
 // Decrypt finds the first encrypted part of doc, decrypts it using
 // privateKey and returns the plaintext of the embedded document.
 func Decrypt(privateKey []byte, doc []byte) ([]byte, error) {
+	return DecryptWithOptions(privateKey, doc, DecryptOptions{})
+}
+
+// DecryptWithOptions is like Decrypt but accepts additional options.
+//
+// If opts.KeyStore is set, privateKey must be empty and the keys in the
+// store are used to decrypt doc.
+//
+// privateKey may be a PKCS#1, SEC1 or PKCS#8 private key in either PEM or
+// DER form. If the key is encrypted, opts.Password must contain its password,
+// otherwise ErrIncorrectPassword is returned.
+func DecryptWithOptions(privateKey []byte, doc []byte, opts DecryptOptions) ([]byte, error) {
+	result, err := DecryptDetailed(privateKey, doc, opts)
+	if err != nil {
+		return nil, err
+	}
+	return result.Plaintext, nil
+}
+
+// DecryptDetailed is like DecryptWithOptions, and also reports which key of
+// opts.KeyStore decrypted the document.
+func DecryptDetailed(privateKey []byte, doc []byte, opts DecryptOptions) (*DecryptionResult, error) {
 	startProcessingXML()
 	defer stopProcessingXML()
 
-	keysMngr := C.xmlSecKeysMngrCreate()
-	if keysMngr == nil {
-		return nil, popError()
+	if opts.KeyStore != nil {
+		if len(privateKey) != 0 {
+			return nil, errKeyAndKeyStore
+		}
+		return decryptWithKeyStore(opts.KeyStore, doc, opts)
 	}
-	defer C.xmlSecKeysMngrDestroy(keysMngr)
 
-	if rv := C.xmlSecCryptoAppDefaultKeysMngrInit(keysMngr); rv < 0 {
-		return nil, popError()
+	if len(privateKey) == 0 && opts.KeyResolver != nil {
+		keysMngr, err := newKeysMngr()
+		if err != nil {
+			return nil, err
+		}
+		defer C.xmlSecKeysMngrDestroy(keysMngr)
+
+		plaintext, err := decryptWithKeysMngr(keysMngr, doc, opts)
+		if err != nil {
+			return nil, err
+		}
+		return &DecryptionResult{Plaintext: plaintext}, nil
 	}
 
-	key := C.xmlSecCryptoAppKeyLoadMemory(
-		(*C.xmlSecByte)(unsafe.Pointer(&privateKey[0])),
-		C.xmlSecSize(len(privateKey)),
-		C.xmlSecKeyDataFormatPem,
-		nil, nil, nil)
-	if key == nil {
-		return nil, popError()
+	key, err := loadPrivateKey(privateKey, opts.Password)
+	if err != nil {
+		return nil, err
 	}
 
+	plaintext, err := decryptWithKey(key, doc, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &DecryptionResult{Plaintext: plaintext}, nil
+}
+
+// DecryptPKCS12 is like Decrypt except that the private key is read from
+// pkcs12, a PKCS#12 (.p12 or .pfx) bundle protected by opts.Password.
+//
+// If the password is incorrect, ErrIncorrectPassword is returned. opts.KeyStore
+// and opts.KeyResolver must not be set.
+func DecryptPKCS12(pkcs12 []byte, doc []byte, opts DecryptOptions) ([]byte, error) {
+	startProcessingXML()
+	defer stopProcessingXML()
+
+	if opts.KeyStore != nil {
+		return nil, errKeyAndKeyStore
+	}
+	if opts.KeyResolver != nil {
+		return nil, errKeyAndKeyResolver
+	}
+
+	key, err := loadPKCS12(pkcs12, opts.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	return decryptWithKey(key, doc, opts)
+}
+
+// decryptWithKey decrypts doc using key. It takes ownership of key.
+func decryptWithKey(key *C.xmlSecKey, doc []byte, opts DecryptOptions) ([]byte, error) {
+	keysMngr, err := newKeysMngr()
+	if err != nil {
+		C.xmlSecKeyDestroy(key)
+		return nil, err
+	}
+	defer C.xmlSecKeysMngrDestroy(keysMngr)
+
 	if rv := C.xmlSecCryptoAppDefaultKeysMngrAdoptKey(keysMngr, key); rv < 0 {
+		C.xmlSecKeyDestroy(key)
 		return nil, popError()
 	}
 
-	parsedDoc, err := newDoc(doc, nil)
+	return decryptWithKeysMngr(keysMngr, doc, opts)
+}
+
+// decryptWithKeyStore decrypts doc using the keys in keyStore. xmlsec does
+// not tell us which key it used, and gives up after the first key that
+// matches, so we try each key on its own, starting with the keys named in
+// the document. The document is parsed once for all the attempts, since
+// xmlsec leaves it untouched when decryption fails.
+func decryptWithKeyStore(keyStore *KeyStore, doc []byte, opts DecryptOptions) (*DecryptionResult, error) {
+	keysMngr, err := keyStore.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer keyStore.release()
+
+	keys := storeKeys(keysMngr)
+	if len(keys) == 0 {
+		plaintext, err := decryptWithKeysMngr(keysMngr, doc, opts)
+		if err != nil {
+			return nil, err
+		}
+		return &DecryptionResult{Plaintext: plaintext}, nil
+	}
+
+	parsedDoc, encDataNode, err := parseEncryptedDoc(doc, opts)
 	if err != nil {
 		return nil, err
 	}
 	defer closeDoc(parsedDoc)
 
-	// create encryption context
-	encCtx := C.xmlSecEncCtxCreate(keysMngr)
-	if encCtx == nil {
-		return nil, popError()
+	var firstErr error
+	for _, key := range keysByName(keys, encryptedDataKeyNames(encDataNode)) {
+		err := decryptNode(keysMngr, encDataNode, opts, key)
+		if err == nil {
+			return &DecryptionResult{Plaintext: dumpDoc(parsedDoc), KeyName: getKeyName(key)}, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+// parseEncryptedDoc parses doc and finds its first EncryptedData element.
+// The caller must free the document with closeDoc.
+func parseEncryptedDoc(doc []byte, opts DecryptOptions) (*C.xmlDoc, *C.xmlNode, error) {
+	parsedDoc, err := newDoc(doc, nil, opts.Parser)
+	if err != nil {
+		return nil, nil, err
 	}
-	defer C.xmlSecEncCtxDestroy(encCtx)
 
 	encDataNode := C.xmlSecFindNode(C.xmlDocGetRootElement(parsedDoc),
 		(*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeEncryptedData)),
 		(*C.xmlChar)(unsafe.Pointer(&C.xmlSecEncNs)))
 	if encDataNode == nil {
-		return nil, fmt.Errorf("xmlSecFindNode cannot find EncryptedData node")
+		closeDoc(parsedDoc)
+		return nil, nil, fmt.Errorf("xmlSecFindNode cannot find EncryptedData node")
 	}
+	return parsedDoc, encDataNode, nil
+}
 
-	// decrypt the data
-	if rv := C.xmlSecEncCtxDecrypt(encCtx, encDataNode); rv < 0 {
-		return nil, popError()
+// encryptedDataKeyNames returns the contents of the KeyName elements in
+// encDataNode, including those of its EncryptedKey elements.
+func encryptedDataKeyNames(encDataNode *C.xmlNode) []string {
+	var names []string
+	var walk func(node *C.xmlNode)
+	walk = func(node *C.xmlNode) {
+		for cur := C.xmlSecGetNextElementNode(node); cur != nil; cur = C.xmlSecGetNextElementNode(cur.next) {
+			if C.xmlSecCheckNodeName(cur,
+				(*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeKeyName)),
+				(*C.xmlChar)(unsafe.Pointer(&C.xmlSecDSigNs))) == 1 {
+				names = append(names, strings.TrimSpace(getContent(cur)))
+				continue
+			}
+			walk(cur.children)
+		}
+	}
+	walk(encDataNode.children)
+	return names
+}
+
+// decryptWithKeysMngr decrypts doc using the keys in keysMngr.
+func decryptWithKeysMngr(keysMngr *C.xmlSecKeysMngr, doc []byte, opts DecryptOptions) ([]byte, error) {
+	parsedDoc, encDataNode, err := parseEncryptedDoc(doc, opts)
+	if err != nil {
+		return nil, err
 	}
-	encDataNode = nil // the template is inserted in the doc, so we don't own it
+	defer closeDoc(parsedDoc)
 
+	if err := decryptNode(keysMngr, encDataNode, opts, nil); err != nil {
+		return nil, err
+	}
 	return dumpDoc(parsedDoc), nil
 }
+
+// decryptNode replaces encDataNode with its plaintext. If key is not nil,
+// it is the only key of keysMngr that may decrypt encDataNode, and it
+// remains owned by the caller. Otherwise the key is found using the keys in
+// keysMngr.
+func decryptNode(keysMngr *C.xmlSecKeysMngr, encDataNode *C.xmlNode, opts DecryptOptions, key *C.xmlSecKey) error {
+	// create encryption context
+	encCtx := C.xmlSecEncCtxCreate(keysMngr)
+	if encCtx == nil {
+		return mustPopError()
+	}
+	defer C.xmlSecEncCtxDestroy(encCtx)
+
+	var resolverCall *keyResolverCall
+	if opts.KeyResolver != nil || key != nil {
+		resolverCall = attachKeyResolver(&encCtx.keyInfoReadCtx, opts.KeyResolver, func(key []byte) (*C.xmlSecKey, error) {
+			return loadPrivateKey(key, opts.Password)
+		})
+		defer resolverCall.detach()
+	}
+	// A key carried in the KeyInfo, such as an AESKeyValue, was chosen by
+	// whoever made the document, so it must never decrypt it, whether or
+	// not a KeyResolver is attached.
+	keyData := []C.xmlSecKeyDataId{C.MY_xmlSecKeyDataEncryptedKeyId()}
+	if key != nil {
+		// KeyName would find the other keys of keysMngr, so we ignore it.
+		resolverCall.key = key
+		resolverCall.exclusive = true
+	} else {
+		keyData = append(keyData, C.MY_xmlSecKeyDataNameId())
+	}
+	if err := enableKeyData(&encCtx.keyInfoReadCtx, keyData...); err != nil {
+		return err
+	}
+
+	// decrypt the data
+	if rv := C.xmlSecEncCtxDecrypt(encCtx, encDataNode); rv < 0 {
+		if resolverCall != nil && resolverCall.err != nil {
+			return resolverCall.err
+		}
+		return mustPopError()
+	}
+	return nil
+}
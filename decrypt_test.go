@@ -1,6 +1,10 @@
 package xmlsec
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
 	"strings"
 
 	. "gopkg.in/check.v1"
@@ -263,3 +267,34 @@ func (testSuite *DecryptTest) TestInvalid(c *C) {
 	_, err = Decrypt(testSuite.Key, docStr)
 	c.Assert(err, ErrorMatches, "func=xmlSecTransformNodeRead.*")
 }
+
+// selfKeyedEncryption returns a document encrypted with an AES key that is
+// carried in its own KeyInfo, as an attacker who knows no key of the
+// recipient might make.
+func selfKeyedEncryption(c *C) []byte {
+	key := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	for _, b := range [][]byte{key, iv} {
+		_, err := rand.Read(b)
+		c.Assert(err, IsNil)
+	}
+	plaintext := []byte("<Data>chosen by the attacker</Data>")
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	for i := 0; i < padding; i++ {
+		plaintext = append(plaintext, byte(padding))
+	}
+
+	block, err := aes.NewCipher(key)
+	c.Assert(err, IsNil)
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, plaintext)
+
+	return []byte(`<Envelope xmlns="urn:envelope">` +
+		`<EncryptedData xmlns="http://www.w3.org/2001/04/xmlenc#" Type="http://www.w3.org/2001/04/xmlenc#Element">` +
+		`<EncryptionMethod Algorithm="http://www.w3.org/2001/04/xmlenc#aes128-cbc"/>` +
+		`<KeyInfo xmlns="http://www.w3.org/2000/09/xmldsig#"><KeyValue>` +
+		`<AESKeyValue xmlns="http://www.aleksey.com/xmlsec/2002">` + base64.StdEncoding.EncodeToString(key) + `</AESKeyValue>` +
+		`</KeyValue></KeyInfo>` +
+		`<CipherData><CipherValue>` + base64.StdEncoding.EncodeToString(append(iv, ciphertext...)) + `</CipherValue></CipherData>` +
+		`</EncryptedData></Envelope>`)
+}
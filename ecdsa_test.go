@@ -0,0 +1,147 @@
+package xmlsec
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"encoding/xml"
+	"math/big"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type ECDSATest struct{}
+
+var _ = Suite(&ECDSATest{})
+
+// newECDSAKey returns a new PEM encoded ECDSA private key on curve and a self
+// signed certificate for it.
+func newECDSAKey(c *C, curve elliptic.Curve) (key []byte, cert []byte) {
+	privateKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+	c.Assert(err, IsNil)
+	return newSelfSignedKey(c, privateKey)
+}
+
+// newSelfSignedKey returns privateKey PEM encoded and a self signed
+// certificate for it.
+func newSelfSignedKey(c *C, privateKey crypto.Signer) (key []byte, cert []byte) {
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "go-xmlsec test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, privateKey.Public(), privateKey)
+	c.Assert(err, IsNil)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	c.Assert(err, IsNil)
+
+	key = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	cert = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	return key, cert
+}
+
+func (testSuite *ECDSATest) TestDefaultSignature(c *C) {
+	for _, tc := range []struct {
+		Curve           elliptic.Curve
+		SignatureMethod string
+		DigestMethod    string
+	}{
+		{elliptic.P256(), "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256", "http://www.w3.org/2001/04/xmlenc#sha256"},
+		{elliptic.P384(), "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha384", "http://www.w3.org/2001/04/xmldsig-more#sha384"},
+		{elliptic.P521(), "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha512", "http://www.w3.org/2001/04/xmlenc#sha512"},
+	} {
+		key, cert := newECDSAKey(c, tc.Curve)
+
+		sig := DefaultSignature(cert)
+		c.Assert(sig.SignatureMethod.Algorithm, Equals, tc.SignatureMethod)
+		c.Assert(sig.DigestMethod.Algorithm, Equals, tc.DigestMethod)
+
+		doc := struct {
+			XMLName   xml.Name `xml:"urn:envelope Envelope"`
+			Data      string   `xml:"Data"`
+			Signature Signature
+		}{Data: "Hello, World!", Signature: sig}
+		docStr, err := xml.Marshal(doc)
+		c.Assert(err, IsNil)
+
+		signed, err := Sign(key, docStr, SignatureOptions{})
+		c.Assert(err, IsNil)
+
+		err = Verify(cert, signed, SignatureOptions{AlgorithmPolicy: SecureAlgorithmPolicy()})
+		c.Assert(err, IsNil)
+
+		tampered := []byte(strings.Replace(string(signed), "Hello", "Goodbye", 1))
+		err = Verify(cert, tampered, SignatureOptions{})
+		c.Assert(err, Equals, ErrVerificationFailed)
+	}
+}
+
+func (testSuite *ECDSATest) TestSignatureTemplate(c *C) {
+	key, cert := newECDSAKey(c, elliptic.P384())
+	doc := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Envelope xmlns="urn:envelope"><Data>Hello, World!</Data></Envelope>
+`)
+	tmpl := SignatureTemplate{
+		SignatureMethod: "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha384",
+		References: []ReferenceTemplate{{
+			DigestMethod: "http://www.w3.org/2001/04/xmldsig-more#sha384",
+			Transforms: []TransformTemplate{
+				{Algorithm: "http://www.w3.org/2000/09/xmldsig#enveloped-signature"},
+			},
+		}},
+		KeyInfo: &KeyInfoTemplate{KeyValue: true},
+	}
+	docWithTemplate, err := InsertSignatureTemplate(doc, tmpl, TemplateLocation{}, SignatureOptions{})
+	c.Assert(err, IsNil)
+
+	signed, err := Sign(key, docWithTemplate, SignatureOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(string(signed), `<ECKeyValue xmlns="http://www.w3.org/2009/xmldsig11#">
+<NamedCurve URI="urn:oid:1.3.132.0.34"/>
+<PublicKey>`), Equals, true)
+
+	result, err := VerifyDetailed(cert, signed, SignatureOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(result.SignatureMethod, Equals, "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha384")
+	c.Assert(result.PublicKey, FitsTypeOf, &ecdsa.PublicKey{})
+}
+
+func (testSuite *ECDSATest) TestSignWithSignerKeyInfo(c *C) {
+	key, certPEM := newECDSAKey(c, elliptic.P256())
+	keyBlock, _ := pem.Decode(key)
+	privateKey, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	c.Assert(err, IsNil)
+	certBlock, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	c.Assert(err, IsNil)
+	doc := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Envelope xmlns="urn:envelope"><Data>Hello, World!</Data></Envelope>
+`)
+	tmpl := SignatureTemplate{
+		SignatureMethod: "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256",
+		References: []ReferenceTemplate{{
+			DigestMethod: "http://www.w3.org/2001/04/xmlenc#sha256",
+			Transforms: []TransformTemplate{
+				{Algorithm: "http://www.w3.org/2000/09/xmldsig#enveloped-signature"},
+			},
+		}},
+		KeyInfo: &KeyInfoTemplate{KeyValue: true, X509Data: true},
+	}
+	docWithTemplate, err := InsertSignatureTemplate(doc, tmpl, TemplateLocation{}, SignatureOptions{})
+	c.Assert(err, IsNil)
+
+	signed, err := SignWithSigner(privateKey.(crypto.Signer), cert, docWithTemplate, SignatureOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(string(signed), `<NamedCurve URI="urn:oid:1.2.840.10045.3.1.7"/>`), Equals, true)
+	c.Assert(strings.Contains(string(signed), "<X509Certificate>"), Equals, true)
+
+	err = Verify(certPEM, signed, SignatureOptions{})
+	c.Assert(err, IsNil)
+}
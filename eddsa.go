@@ -0,0 +1,614 @@
+package xmlsec
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"unsafe"
+)
+
+// xmlsec does not support EdDSA keys, so we provide a key data for Ed25519
+// and Ed448 keys and the signature methods of RFC 9231 that use them, backed
+// by OpenSSL. xmlsec also cannot extract EdDSA keys from the certificates in
+// a KeyInfo, so the keys manager is given a callback that does that for
+// EdDSA signatures.
+
+// #include <stdlib.h>
+// #include <string.h>
+// #include <xmlsec/xmlsec.h>
+// #include <xmlsec/xmltree.h>
+// #include <xmlsec/base64.h>
+// #include <xmlsec/keys.h>
+// #include <xmlsec/keysdata.h>
+// #include <xmlsec/keysmngr.h>
+// #include <xmlsec/keyinfo.h>
+// #include <xmlsec/transforms.h>
+// #include <xmlsec/errors.h>
+// #include <xmlsec/openssl/crypto.h>
+// #include <xmlsec/openssl/x509.h>
+// #include <openssl/err.h>
+// #include <openssl/evp.h>
+// #include <openssl/x509.h>
+//
+// #define MY_EDDSA_NS "http://www.w3.org/2021/04/xmldsig-more#"
+//
+// static int MY_eddsaError(const char *object, const char *subject, int reason, const char *msg) {
+//   ERR_clear_error();
+//   xmlSecError(XMLSEC_ERRORS_HERE, object, subject, reason, "%s", msg);
+//   return -1;
+// }
+//
+// static int MY_eddsaIsKey(EVP_PKEY *pKey) {
+//   return EVP_PKEY_id(pKey) == EVP_PKEY_ED25519 || EVP_PKEY_id(pKey) == EVP_PKEY_ED448;
+// }
+//
+// // MY_eddsaKeyDataCtx is the state of an EdDSA key data, which xmlsec
+// // allocates right after the xmlSecKeyData.
+// typedef struct {
+//   EVP_PKEY *pKey;
+// } MY_eddsaKeyDataCtx;
+//
+// #define MY_eddsaKeyDataGetCtx(data) \
+//   ((MY_eddsaKeyDataCtx*)(((xmlSecByte*)(data)) + sizeof(xmlSecKeyData)))
+//
+// static int MY_eddsaKeyDataInitialize(xmlSecKeyDataPtr data) {
+//   memset(MY_eddsaKeyDataGetCtx(data), 0, sizeof(MY_eddsaKeyDataCtx));
+//   return 0;
+// }
+//
+// static int MY_eddsaKeyDataDuplicate(xmlSecKeyDataPtr dst, xmlSecKeyDataPtr src) {
+//   EVP_PKEY *pKey = MY_eddsaKeyDataGetCtx(src)->pKey;
+//   if (pKey != NULL && EVP_PKEY_up_ref(pKey) != 1) {
+//     return MY_eddsaError("eddsa", "EVP_PKEY_up_ref", XMLSEC_ERRORS_R_CRYPTO_FAILED, "cannot copy key");
+//   }
+//   MY_eddsaKeyDataGetCtx(dst)->pKey = pKey;
+//   return 0;
+// }
+//
+// static void MY_eddsaKeyDataFinalize(xmlSecKeyDataPtr data) {
+//   MY_eddsaKeyDataCtx *ctx = MY_eddsaKeyDataGetCtx(data);
+//   if (ctx->pKey != NULL) {
+//     EVP_PKEY_free(ctx->pKey);
+//   }
+//   memset(ctx, 0, sizeof(MY_eddsaKeyDataCtx));
+// }
+//
+// static xmlSecKeyDataType MY_eddsaKeyDataGetType(xmlSecKeyDataPtr data) {
+//   EVP_PKEY *pKey = MY_eddsaKeyDataGetCtx(data)->pKey;
+//   size_t size = 0;
+//   if (pKey == NULL) {
+//     return xmlSecKeyDataTypeUnknown;
+//   }
+//   if (EVP_PKEY_get_raw_private_key(pKey, NULL, &size) == 1) {
+//     return xmlSecKeyDataTypePrivate | xmlSecKeyDataTypePublic;
+//   }
+//   ERR_clear_error();
+//   return xmlSecKeyDataTypePublic;
+// }
+//
+// static xmlSecSize MY_eddsaKeyDataGetSize(xmlSecKeyDataPtr data) {
+//   EVP_PKEY *pKey = MY_eddsaKeyDataGetCtx(data)->pKey;
+//   return pKey != NULL ? EVP_PKEY_bits(pKey) : 0;
+// }
+//
+// static struct _xmlSecKeyDataKlass MY_eddsaKeyDataKlass = {
+//   sizeof(xmlSecKeyDataKlass),
+//   sizeof(xmlSecKeyData) + sizeof(MY_eddsaKeyDataCtx),
+//   BAD_CAST "eddsa",
+//   xmlSecKeyDataUsageUnknown,
+//   NULL, NULL, NULL,
+//   MY_eddsaKeyDataInitialize,
+//   MY_eddsaKeyDataDuplicate,
+//   MY_eddsaKeyDataFinalize,
+//   NULL,
+//   MY_eddsaKeyDataGetType,
+//   MY_eddsaKeyDataGetSize,
+//   NULL,
+//   NULL, NULL, NULL, NULL,
+//   NULL, NULL,
+//   NULL, NULL,
+// };
+//
+// // MY_eddsaKeyDataGetEvp returns the OpenSSL key of data, or NULL if data
+// // is not an EdDSA key data.
+// EVP_PKEY* MY_eddsaKeyDataGetEvp(xmlSecKeyDataPtr data) {
+//   if (!xmlSecKeyDataCheckId(data, &MY_eddsaKeyDataKlass)) {
+//     return NULL;
+//   }
+//   return MY_eddsaKeyDataGetCtx(data)->pKey;
+// }
+//
+// // MY_eddsaKeyCreate returns a new key whose value is the EdDSA key pKey,
+// // or NULL. It takes ownership of pKey.
+// static xmlSecKeyPtr MY_eddsaKeyCreate(EVP_PKEY *pKey) {
+//   xmlSecKeyDataPtr data;
+//   xmlSecKeyPtr key;
+//   if (!MY_eddsaIsKey(pKey)) {
+//     EVP_PKEY_free(pKey);
+//     MY_eddsaError("eddsa", NULL, XMLSEC_ERRORS_R_INVALID_KEY_DATA, "not an EdDSA key");
+//     return NULL;
+//   }
+//   data = xmlSecKeyDataCreate(&MY_eddsaKeyDataKlass);
+//   if (data == NULL) {
+//     EVP_PKEY_free(pKey);
+//     return NULL;
+//   }
+//   MY_eddsaKeyDataGetCtx(data)->pKey = pKey;
+//   key = xmlSecKeyCreate();
+//   if (key == NULL) {
+//     xmlSecKeyDataDestroy(data);
+//     return NULL;
+//   }
+//   if (xmlSecKeySetValue(key, data) < 0) {
+//     xmlSecKeyDataDestroy(data);
+//     xmlSecKeyDestroy(key);
+//     return NULL;
+//   }
+//   return key;
+// }
+//
+// static xmlSecKeyPtr MY_eddsaKeyLoadPrivateKeyDER(const xmlSecByte *der, xmlSecSize size) {
+//   const unsigned char *p = der;
+//   EVP_PKEY *pKey = d2i_AutoPrivateKey(NULL, &p, (long)size);
+//   if (pKey == NULL) {
+//     MY_eddsaError("eddsa", "d2i_AutoPrivateKey", XMLSEC_ERRORS_R_CRYPTO_FAILED, "cannot read private key");
+//     return NULL;
+//   }
+//   return MY_eddsaKeyCreate(pKey);
+// }
+//
+// static xmlSecKeyPtr MY_eddsaKeyLoadCertDER(const xmlSecByte *der, xmlSecSize size) {
+//   const unsigned char *p = der;
+//   X509 *cert = d2i_X509(NULL, &p, (long)size);
+//   EVP_PKEY *pKey;
+//   if (cert == NULL) {
+//     MY_eddsaError("eddsa", "d2i_X509", XMLSEC_ERRORS_R_CRYPTO_FAILED, "cannot read certificate");
+//     return NULL;
+//   }
+//   pKey = X509_get_pubkey(cert);
+//   X509_free(cert);
+//   if (pKey == NULL) {
+//     MY_eddsaError("eddsa", "X509_get_pubkey", XMLSEC_ERRORS_R_CRYPTO_FAILED, "cannot read public key");
+//     return NULL;
+//   }
+//   return MY_eddsaKeyCreate(pKey);
+// }
+//
+// static xmlSecKeyPtr MY_eddsaKeyLoadPublicKeyDER(const xmlSecByte *der, xmlSecSize size) {
+//   const unsigned char *p = der;
+//   EVP_PKEY *pKey = d2i_PUBKEY(NULL, &p, (long)size);
+//   if (pKey == NULL) {
+//     MY_eddsaError("eddsa", "d2i_PUBKEY", XMLSEC_ERRORS_R_CRYPTO_FAILED, "cannot read public key");
+//     return NULL;
+//   }
+//   return MY_eddsaKeyCreate(pKey);
+// }
+//
+// // MY_eddsaMethod describes an EdDSA signature method.
+// typedef struct {
+//   const char *name;
+//   const char *href;
+//   int keyType;
+// } MY_eddsaMethod;
+//
+// static const MY_eddsaMethod MY_eddsaMethods[] = {
+//   { "eddsa-ed25519", MY_EDDSA_NS "eddsa-ed25519", EVP_PKEY_ED25519 },
+//   { "eddsa-ed448", MY_EDDSA_NS "eddsa-ed448", EVP_PKEY_ED448 },
+// };
+//
+// #define MY_EDDSA_METHODS_COUNT (sizeof(MY_eddsaMethods) / sizeof(MY_eddsaMethods[0]))
+//
+// // MY_eddsaCtx is the state of an EdDSA transform. EdDSA signs the whole
+// // message at once, so the transform collects its input in data.
+// typedef struct {
+//   EVP_PKEY *pKey;
+//   xmlSecBuffer data;
+// } MY_eddsaCtx;
+//
+// #define MY_eddsaGetCtx(transform) \
+//   ((MY_eddsaCtx*)(((xmlSecByte*)(transform)) + sizeof(xmlSecTransform)))
+//
+// static struct _xmlSecTransformKlass MY_eddsaKlasses[MY_EDDSA_METHODS_COUNT];
+//
+// static int MY_eddsaInitialize(xmlSecTransformPtr transform) {
+//   MY_eddsaCtx *ctx = MY_eddsaGetCtx(transform);
+//   memset(ctx, 0, sizeof(MY_eddsaCtx));
+//   return xmlSecBufferInitialize(&ctx->data, 0);
+// }
+//
+// static void MY_eddsaFinalize(xmlSecTransformPtr transform) {
+//   MY_eddsaCtx *ctx = MY_eddsaGetCtx(transform);
+//   if (ctx->pKey != NULL) {
+//     EVP_PKEY_free(ctx->pKey);
+//   }
+//   xmlSecBufferFinalize(&ctx->data);
+//   memset(ctx, 0, sizeof(MY_eddsaCtx));
+// }
+//
+// static int MY_eddsaSetKeyReq(xmlSecTransformPtr transform, xmlSecKeyReqPtr keyReq) {
+//   keyReq->keyId = &MY_eddsaKeyDataKlass;
+//   if (transform->operation == xmlSecTransformOperationSign) {
+//     keyReq->keyType = xmlSecKeyDataTypePrivate;
+//     keyReq->keyUsage = xmlSecKeyUsageSign;
+//   } else {
+//     keyReq->keyType = xmlSecKeyDataTypePublic;
+//     keyReq->keyUsage = xmlSecKeyUsageVerify;
+//   }
+//   return 0;
+// }
+//
+// static int MY_eddsaSetKey(xmlSecTransformPtr transform, xmlSecKeyPtr key) {
+//   MY_eddsaCtx *ctx = MY_eddsaGetCtx(transform);
+//   const char *name = (const char*)xmlSecTransformGetName(transform);
+//   EVP_PKEY *pKey = MY_eddsaKeyDataGetEvp(xmlSecKeyGetValue(key));
+//   if (pKey == NULL || EVP_PKEY_id(pKey) != MY_eddsaMethods[transform->id - MY_eddsaKlasses].keyType) {
+//     return MY_eddsaError(name, NULL, XMLSEC_ERRORS_R_INVALID_KEY_DATA, "key does not match the signature method");
+//   }
+//   if (EVP_PKEY_up_ref(pKey) != 1) {
+//     return MY_eddsaError(name, "EVP_PKEY_up_ref", XMLSEC_ERRORS_R_CRYPTO_FAILED, "cannot copy key");
+//   }
+//   if (ctx->pKey != NULL) {
+//     EVP_PKEY_free(ctx->pKey);
+//   }
+//   ctx->pKey = pKey;
+//   return 0;
+// }
+//
+// static int MY_eddsaExecute(xmlSecTransformPtr transform, int last, xmlSecTransformCtxPtr transformCtx) {
+//   MY_eddsaCtx *ctx = MY_eddsaGetCtx(transform);
+//   const char *name = (const char*)xmlSecTransformGetName(transform);
+//   xmlSecBufferPtr in = &(transform->inBuf);
+//   xmlSecBufferPtr out = &(transform->outBuf);
+//   xmlSecSize inSize = xmlSecBufferGetSize(in);
+//
+//   if (transform->status == xmlSecTransformStatusNone) {
+//     if (ctx->pKey == NULL) {
+//       return MY_eddsaError(name, NULL, XMLSEC_ERRORS_R_INVALID_TRANSFORM, "transform has no key");
+//     }
+//     transform->status = xmlSecTransformStatusWorking;
+//   }
+//   if (transform->status == xmlSecTransformStatusWorking && inSize > 0) {
+//     if (xmlSecBufferAppend(&ctx->data, xmlSecBufferGetData(in), inSize) < 0 ||
+//         xmlSecBufferRemoveHead(in, inSize) < 0) {
+//       return -1;
+//     }
+//   }
+//   if (transform->status == xmlSecTransformStatusWorking && last) {
+//     if (transform->operation == xmlSecTransformOperationSign) {
+//       EVP_MD_CTX *mdCtx = EVP_MD_CTX_new();
+//       size_t size = 0;
+//       int ok = mdCtx != NULL &&
+//         EVP_DigestSignInit(mdCtx, NULL, NULL, NULL, ctx->pKey) == 1 &&
+//         EVP_DigestSign(mdCtx, NULL, &size, xmlSecBufferGetData(&ctx->data), xmlSecBufferGetSize(&ctx->data)) == 1 &&
+//         xmlSecBufferSetMaxSize(out, size) >= 0 &&
+//         EVP_DigestSign(mdCtx, xmlSecBufferGetData(out), &size, xmlSecBufferGetData(&ctx->data), xmlSecBufferGetSize(&ctx->data)) == 1 &&
+//         xmlSecBufferSetSize(out, size) >= 0;
+//       if (mdCtx != NULL) {
+//         EVP_MD_CTX_free(mdCtx);
+//       }
+//       if (!ok) {
+//         return MY_eddsaError(name, "EVP_DigestSign", XMLSEC_ERRORS_R_CRYPTO_FAILED, "cannot sign data");
+//       }
+//     }
+//     transform->status = xmlSecTransformStatusFinished;
+//   }
+//   return 0;
+// }
+//
+// static int MY_eddsaVerify(xmlSecTransformPtr transform, const xmlSecByte *data, xmlSecSize dataSize, xmlSecTransformCtxPtr transformCtx) {
+//   MY_eddsaCtx *ctx = MY_eddsaGetCtx(transform);
+//   EVP_MD_CTX *mdCtx;
+//   if (transform->operation != xmlSecTransformOperationVerify ||
+//       transform->status != xmlSecTransformStatusFinished) {
+//     return MY_eddsaError((const char*)xmlSecTransformGetName(transform), NULL,
+//       XMLSEC_ERRORS_R_INVALID_STATUS, "transform is not finished");
+//   }
+//   mdCtx = EVP_MD_CTX_new();
+//   if (mdCtx != NULL &&
+//       EVP_DigestVerifyInit(mdCtx, NULL, NULL, NULL, ctx->pKey) == 1 &&
+//       EVP_DigestVerify(mdCtx, data, dataSize, xmlSecBufferGetData(&ctx->data), xmlSecBufferGetSize(&ctx->data)) == 1) {
+//     transform->status = xmlSecTransformStatusOk;
+//   } else {
+//     ERR_clear_error();
+//     transform->status = xmlSecTransformStatusFail;
+//   }
+//   if (mdCtx != NULL) {
+//     EVP_MD_CTX_free(mdCtx);
+//   }
+//   return 0;
+// }
+//
+// // MY_eddsaRegister registers the EdDSA signature methods that xmlsec does
+// // not already provide.
+// static int MY_eddsaRegister(void) {
+//   size_t i;
+//   for (i = 0; i < MY_EDDSA_METHODS_COUNT; i++) {
+//     struct _xmlSecTransformKlass *klass = &MY_eddsaKlasses[i];
+//     if (xmlSecTransformIdListFindByHref(xmlSecTransformIdsGet(), BAD_CAST MY_eddsaMethods[i].href,
+//         xmlSecTransformUsageSignatureMethod) != xmlSecTransformIdUnknown) {
+//       continue;
+//     }
+//     memset(klass, 0, sizeof(*klass));
+//     klass->klassSize = sizeof(xmlSecTransformKlass);
+//     klass->objSize = sizeof(xmlSecTransform) + sizeof(MY_eddsaCtx);
+//     klass->name = BAD_CAST MY_eddsaMethods[i].name;
+//     klass->href = BAD_CAST MY_eddsaMethods[i].href;
+//     klass->usage = xmlSecTransformUsageSignatureMethod;
+//     klass->initialize = MY_eddsaInitialize;
+//     klass->finalize = MY_eddsaFinalize;
+//     klass->setKeyReq = MY_eddsaSetKeyReq;
+//     klass->setKey = MY_eddsaSetKey;
+//     klass->verify = MY_eddsaVerify;
+//     klass->getDataType = xmlSecTransformDefaultGetDataType;
+//     klass->pushBin = xmlSecTransformDefaultPushBin;
+//     klass->popBin = xmlSecTransformDefaultPopBin;
+//     klass->execute = MY_eddsaExecute;
+//     if (xmlSecTransformIdsRegister(klass) < 0) {
+//       return -1;
+//     }
+//   }
+//   return 0;
+// }
+//
+// static X509* MY_eddsaReadCert(xmlNodePtr node) {
+//   xmlChar *content = xmlNodeGetContent(node);
+//   xmlSecSize size = 0;
+//   const unsigned char *p;
+//   X509 *cert = NULL;
+//   if (content == NULL) {
+//     return NULL;
+//   }
+//   if (xmlSecBase64DecodeInPlace(content, &size) == 0) {
+//     p = content;
+//     cert = d2i_X509(NULL, &p, (long)size);
+//   }
+//   xmlFree(content);
+//   ERR_clear_error();
+//   return cert;
+// }
+//
+// static X509_CRL* MY_eddsaReadCRL(xmlNodePtr node) {
+//   xmlChar *content = xmlNodeGetContent(node);
+//   xmlSecSize size = 0;
+//   const unsigned char *p;
+//   X509_CRL *crl = NULL;
+//   if (content == NULL) {
+//     return NULL;
+//   }
+//   if (xmlSecBase64DecodeInPlace(content, &size) == 0) {
+//     p = content;
+//     crl = d2i_X509_CRL(NULL, &p, (long)size);
+//   }
+//   xmlFree(content);
+//   ERR_clear_error();
+//   return crl;
+// }
+//
+// // MY_eddsaKeyFromX509Data returns the EdDSA key of the certificate in the
+// // X509Data of keyInfoNode that the trusted certificates of the keys
+// // manager vouch for, or NULL. Like xmlsec, it checks the certificates
+// // against the CRLs of the X509Data as well as those of the keys manager.
+// static xmlSecKeyPtr MY_eddsaKeyFromX509Data(xmlNodePtr keyInfoNode, xmlSecKeyInfoCtxPtr keyInfoCtx) {
+//   xmlSecKeyDataStorePtr store = xmlSecKeysMngrGetDataStore(keyInfoCtx->keysMngr, xmlSecOpenSSLX509StoreId);
+//   STACK_OF(X509) *certs;
+//   STACK_OF(X509_CRL) *crls;
+//   xmlNodePtr x509DataNode, certNode;
+//   X509 *cert;
+//   X509_CRL *crl;
+//   EVP_PKEY *pKey;
+//   xmlSecKeyPtr key = NULL;
+//   xmlSecKeyDataPtr x509Data;
+//
+//   if (store == NULL || (certs = sk_X509_new_null()) == NULL) {
+//     return NULL;
+//   }
+//   if ((crls = sk_X509_CRL_new_null()) == NULL) {
+//     sk_X509_free(certs);
+//     return NULL;
+//   }
+//   for (x509DataNode = xmlSecGetNextElementNode(keyInfoNode->children); x509DataNode != NULL;
+//       x509DataNode = xmlSecGetNextElementNode(x509DataNode->next)) {
+//     if (!xmlSecCheckNodeName(x509DataNode, xmlSecNodeX509Data, xmlSecDSigNs)) {
+//       continue;
+//     }
+//     for (certNode = xmlSecGetNextElementNode(x509DataNode->children); certNode != NULL;
+//         certNode = xmlSecGetNextElementNode(certNode->next)) {
+//       if (xmlSecCheckNodeName(certNode, xmlSecNodeX509Certificate, xmlSecDSigNs)) {
+//         if ((cert = MY_eddsaReadCert(certNode)) != NULL && sk_X509_push(certs, cert) <= 0) {
+//           X509_free(cert);
+//         }
+//       } else if (xmlSecCheckNodeName(certNode, xmlSecNodeX509CRL, xmlSecDSigNs)) {
+//         if ((crl = MY_eddsaReadCRL(certNode)) != NULL && sk_X509_CRL_push(crls, crl) <= 0) {
+//           X509_CRL_free(crl);
+//         }
+//       }
+//     }
+//   }
+//
+//   if (sk_X509_num(certs) > 0 &&
+//       (cert = xmlSecOpenSSLX509StoreVerify(store, certs, crls, keyInfoCtx)) != NULL &&
+//       (pKey = X509_get_pubkey(cert)) != NULL) {
+//     if (MY_eddsaIsKey(pKey)) {
+//       key = MY_eddsaKeyCreate(pKey);
+//     } else {
+//       EVP_PKEY_free(pKey);
+//     }
+//     if (key != NULL) {
+//       X509 *keyCert = X509_dup(cert);
+//       x509Data = xmlSecKeyEnsureData(key, xmlSecOpenSSLKeyDataX509Id);
+//       if (keyCert == NULL || x509Data == NULL ||
+//           xmlSecOpenSSLKeyDataX509AdoptKeyCert(x509Data, keyCert) < 0) {
+//         if (keyCert != NULL) {
+//           X509_free(keyCert);
+//         }
+//         xmlSecKeyDestroy(key);
+//         key = NULL;
+//       } else if (xmlSecKeyReqMatchKey(&(keyInfoCtx->keyReq), key) != 1) {
+//         xmlSecKeyDestroy(key);
+//         key = NULL;
+//       }
+//     }
+//   }
+//   sk_X509_pop_free(certs, X509_free);
+//   sk_X509_CRL_pop_free(crls, X509_CRL_free);
+//   return key;
+// }
+//
+// // MY_eddsaGetKey is the keys manager callback that finds the key for a
+// // signature. xmlsec fails when the certificate it would take the key from
+// // holds an EdDSA key, so we handle those ourselves.
+// xmlSecKeyPtr MY_eddsaGetKey(xmlNodePtr keyInfoNode, xmlSecKeyInfoCtxPtr keyInfoCtx) {
+//   if (keyInfoNode != NULL && keyInfoCtx->keyReq.keyId == &MY_eddsaKeyDataKlass) {
+//     xmlSecKeyPtr key = MY_eddsaKeyFromX509Data(keyInfoNode, keyInfoCtx);
+//     if (key != NULL) {
+//       return key;
+//     }
+//   }
+//   return xmlSecKeysMngrGetKey(keyInfoNode, keyInfoCtx);
+// }
+//
+// // MY_eddsaGetKeyFromKeyInfo is like MY_eddsaGetKey, except that it only
+// // uses the contents of keyInfoNode, and never falls back to the keys held
+// // by the keys manager like xmlSecKeysMngrGetKey does.
+// xmlSecKeyPtr MY_eddsaGetKeyFromKeyInfo(xmlNodePtr keyInfoNode, xmlSecKeyInfoCtxPtr keyInfoCtx) {
+//   xmlSecKeyPtr key;
+//   if (keyInfoNode == NULL) {
+//     return NULL;
+//   }
+//   if (keyInfoCtx->keyReq.keyId == &MY_eddsaKeyDataKlass) {
+//     return MY_eddsaKeyFromX509Data(keyInfoNode, keyInfoCtx);
+//   }
+//   if ((key = xmlSecKeyCreate()) == NULL) {
+//     return NULL;
+//   }
+//   if (xmlSecKeyInfoNodeRead(keyInfoNode, key, keyInfoCtx) < 0 ||
+//       xmlSecKeyGetValue(key) == NULL ||
+//       xmlSecKeyMatch(key, NULL, &(keyInfoCtx->keyReq)) != 1) {
+//     xmlSecKeyDestroy(key);
+//     return NULL;
+//   }
+//   return key;
+// }
+//
+// static void MY_eddsaInitKeysMngr(xmlSecKeysMngrPtr keysMngr) {
+//   keysMngr->getKey = MY_eddsaGetKey;
+// }
+import "C"
+
+// #include <libxml/tree.h>
+import "C"
+
+// the object identifiers of EdDSA keys, from RFC 8410
+var (
+	oidEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+	oidEd448   = asn1.ObjectIdentifier{1, 3, 101, 113}
+)
+
+// registerEdDSATransforms makes the EdDSA signature methods available to
+// xmlsec. It must be called after xmlsec is initialized.
+func registerEdDSATransforms() error {
+	if rv := C.MY_eddsaRegister(); rv < 0 {
+		return mustPopError()
+	}
+	return nil
+}
+
+// isEdDSAAlgorithm reports whether algorithm identifies an EdDSA key.
+func isEdDSAAlgorithm(algorithm pkix.AlgorithmIdentifier) bool {
+	return algorithm.Algorithm.Equal(oidEd25519) || algorithm.Algorithm.Equal(oidEd448)
+}
+
+// edDSAPrivateKeyDER returns the PKCS#8 encoding of key if it is an
+// unencrypted Ed25519 or Ed448 private key in PEM or DER form, or nil.
+func edDSAPrivateKeyDER(key []byte) []byte {
+	der := key
+	if block, _ := pem.Decode(key); block != nil {
+		if block.Type != "PRIVATE KEY" {
+			return nil
+		}
+		der = block.Bytes
+	}
+
+	// PrivateKeyInfo from RFC 5208, section 5
+	var privateKeyInfo struct {
+		Version    int
+		Algorithm  pkix.AlgorithmIdentifier
+		PrivateKey []byte
+	}
+	if _, err := asn1.Unmarshal(der, &privateKeyInfo); err != nil || !isEdDSAAlgorithm(privateKeyInfo.Algorithm) {
+		return nil
+	}
+	return der
+}
+
+// isEdDSACertificate reports whether the DER encoded certificate der holds
+// an Ed25519 or Ed448 key.
+func isEdDSACertificate(der []byte) bool {
+	// Certificate from RFC 5280, section 4.1
+	var certificate struct {
+		TBSCertificate struct {
+			Version              asn1.RawValue `asn1:"optional,explicit,tag:0"`
+			SerialNumber         asn1.RawValue
+			Signature            asn1.RawValue
+			Issuer               asn1.RawValue
+			Validity             asn1.RawValue
+			Subject              asn1.RawValue
+			SubjectPublicKeyInfo subjectPublicKeyInfo
+		}
+	}
+	_, err := asn1.Unmarshal(der, &certificate)
+	return err == nil && isEdDSAAlgorithm(certificate.TBSCertificate.SubjectPublicKeyInfo.Algorithm)
+}
+
+// isEdDSAPublicKey reports whether the DER encoded SubjectPublicKeyInfo der
+// is an Ed25519 or Ed448 key.
+func isEdDSAPublicKey(der []byte) bool {
+	var publicKeyInfo subjectPublicKeyInfo
+	_, err := asn1.Unmarshal(der, &publicKeyInfo)
+	return err == nil && isEdDSAAlgorithm(publicKeyInfo.Algorithm)
+}
+
+// loadEdDSAPrivateKey returns an xmlsec key for the PKCS#8 encoded EdDSA
+// private key der. The caller owns the returned key.
+//
+// This function must be called between startProcessingXML() and
+// stopProcessingXML().
+func loadEdDSAPrivateKey(der []byte) (*C.xmlSecKey, error) {
+	key := C.MY_eddsaKeyLoadPrivateKeyDER((*C.xmlSecByte)(unsafe.Pointer(&der[0])), C.xmlSecSize(len(der)))
+	if key == nil {
+		return nil, mustPopError()
+	}
+	return key, nil
+}
+
+// loadEdDSACertificate returns an xmlsec key for the public key of the DER
+// encoded certificate der. The caller owns the returned key.
+//
+// This function must be called between startProcessingXML() and
+// stopProcessingXML().
+func loadEdDSACertificate(der []byte) (*C.xmlSecKey, error) {
+	key := C.MY_eddsaKeyLoadCertDER((*C.xmlSecByte)(unsafe.Pointer(&der[0])), C.xmlSecSize(len(der)))
+	if key == nil {
+		return nil, mustPopError()
+	}
+	return key, nil
+}
+
+// loadEdDSAPublicKey returns an xmlsec key for the DER encoded EdDSA
+// SubjectPublicKeyInfo der. The caller owns the returned key.
+//
+// This function must be called between startProcessingXML() and
+// stopProcessingXML().
+func loadEdDSAPublicKey(der []byte) (*C.xmlSecKey, error) {
+	key := C.MY_eddsaKeyLoadPublicKeyDER((*C.xmlSecByte)(unsafe.Pointer(&der[0])), C.xmlSecSize(len(der)))
+	if key == nil {
+		return nil, mustPopError()
+	}
+	return key, nil
+}
+
+// initEdDSAKeysMngr makes keysMngr able to find EdDSA keys in the
+// certificates of a KeyInfo.
+func initEdDSAKeysMngr(keysMngr *C.xmlSecKeysMngr) {
+	C.MY_eddsaInitKeysMngr(keysMngr)
+}
@@ -1,5 +1,6 @@
 package xmlsec
 
+// #include <stdlib.h>
 // #include <xmlsec/xmlsec.h>
 // #include <xmlsec/xmltree.h>
 // #include <xmlsec/xmlenc.h>
@@ -83,6 +84,18 @@ type EncryptOptions struct {
 	SessionCipher   SessionCipherType
 	Cipher          CipherType
 	DigestAlgorithm DigestAlgorithmType
+
+	// KeyStore, if not nil, holds the key that the document is encrypted
+	// to, in which case the publicKey argument of Encrypt must be empty.
+	KeyStore *KeyStore
+
+	// KeyName selects the key in KeyStore by name, and is written to a
+	// KeyName element of the EncryptedKey. If empty, the first suitable key
+	// in KeyStore is used.
+	KeyName string
+
+	// Parser configures the parser of the document to encrypt.
+	Parser ParserOptions
 }
 
 var errInvalidAlgorithm = errors.New("invalid algorithm")
@@ -103,42 +116,51 @@ var (
 
 // Encrypt encrypts the XML document to publicKey and returns the encrypted
 // document.
+//
+// If opts.KeyStore is set, publicKey must be empty and the document is
+// encrypted to a key from the store.
 func Encrypt(publicKey, doc []byte, opts EncryptOptions) ([]byte, error) {
 	startProcessingXML()
 	defer stopProcessingXML()
 
-	keysMngr := C.xmlSecKeysMngrCreate()
-	if keysMngr == nil {
-		return nil, mustPopError()
+	if opts.KeyStore != nil {
+		if len(publicKey) != 0 {
+			return nil, errKeyAndKeyStore
+		}
+		keysMngr, err := opts.KeyStore.acquire()
+		if err != nil {
+			return nil, err
+		}
+		defer opts.KeyStore.release()
+		return encryptWithKeysMngr(keysMngr, doc, opts)
 	}
-	defer C.xmlSecKeysMngrDestroy(keysMngr)
 
-	if rv := C.xmlSecCryptoAppDefaultKeysMngrInit(keysMngr); rv < 0 {
-		return nil, mustPopError()
+	keysMngr, err := newKeysMngr()
+	if err != nil {
+		return nil, err
 	}
+	defer C.xmlSecKeysMngrDestroy(keysMngr)
 
-	key := C.xmlSecCryptoAppKeyLoadMemory(
-		(*C.xmlSecByte)(unsafe.Pointer(&publicKey[0])),
-		C.xmlSecSize(len(publicKey)),
-		C.xmlSecKeyDataFormatCertPem,
-		nil, nil, nil)
-	if key == nil {
-		return nil, mustPopError()
+	key, err := loadPublicKey(publicKey)
+	if err != nil {
+		return nil, err
 	}
-
-	if rv := C.xmlSecCryptoAppKeyCertLoadMemory(key,
-		(*C.xmlSecByte)(unsafe.Pointer(&publicKey[0])),
-		C.xmlSecSize(len(publicKey)),
-		C.xmlSecKeyDataFormatCertPem); rv < 0 {
+	if err := setKeyName(key, opts.KeyName); err != nil {
 		C.xmlSecKeyDestroy(key)
-		return nil, mustPopError()
+		return nil, err
 	}
 
 	if rv := C.xmlSecCryptoAppDefaultKeysMngrAdoptKey(keysMngr, key); rv < 0 {
+		C.xmlSecKeyDestroy(key)
 		return nil, mustPopError()
 	}
 
-	parsedDoc, err := newDoc(doc, nil)
+	return encryptWithKeysMngr(keysMngr, doc, opts)
+}
+
+// encryptWithKeysMngr encrypts doc to a key from keysMngr.
+func encryptWithKeysMngr(keysMngr *C.xmlSecKeysMngr, doc []byte, opts EncryptOptions) ([]byte, error) {
+	parsedDoc, err := newDoc(doc, nil, opts.Parser)
 	if err != nil {
 		return nil, err
 	}
@@ -210,6 +232,13 @@ func Encrypt(publicKey, doc []byte, opts EncryptOptions) ([]byte, error) {
 	if keyInfoNode2 == nil {
 		return nil, mustPopError()
 	}
+	if opts.KeyName != "" {
+		keyName := C.CString(opts.KeyName)
+		defer C.free(unsafe.Pointer(keyName))
+		if C.xmlSecTmplKeyInfoAddKeyName(keyInfoNode2, (*C.xmlChar)(unsafe.Pointer(keyName))) == nil {
+			return nil, mustPopError()
+		}
+	}
 
 	// Add a DigestMethod element to the encryption method node
 	{
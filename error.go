@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"runtime"
 	"strings"
+	"sync"
 
 	"github.com/crewjam/errset"
 )
@@ -11,7 +12,13 @@ import (
 // void captureXmlErrors();
 import "C"
 
-var globalErrors = map[uintptr]errset.ErrSet{}
+// globalErrors holds the errors reported by the library on each thread.
+// It is guarded by globalErrorsMu because many goroutines may be processing
+// XML at the same time.
+var (
+	globalErrorsMu sync.Mutex
+	globalErrors   = map[uintptr]errset.ErrSet{}
+)
 
 type libraryError struct {
 	FileName string
@@ -46,12 +53,16 @@ func onXmlsecError(file *C.char, line C.int, funcName *C.char, errorObject *C.ch
 		Reason:   int(reason),
 		Message:  C.GoString(msg)}
 	threadID := getThreadID()
+	globalErrorsMu.Lock()
+	defer globalErrorsMu.Unlock()
 	globalErrors[threadID] = append(globalErrors[threadID], err)
 }
 
 //export onXmlError
 func onXmlError(msg *C.char) {
 	threadID := getThreadID()
+	globalErrorsMu.Lock()
+	defer globalErrorsMu.Unlock()
 	globalErrors[threadID] = append(globalErrors[threadID],
 		fmt.Errorf("%s", strings.TrimSuffix(C.GoString(msg), "\n")))
 }
@@ -62,14 +73,18 @@ func onXmlError(msg *C.char) {
 // to the error object associated with the current thread.
 func startProcessingXML() {
 	runtime.LockOSThread()
+	globalErrorsMu.Lock()
 	globalErrors[getThreadID()] = errset.ErrSet{}
+	globalErrorsMu.Unlock()
 	C.captureXmlErrors()
 }
 
 // stopProcessingXML unlocks the goroutine-thread lock and deletes the current
 // error stack.
 func stopProcessingXML() {
+	globalErrorsMu.Lock()
 	delete(globalErrors, getThreadID())
+	globalErrorsMu.Unlock()
 	runtime.UnlockOSThread()
 }
 
@@ -79,11 +94,28 @@ func stopProcessingXML() {
 // functions must be called on the same goroutine.
 func popError() error {
 	threadID := getThreadID()
+	globalErrorsMu.Lock()
+	defer globalErrorsMu.Unlock()
 	rv := globalErrors[threadID].ReturnValue()
 	globalErrors[threadID] = errset.ErrSet{}
 	return rv
 }
 
+// hasLibraryError reports whether the library has reported an error for
+// reason on the current thread since the last call to popError. It does
+// not remove any errors.
+func hasLibraryError(reason int) bool {
+	threadID := getThreadID()
+	globalErrorsMu.Lock()
+	defer globalErrorsMu.Unlock()
+	for _, err := range globalErrors[threadID] {
+		if err, ok := err.(libraryError); ok && err.Reason == reason {
+			return true
+		}
+	}
+	return false
+}
+
 // mustPopError is like popError except that if there is no error on the stack
 // it returns a generic error.
 func mustPopError() error {
@@ -0,0 +1,199 @@
+package xmlsec
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// #include <xmlsec/xmlsec.h>
+// #include <xmlsec/xmltree.h>
+// #include <xmlsec/keys.h>
+// #include <xmlsec/keysmngr.h>
+// #include <xmlsec/keyinfo.h>
+// #include <xmlsec/app.h>
+// #include <xmlsec/crypto.h>
+//
+// static inline xmlSecKeyDataId MY_xmlSecKeyDataHmacId(void) { return xmlSecKeyDataHmacId; }
+// static inline xmlSecKeyDataId MY_xmlSecKeyDataNameId(void) { return xmlSecKeyDataNameId; }
+import "C"
+
+// hmacOutputSizes maps the HMAC signature methods to the size in bits of
+// the output of their hash function.
+var hmacOutputSizes = map[string]int{
+	"http://www.w3.org/2001/04/xmldsig-more#hmac-md5":       128,
+	"http://www.w3.org/2001/04/xmldsig-more#hmac-ripemd160": 160,
+	"http://www.w3.org/2000/09/xmldsig#hmac-sha1":           160,
+	"http://www.w3.org/2001/04/xmldsig-more#hmac-sha224":    224,
+	"http://www.w3.org/2001/04/xmldsig-more#hmac-sha256":    256,
+	"http://www.w3.org/2001/04/xmldsig-more#hmac-sha384":    384,
+	"http://www.w3.org/2001/04/xmldsig-more#hmac-sha512":    512,
+}
+
+// SignHMAC returns a version of doc signed with the shared secret key
+// according to the XMLDSIG standard. doc is a template document, like for
+// Sign, whose SignatureMethod must be one of the HMAC algorithms, for example
+// "http://www.w3.org/2001/04/xmldsig-more#hmac-sha256".
+func SignHMAC(key []byte, doc []byte, opts SignatureOptions) ([]byte, error) {
+	startProcessingXML()
+	defer stopProcessingXML()
+
+	signKey, err := loadHMACKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return signWithKey(signKey, doc, opts, nil)
+}
+
+// VerifyHMAC checks that the HMAC signature in doc is valid according to the
+// XMLDSIG specification. key is the shared secret used to sign doc. If the
+// signature is not correct, this function returns ErrVerificationFailed.
+//
+// Only key is used to check the signature, never a key from its KeyInfo,
+// and signatures whose SignatureMethod is not an HMAC are rejected with an
+// AlgorithmNotAllowedError. Signatures whose HMACOutputLength truncates the
+// HMAC to less than half its size, or to less than 80 bits, are rejected as
+// required by XMLDSIG 1.1.
+func VerifyHMAC(key []byte, doc []byte, opts SignatureOptions) error {
+	startProcessingXML()
+	defer stopProcessingXML()
+
+	keysMngr, err := newKeysMngr()
+	if err != nil {
+		return err
+	}
+	defer C.xmlSecKeysMngrDestroy(keysMngr)
+
+	hmacKey, err := loadHMACKey(key)
+	if err != nil {
+		return err
+	}
+	if rv := C.xmlSecCryptoAppDefaultKeysMngrAdoptKey(keysMngr, hmacKey); rv < 0 {
+		C.xmlSecKeyDestroy(hmacKey)
+		return mustPopError()
+	}
+
+	// the keys returned by a KeyResolver are certificates, which cannot
+	// check an HMAC
+	opts.KeyResolver = nil
+	_, err = verifyWithKeysMngr(keysMngr, doc, opts, verifyHMACKey)
+	return err
+}
+
+// loadHMACKey returns an xmlsec HMAC key whose value is key. The caller owns
+// the returned key.
+//
+// This function must be called between startProcessingXML() and
+// stopProcessingXML().
+func loadHMACKey(key []byte) (*C.xmlSecKey, error) {
+	if len(key) == 0 {
+		return nil, errors.New("empty HMAC key")
+	}
+	rv := C.xmlSecKeyReadMemory(C.MY_xmlSecKeyDataHmacId(),
+		(*C.xmlSecByte)(unsafe.Pointer(&key[0])),
+		C.xmlSecSize(len(key)))
+	if rv == nil {
+		return nil, mustPopError()
+	}
+	return rv, nil
+}
+
+// checkHMACSignatureMethod returns an AlgorithmNotAllowedError if the
+// SignatureMethod of the signature in signatureNode is not an HMAC, so that
+// VerifyHMAC cannot be satisfied by a public key in the KeyInfo.
+func checkHMACSignatureMethod(signatureNode *C.xmlNode) error {
+	signedInfoNode := firstDSigChild(signatureNode, (*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeSignedInfo)))
+	signatureMethodNode := firstDSigChild(signedInfoNode, (*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeSignatureMethod)))
+	algorithm := ""
+	if signatureMethodNode != nil {
+		algorithm = getAttr(signatureMethodNode, "Algorithm")
+	}
+	if _, ok := hmacOutputSizes[algorithm]; !ok {
+		return AlgorithmNotAllowedError{Kind: "signature method", Algorithm: algorithm}
+	}
+	return nil
+}
+
+// restrictToKeysMngrKeys makes xmlsec ignore the keys in the KeyInfo of the
+// signature in dsigCtx, such as an HMACKeyValue, so that the key always
+// comes from the keys manager. Only KeyName is read, which names one of
+// its keys.
+func restrictToKeysMngrKeys(dsigCtx *C.xmlSecDSigCtx) error {
+	return enableKeyData(&dsigCtx.keyInfoReadCtx, C.MY_xmlSecKeyDataNameId())
+}
+
+// hmacDigestMethods maps the HMAC signature methods to the digest method
+// of their hash function.
+var hmacDigestMethods = map[string]string{
+	"http://www.w3.org/2001/04/xmldsig-more#hmac-md5":       "http://www.w3.org/2001/04/xmldsig-more#md5",
+	"http://www.w3.org/2001/04/xmldsig-more#hmac-ripemd160": "http://www.w3.org/2001/04/xmlenc#ripemd160",
+	"http://www.w3.org/2000/09/xmldsig#hmac-sha1":           "http://www.w3.org/2000/09/xmldsig#sha1",
+	"http://www.w3.org/2001/04/xmldsig-more#hmac-sha224":    "http://www.w3.org/2001/04/xmldsig-more#sha224",
+	"http://www.w3.org/2001/04/xmldsig-more#hmac-sha256":    "http://www.w3.org/2001/04/xmlenc#sha256",
+	"http://www.w3.org/2001/04/xmldsig-more#hmac-sha384":    "http://www.w3.org/2001/04/xmldsig-more#sha384",
+	"http://www.w3.org/2001/04/xmldsig-more#hmac-sha512":    "http://www.w3.org/2001/04/xmlenc#sha512",
+}
+
+// hmacOnly returns a copy of p whose signature methods are restricted to
+// the HMAC algorithms. If p lists HMAC signature methods, only those are
+// allowed. Otherwise, as for SecureAlgorithmPolicy, which lists none, the
+// HMACs whose hash is an allowed digest method are. p may be nil.
+func (p *AlgorithmPolicy) hmacOnly() *AlgorithmPolicy {
+	rv := &AlgorithmPolicy{}
+	if p != nil {
+		*rv = *p
+	}
+	allowed := map[string]bool{}
+	for _, algorithm := range rv.SignatureMethods {
+		if _, ok := hmacOutputSizes[algorithm]; ok {
+			allowed[algorithm] = true
+		}
+	}
+	if len(allowed) == 0 {
+		allowedDigests := map[string]bool{}
+		for _, algorithm := range rv.DigestMethods {
+			allowedDigests[algorithm] = true
+		}
+		for algorithm, digestMethod := range hmacDigestMethods {
+			if len(allowedDigests) == 0 || allowedDigests[digestMethod] {
+				allowed[algorithm] = true
+			}
+		}
+	}
+	rv.SignatureMethods = nil
+	for algorithm := range allowed {
+		rv.SignatureMethods = append(rv.SignatureMethods, algorithm)
+	}
+	sort.Strings(rv.SignatureMethods)
+	return rv
+}
+
+// checkHMACOutputLength returns an error if the signature in signatureNode
+// uses an HMAC truncated to fewer bits than allowed by XMLDSIG 1.1, section
+// 6.3.1, which is the larger of 80 and half the output size of the hash.
+func checkHMACOutputLength(signatureNode *C.xmlNode) error {
+	signedInfoNode := firstDSigChild(signatureNode, (*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeSignedInfo)))
+	signatureMethodNode := firstDSigChild(signedInfoNode, (*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeSignatureMethod)))
+	outputLengthNode := firstDSigChild(signatureMethodNode, (*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeHMACOutputLength)))
+	if outputLengthNode == nil {
+		return nil
+	}
+
+	value := strings.TrimSpace(getContent(outputLengthNode))
+	outputLength, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid HMACOutputLength %q", value)
+	}
+
+	minOutputLength := 80
+	if size := hmacOutputSizes[getAttr(signatureMethodNode, "Algorithm")]; size/2 > minOutputLength {
+		minOutputLength = size / 2
+	}
+	if outputLength < minOutputLength {
+		return fmt.Errorf("HMACOutputLength %d is less than the minimum of %d bits", outputLength, minOutputLength)
+	}
+	return nil
+}
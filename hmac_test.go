@@ -0,0 +1,104 @@
+package xmlsec
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type HMACTest struct {
+	Secret []byte
+	DocStr []byte
+}
+
+var _ = Suite(&HMACTest{})
+
+func (testSuite *HMACTest) SetUpTest(c *C) {
+	testSuite.Secret = []byte("correct horse battery staple")
+	testSuite.DocStr = []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Envelope xmlns="urn:envelope"><Data>Hello, World!</Data></Envelope>
+`)
+}
+
+func (testSuite *HMACTest) template(c *C, hmacOutputLength int) []byte {
+	doc, err := InsertSignatureTemplate(testSuite.DocStr, SignatureTemplate{
+		SignatureMethod:  "http://www.w3.org/2001/04/xmldsig-more#hmac-sha256",
+		HMACOutputLength: hmacOutputLength,
+		References: []ReferenceTemplate{{
+			DigestMethod: "http://www.w3.org/2001/04/xmlenc#sha256",
+			Transforms: []TransformTemplate{
+				{Algorithm: "http://www.w3.org/2000/09/xmldsig#enveloped-signature"},
+			},
+		}},
+	}, TemplateLocation{}, SignatureOptions{})
+	c.Assert(err, IsNil)
+	return doc
+}
+
+func (testSuite *HMACTest) TestSignAndVerify(c *C) {
+	signed, err := SignHMAC(testSuite.Secret, testSuite.template(c, 0), SignatureOptions{})
+	c.Assert(err, IsNil)
+
+	err = VerifyHMAC(testSuite.Secret, signed, SignatureOptions{})
+	c.Assert(err, IsNil)
+
+	err = VerifyHMAC(testSuite.Secret, signed, SignatureOptions{AlgorithmPolicy: SecureAlgorithmPolicy()})
+	c.Assert(err, IsNil)
+
+	err = VerifyHMAC([]byte("wrong secret"), signed, SignatureOptions{})
+	c.Assert(err, Equals, ErrVerificationFailed)
+}
+
+func (testSuite *HMACTest) TestOutputLength(c *C) {
+	signed, err := SignHMAC(testSuite.Secret, testSuite.template(c, 128), SignatureOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(string(signed), Matches, `(?s).*<HMACOutputLength>128</HMACOutputLength>.*`)
+
+	err = VerifyHMAC(testSuite.Secret, signed, SignatureOptions{})
+	c.Assert(err, IsNil)
+}
+
+func (testSuite *HMACTest) TestTruncatedOutputRejected(c *C) {
+	signed, err := SignHMAC(testSuite.Secret, testSuite.template(c, 96), SignatureOptions{})
+	c.Assert(err, IsNil)
+
+	err = VerifyHMAC(testSuite.Secret, signed, SignatureOptions{})
+	c.Assert(err, ErrorMatches, "HMACOutputLength 96 is less than the minimum of 128 bits")
+}
+
+func (testSuite *HMACTest) TestEmptyKey(c *C) {
+	_, err := SignHMAC(nil, testSuite.template(c, 0), SignatureOptions{})
+	c.Assert(err, ErrorMatches, "empty HMAC key")
+
+	err = VerifyHMAC(nil, testSuite.DocStr, SignatureOptions{})
+	c.Assert(err, ErrorMatches, "empty HMAC key")
+}
+
+func (testSuite *HMACTest) TestPublicKeyRejected(c *C) {
+	dsigTest := XMLDSigTest{}
+	dsigTest.SetUpTest(c)
+
+	// a signature by an arbitrary RSA key that carries its public key
+	doc, err := InsertSignatureTemplate(testSuite.DocStr, SignatureTemplate{
+		SignatureMethod: "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256",
+		References: []ReferenceTemplate{{
+			DigestMethod: "http://www.w3.org/2001/04/xmlenc#sha256",
+			Transforms: []TransformTemplate{
+				{Algorithm: "http://www.w3.org/2000/09/xmldsig#enveloped-signature"},
+			},
+		}},
+		KeyInfo: &KeyInfoTemplate{KeyValue: true},
+	}, TemplateLocation{}, SignatureOptions{})
+	c.Assert(err, IsNil)
+	signed, err := Sign(dsigTest.Key, doc, SignatureOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(string(signed), Matches, `(?s).*<RSAKeyValue>.*`)
+
+	err = VerifyHMAC(testSuite.Secret, signed, SignatureOptions{})
+	c.Assert(err, ErrorMatches, `signature method "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256" is not allowed`)
+	c.Assert(err, FitsTypeOf, AlgorithmNotAllowedError{})
+}
+
+func (testSuite *HMACTest) TestDocumentKeyIgnored(c *C) {
+	_, hmacKeyValue := forgedSignatures(c)
+	err := VerifyHMAC(testSuite.Secret, hmacKeyValue, SignatureOptions{})
+	c.Assert(err, Equals, ErrVerificationFailed)
+}
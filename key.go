@@ -0,0 +1,177 @@
+package xmlsec
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"unsafe"
+)
+
+// #include <stdlib.h>
+// #include <string.h>
+// #include <xmlsec/xmlsec.h>
+// #include <xmlsec/keys.h>
+// #include <xmlsec/crypto.h>
+// #include <openssl/err.h>
+// #include <openssl/evp.h>
+// #include <openssl/pem.h>
+// #include <openssl/pkcs12.h>
+//
+// // MY_isDecryptionFailure reports whether the error queue of OpenSSL
+// // holds a failure to decrypt a private key or PKCS#12 bundle, which is
+// // what a wrong password produces, and clears the queue.
+// static int MY_isDecryptionFailure(void) {
+//   unsigned long err;
+//   int rv = 0;
+//   while ((err = ERR_get_error()) != 0) {
+//     int lib = ERR_GET_LIB(err), reason = ERR_GET_REASON(err);
+//     if ((lib == ERR_LIB_EVP && reason == EVP_R_BAD_DECRYPT) ||
+//         (lib == ERR_LIB_PEM && reason == PEM_R_BAD_DECRYPT) ||
+//         (lib == ERR_LIB_PKCS12 && (reason == PKCS12_R_PKCS12_CIPHERFINAL_ERROR ||
+//                                    reason == PKCS12_R_MAC_VERIFY_FAILURE))) {
+//       rv = 1;
+//     }
+//   }
+//   return rv;
+// }
+//
+// // MY_isPKCS12MacMismatch reports whether the PKCS#12 bundle in data has a
+// // MAC that password does not verify, which OpenSSL does not report as an
+// // error.
+// static int MY_isPKCS12MacMismatch(const unsigned char *data, long size, const char *password) {
+//   PKCS12 *p12 = d2i_PKCS12(NULL, &data, size);
+//   int rv;
+//   if (p12 == NULL) {
+//     ERR_clear_error();
+//     return 0;
+//   }
+//   rv = PKCS12_mac_present(p12) && !PKCS12_verify_mac(p12, password, strlen(password));
+//   PKCS12_free(p12);
+//   ERR_clear_error();
+//   return rv;
+// }
+import "C"
+
+// ErrIncorrectPassword is returned when a private key is encrypted and the
+// password supplied in the options is missing or does not decrypt it.
+var ErrIncorrectPassword = errors.New("incorrect password for private key")
+
+// privateKeyFormat describes how a private key passed to this package is
+// encoded, as determined by detectPrivateKeyFormat.
+type privateKeyFormat struct {
+	Format    C.xmlSecKeyDataFormat
+	Encrypted bool
+}
+
+// detectPrivateKeyFormat inspects key and determines which of the formats
+// understood by xmlsec it is encoded in. PEM encoded keys may contain a
+// PKCS#1, SEC1 or PKCS#8 structure, optionally encrypted. DER encoded keys
+// may be PKCS#1, SEC1, PKCS#8 or encrypted PKCS#8. If the format cannot be
+// determined we fall back to PEM and let xmlsec report the error.
+func detectPrivateKeyFormat(key []byte) privateKeyFormat {
+	if block, _ := pem.Decode(key); block != nil {
+		encrypted := block.Type == "ENCRYPTED PRIVATE KEY" ||
+			strings.Contains(block.Headers["Proc-Type"], "ENCRYPTED")
+		return privateKeyFormat{Format: C.xmlSecKeyDataFormatPem, Encrypted: encrypted}
+	}
+
+	if _, err := x509.ParsePKCS1PrivateKey(key); err == nil {
+		return privateKeyFormat{Format: C.xmlSecKeyDataFormatDer}
+	}
+	if _, err := x509.ParseECPrivateKey(key); err == nil {
+		return privateKeyFormat{Format: C.xmlSecKeyDataFormatDer}
+	}
+	if _, err := x509.ParsePKCS8PrivateKey(key); err == nil {
+		return privateKeyFormat{Format: C.xmlSecKeyDataFormatDer}
+	}
+
+	// EncryptedPrivateKeyInfo from RFC 5208, section 6
+	var encryptedKey struct {
+		Algorithm     pkix.AlgorithmIdentifier
+		EncryptedData []byte
+	}
+	if rest, err := asn1.Unmarshal(key, &encryptedKey); err == nil && len(rest) == 0 {
+		return privateKeyFormat{Format: C.xmlSecKeyDataFormatPkcs8Der, Encrypted: true}
+	}
+
+	return privateKeyFormat{Format: C.xmlSecKeyDataFormatPem}
+}
+
+// loadPrivateKey returns an xmlsec key for the private key in key, which may
+// be in any of the formats understood by detectPrivateKeyFormat. password is
+// used to decrypt the key if it is encrypted. The caller owns the returned key.
+//
+// This function must be called between startProcessingXML() and
+// stopProcessingXML().
+func loadPrivateKey(key []byte, password string) (*C.xmlSecKey, error) {
+	if len(key) == 0 {
+		return nil, errors.New("empty private key")
+	}
+	if der := edDSAPrivateKeyDER(key); der != nil {
+		return loadEdDSAPrivateKey(der)
+	}
+	format := detectPrivateKeyFormat(key)
+	if format.Encrypted && password == "" {
+		return nil, ErrIncorrectPassword
+	}
+
+	// Note: we always pass a password, even an empty one, because when the
+	// password is nil OpenSSL falls back to prompting on the terminal.
+	cPassword := C.CString(password)
+	defer C.free(unsafe.Pointer(cPassword))
+
+	// xmlsec reports the last error in the queue of OpenSSL, which may be
+	// left over from an earlier failure on this thread
+	C.ERR_clear_error()
+	rv := C.xmlSecCryptoAppKeyLoadMemory(
+		(*C.xmlSecByte)(unsafe.Pointer(&key[0])),
+		C.xmlSecSize(len(key)),
+		format.Format,
+		cPassword, nil, nil)
+	if rv == nil {
+		// OpenSSL cannot tell a wrong password from a corrupted ciphertext,
+		// but other failures, such as an unsupported cipher or a malformed
+		// structure, are reported as they are.
+		if format.Encrypted && C.MY_isDecryptionFailure() != 0 {
+			popError()
+			return nil, ErrIncorrectPassword
+		}
+		return nil, mustPopError()
+	}
+	return rv, nil
+}
+
+// loadPKCS12 returns an xmlsec key for the private key and certificates
+// in the PKCS#12 bundle data, which is protected by password. The caller
+// owns the returned key.
+//
+// This function must be called between startProcessingXML() and
+// stopProcessingXML().
+func loadPKCS12(data []byte, password string) (*C.xmlSecKey, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty PKCS#12 data")
+	}
+
+	cPassword := C.CString(password)
+	defer C.free(unsafe.Pointer(cPassword))
+
+	C.ERR_clear_error()
+	rv := C.xmlSecCryptoAppPkcs12LoadMemory(
+		(*C.xmlSecByte)(unsafe.Pointer(&data[0])),
+		C.xmlSecSize(len(data)),
+		cPassword, nil, nil)
+	if rv == nil {
+		// as for private keys, other failures, such as a cipher that
+		// OpenSSL no longer provides by default, are reported as they are
+		if C.MY_isDecryptionFailure() != 0 || C.MY_isPKCS12MacMismatch(
+			(*C.uchar)(unsafe.Pointer(&data[0])), C.long(len(data)), cPassword) != 0 {
+			popError()
+			return nil, ErrIncorrectPassword
+		}
+		return nil, mustPopError()
+	}
+	return rv, nil
+}
@@ -0,0 +1,307 @@
+package xmlsec
+
+import (
+	"crypto/x509"
+	"errors"
+	"sync"
+	"unsafe"
+)
+
+// #include <stdlib.h>
+// #include <xmlsec/xmlsec.h>
+// #include <xmlsec/keys.h>
+// #include <xmlsec/keysmngr.h>
+// #include <xmlsec/list.h>
+// #include <xmlsec/crypto.h>
+import "C"
+
+// KeyStore holds keys and trusted certificates that are loaded once and then
+// used by many operations, avoiding the cost of parsing the keys on every
+// call. Pass it in the KeyStore field of SignatureOptions, EncryptOptions or
+// DecryptOptions instead of the key argument of Verify, VerifyTrusted,
+// Encrypt and Decrypt.
+//
+// A KeyStore may be used concurrently by multiple goroutines, including
+// while keys are being added. Call Close to release its resources.
+type KeyStore struct {
+	mu       sync.RWMutex
+	keysMngr *C.xmlSecKeysMngr
+	crls     []*x509.RevocationList
+
+	// trusted and intermediates hold the certificates added by
+	// AddTrustedCertificate and AddIntermediateCertificate.
+	trusted       []*x509.Certificate
+	intermediates []*x509.Certificate
+}
+
+// errKeyAndKeyStore is returned when a key is passed along with a KeyStore.
+var errKeyAndKeyStore = errors.New("cannot use both a key and a KeyStore")
+
+// errKeyStoreClosed is returned when using a KeyStore after Close.
+var errKeyStoreClosed = errors.New("KeyStore is closed")
+
+// NewKeyStore returns a new, empty KeyStore.
+func NewKeyStore() (*KeyStore, error) {
+	startProcessingXML()
+	defer stopProcessingXML()
+
+	keysMngr, err := newKeysMngr()
+	if err != nil {
+		return nil, err
+	}
+	return &KeyStore{keysMngr: keysMngr}, nil
+}
+
+// Close releases the keys held by the store. The store must not be used
+// afterwards.
+func (s *KeyStore) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keysMngr != nil {
+		C.xmlSecKeysMngrDestroy(s.keysMngr)
+		s.keysMngr = nil
+	}
+}
+
+// AddKey adds the private key key, which is used to decrypt documents. name
+// is the name of the key, which may be empty. key may be in any of the
+// formats accepted by Sign. password is used to decrypt key if it is
+// encrypted.
+func (s *KeyStore) AddKey(name string, key []byte, password string) error {
+	startProcessingXML()
+	defer stopProcessingXML()
+
+	xmlsecKey, err := loadPrivateKey(key, password)
+	if err != nil {
+		return err
+	}
+	return s.adoptKey(name, xmlsecKey)
+}
+
+// AddCertificate adds the certificate or public key cert, in any of the
+// forms accepted by Verify, which is used to verify signatures and to
+// encrypt documents. name is the name of the key, which may be empty.
+func (s *KeyStore) AddCertificate(name string, cert []byte) error {
+	startProcessingXML()
+	defer stopProcessingXML()
+
+	key, err := loadPublicKey(cert)
+	if err != nil {
+		return err
+	}
+	return s.adoptKey(name, key)
+}
+
+// AddTrustedCertificate adds the PEM encoded certificate cert to the
+// certificates trusted to issue the certificates in signatures, like the
+// certs argument of VerifyTrusted.
+func (s *KeyStore) AddTrustedCertificate(cert []byte) error {
+	return s.addX509StoreCert(cert, C.xmlSecKeyDataTypeTrusted, &s.trusted)
+}
+
+// AddIntermediateCertificate adds the PEM encoded certificate cert to the
+// certificates that may be used to build the chain from the certificate of a
+// signature to a trusted certificate, like SignatureOptions.Intermediates.
+// cert itself is not trusted.
+func (s *KeyStore) AddIntermediateCertificate(cert []byte) error {
+	return s.addX509StoreCert(cert, C.xmlSecKeyDataTypeNone, &s.intermediates)
+}
+
+// AddCRL adds the PEM or DER encoded certificate revocation list crl, like
+// SignatureOptions.CRLs.
+func (s *KeyStore) AddCRL(crl []byte) error {
+	startProcessingXML()
+	defer stopProcessingXML()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keysMngr == nil {
+		return errKeyStoreClosed
+	}
+	revocationList, err := loadCRL(s.keysMngr, crl)
+	if err != nil {
+		return err
+	}
+	s.crls = append(s.crls, revocationList)
+	return nil
+}
+
+// certificates returns the certificates added by AddTrustedCertificate and
+// AddIntermediateCertificate.
+func (s *KeyStore) certificates() (trusted, intermediates []*x509.Certificate) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.trusted, s.intermediates
+}
+
+// addX509StoreCert adds cert to the certificates of the store with the
+// type dataType, and appends it to certs.
+func (s *KeyStore) addX509StoreCert(cert []byte, dataType C.xmlSecKeyDataType, certs *[]*x509.Certificate) error {
+	startProcessingXML()
+	defer stopProcessingXML()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keysMngr == nil {
+		return errKeyStoreClosed
+	}
+	if err := loadX509StoreCert(s.keysMngr, cert, dataType); err != nil {
+		return err
+	}
+	if parsed := parsePEMCertificate(cert); parsed != nil {
+		*certs = append(*certs, parsed)
+	}
+	return nil
+}
+
+// adoptKey names key and adds it to the store, which takes ownership of it.
+//
+// This function must be called between startProcessingXML() and
+// stopProcessingXML().
+func (s *KeyStore) adoptKey(name string, key *C.xmlSecKey) error {
+	if err := setKeyName(key, name); err != nil {
+		C.xmlSecKeyDestroy(key)
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keysMngr == nil {
+		C.xmlSecKeyDestroy(key)
+		return errKeyStoreClosed
+	}
+	if rv := C.xmlSecCryptoAppDefaultKeysMngrAdoptKey(s.keysMngr, key); rv < 0 {
+		C.xmlSecKeyDestroy(key)
+		return mustPopError()
+	}
+	return nil
+}
+
+// acquire returns the keys manager of the store, which may be used until
+// release is called. The keys manager must not be modified.
+func (s *KeyStore) acquire() (*C.xmlSecKeysMngr, error) {
+	s.mu.RLock()
+	if s.keysMngr == nil {
+		s.mu.RUnlock()
+		return nil, errKeyStoreClosed
+	}
+	return s.keysMngr, nil
+}
+
+// release ends the use of the keys manager returned by acquire.
+func (s *KeyStore) release() {
+	s.mu.RUnlock()
+}
+
+// storeKeys returns the keys held by keysMngr, in the order they were added.
+// The keys remain owned by keysMngr.
+func storeKeys(keysMngr *C.xmlSecKeysMngr) []*C.xmlSecKey {
+	store := C.xmlSecKeysMngrGetKeysStore(keysMngr)
+	if store == nil {
+		return nil
+	}
+	list := C.xmlSecSimpleKeysStoreGetKeys(store)
+	if list == nil {
+		return nil
+	}
+
+	var keys []*C.xmlSecKey
+	for i := C.xmlSecSize(0); i < C.xmlSecPtrListGetSize(list); i++ {
+		if key := (*C.xmlSecKey)(C.xmlSecPtrListGetItem(list, i)); key != nil {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// keysByName returns keys with the keys named in names first, in the order
+// of names, followed by the other keys in their original order.
+func keysByName(keys []*C.xmlSecKey, names []string) []*C.xmlSecKey {
+	rv := make([]*C.xmlSecKey, 0, len(keys))
+	used := make(map[*C.xmlSecKey]bool, len(keys))
+	for _, name := range names {
+		for _, key := range keys {
+			if !used[key] && getKeyName(key) == name {
+				rv = append(rv, key)
+				used[key] = true
+			}
+		}
+	}
+	for _, key := range keys {
+		if !used[key] {
+			rv = append(rv, key)
+		}
+	}
+	return rv
+}
+
+// getKeyName returns the name of key, or an empty string if it has none.
+func getKeyName(key *C.xmlSecKey) string {
+	return C.GoString((*C.char)(unsafe.Pointer(C.xmlSecKeyGetName(key))))
+}
+
+// setKeyName sets the name of key, unless name is empty.
+func setKeyName(key *C.xmlSecKey, name string) error {
+	if name == "" {
+		return nil
+	}
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	if rv := C.xmlSecKeySetName(key, (*C.xmlChar)(unsafe.Pointer(cName))); rv < 0 {
+		return mustPopError()
+	}
+	return nil
+}
+
+// enableKeyData makes keyInfoCtx read only the kinds of key data in ids
+// from KeyInfo elements, and from those of the EncryptedKeys it reads.
+func enableKeyData(keyInfoCtx *C.xmlSecKeyInfoCtx, ids ...C.xmlSecKeyDataId) error {
+	for _, id := range ids {
+		if rv := C.xmlSecPtrListAdd(&keyInfoCtx.enabledKeyData, C.xmlSecPtr(unsafe.Pointer(id))); rv < 0 {
+			return mustPopError()
+		}
+	}
+	return nil
+}
+
+// newKeysMngr returns a keys manager with the default keys store and X509
+// certificate store. The caller must destroy it.
+//
+// This function must be called between startProcessingXML() and
+// stopProcessingXML().
+func newKeysMngr() (*C.xmlSecKeysMngr, error) {
+	keysMngr := C.xmlSecKeysMngrCreate()
+	if keysMngr == nil {
+		return nil, mustPopError()
+	}
+	if rv := C.xmlSecCryptoAppDefaultKeysMngrInit(keysMngr); rv < 0 {
+		C.xmlSecKeysMngrDestroy(keysMngr)
+		return nil, mustPopError()
+	}
+	initEdDSAKeysMngr(keysMngr)
+	initKeyResolverKeysMngr(keysMngr)
+	return keysMngr, nil
+}
+
+// loadX509StoreCert adds the PEM encoded certificate cert to the
+// certificates of keysMngr that are used to verify the certificates of
+// signatures. dataType is xmlSecKeyDataTypeTrusted for trusted certificates,
+// or xmlSecKeyDataTypeNone for untrusted certificates that may only be used
+// to build chains.
+//
+// This function must be called between startProcessingXML() and
+// stopProcessingXML().
+func loadX509StoreCert(keysMngr *C.xmlSecKeysMngr, cert []byte, dataType C.xmlSecKeyDataType) error {
+	if len(cert) == 0 {
+		return errors.New("empty certificate")
+	}
+	if rv := C.xmlSecCryptoAppKeysMngrCertLoadMemory(
+		keysMngr,
+		(*C.xmlSecByte)(unsafe.Pointer(&cert[0])),
+		C.xmlSecSize(len(cert)),
+		C.xmlSecKeyDataFormatCertPem,
+		dataType); rv < 0 {
+		return mustPopError()
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package xmlsec
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"sync"
+
+	. "gopkg.in/check.v1"
+)
+
+type KeyStoreTest struct {
+	DSig    XMLDSigTest
+	Encrypt EncryptTest
+	Chain   ChainTest
+}
+
+var _ = Suite(&KeyStoreTest{})
+
+func (testSuite *KeyStoreTest) SetUpSuite(c *C) {
+	testSuite.Chain.SetUpSuite(c)
+}
+
+func (testSuite *KeyStoreTest) SetUpTest(c *C) {
+	testSuite.DSig.SetUpTest(c)
+	testSuite.Encrypt.SetUpTest(c)
+	testSuite.Chain.SetUpTest(c)
+}
+
+func (testSuite *KeyStoreTest) TestVerify(c *C) {
+	keyStore, err := NewKeyStore()
+	c.Assert(err, IsNil)
+	defer keyStore.Close()
+	c.Assert(keyStore.AddCertificate("dsig", testSuite.DSig.Cert), IsNil)
+
+	signed, err := Sign(testSuite.DSig.Key, testSuite.DSig.DocStr, SignatureOptions{})
+	c.Assert(err, IsNil)
+
+	opts := SignatureOptions{KeyStore: keyStore}
+	c.Assert(Verify(nil, signed, opts), IsNil)
+
+	tampered := []byte(strings.Replace(string(signed), "Hello", "Goodbye", 1))
+	c.Assert(Verify(nil, tampered, opts), Equals, ErrVerificationFailed)
+
+	err = Verify(testSuite.DSig.Cert, signed, opts)
+	c.Assert(err, ErrorMatches, "cannot use both a key and a KeyStore")
+}
+
+func (testSuite *KeyStoreTest) TestVerifyTrusted(c *C) {
+	keyStore, err := NewKeyStore()
+	c.Assert(err, IsNil)
+	defer keyStore.Close()
+
+	chain := testSuite.Chain.Chain
+	signed, err := SignWithSigner(chain.LeafKey, chain.Leaf, testSuite.Chain.DocStr,
+		SignatureOptions{Certificates: []*x509.Certificate{chain.Leaf, chain.Intermediate}})
+	c.Assert(err, IsNil)
+
+	opts := SignatureOptions{KeyStore: keyStore}
+	c.Assert(VerifyTrusted(nil, signed, opts), Equals, ErrVerificationFailed)
+
+	c.Assert(keyStore.AddTrustedCertificate(chain.RootPEM), IsNil)
+	c.Assert(VerifyTrusted(nil, signed, opts), IsNil)
+}
+
+func (testSuite *KeyStoreTest) TestVerifyTrustedKeyName(c *C) {
+	keyStore, err := NewKeyStore()
+	c.Assert(err, IsNil)
+	defer keyStore.Close()
+	chain := testSuite.Chain.Chain
+	leafPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: chain.Leaf.Raw})
+	c.Assert(keyStore.AddCertificate("partner", leafPEM), IsNil)
+	c.Assert(keyStore.AddTrustedCertificate(chain.RootPEM), IsNil)
+
+	// The signature names a key of the store but has no certificate, so
+	// there is nothing for the trusted certificates to validate.
+	doc, err := InsertSignatureTemplate([]byte(`<Envelope xmlns="urn:envelope"><Data>Hello, World!</Data></Envelope>`), SignatureTemplate{
+		SignatureMethod: "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256",
+		References: []ReferenceTemplate{{
+			DigestMethod: "http://www.w3.org/2001/04/xmlenc#sha256",
+			Transforms: []TransformTemplate{
+				{Algorithm: "http://www.w3.org/2000/09/xmldsig#enveloped-signature"},
+			},
+		}},
+		KeyInfo: &KeyInfoTemplate{KeyName: "partner"},
+	}, TemplateLocation{}, SignatureOptions{})
+	c.Assert(err, IsNil)
+	signed, err := Sign(chain.LeafKeyPEM, doc, SignatureOptions{})
+	c.Assert(err, IsNil)
+
+	opts := SignatureOptions{KeyStore: keyStore}
+	c.Assert(Verify(nil, signed, opts), IsNil)
+	c.Assert(VerifyTrusted(nil, signed, opts), Equals, ErrVerificationFailed)
+}
+
+func (testSuite *KeyStoreTest) TestEncryptAndDecrypt(c *C) {
+	keyStore, err := NewKeyStore()
+	c.Assert(err, IsNil)
+	defer keyStore.Close()
+	c.Assert(keyStore.AddCertificate("encrypt", testSuite.Encrypt.Cert), IsNil)
+	c.Assert(keyStore.AddKey("decrypt", testSuite.Encrypt.Key, ""), IsNil)
+
+	encrypted, err := Encrypt(nil, testSuite.Encrypt.Plaintext, EncryptOptions{
+		KeyStore: keyStore,
+		KeyName:  "encrypt",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(string(encrypted), "<KeyName>encrypt</KeyName>"), Equals, true)
+
+	plaintext, err := DecryptWithOptions(nil, encrypted, DecryptOptions{KeyStore: keyStore})
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(string(plaintext), "EncryptedData"), Equals, false)
+
+	_, err = DecryptWithOptions(testSuite.Encrypt.Key, encrypted, DecryptOptions{KeyStore: keyStore})
+	c.Assert(err, ErrorMatches, "cannot use both a key and a KeyStore")
+}
+
+func (testSuite *KeyStoreTest) TestVerifyKeyName(c *C) {
+	keyStore, err := NewKeyStore()
+	c.Assert(err, IsNil)
+	defer keyStore.Close()
+	chain := testSuite.Chain.Chain
+	leafPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: chain.Leaf.Raw})
+	c.Assert(keyStore.AddCertificate("partner-a", testSuite.DSig.Cert), IsNil)
+	c.Assert(keyStore.AddCertificate("partner-b", leafPEM), IsNil)
+
+	sign := func(keyName string) []byte {
+		doc, err := InsertSignatureTemplate([]byte(`<Envelope xmlns="urn:envelope"><Data>Hello, World!</Data></Envelope>`), SignatureTemplate{
+			SignatureMethod: "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256",
+			References: []ReferenceTemplate{{
+				DigestMethod: "http://www.w3.org/2001/04/xmlenc#sha256",
+				Transforms: []TransformTemplate{
+					{Algorithm: "http://www.w3.org/2000/09/xmldsig#enveloped-signature"},
+				},
+			}},
+			KeyInfo: &KeyInfoTemplate{KeyName: keyName},
+		}, TemplateLocation{}, SignatureOptions{})
+		c.Assert(err, IsNil)
+		signed, err := Sign(chain.LeafKeyPEM, doc, SignatureOptions{})
+		c.Assert(err, IsNil)
+		return signed
+	}
+
+	opts := SignatureOptions{KeyStore: keyStore}
+	for _, keyName := range []string{"partner-b", "partner-a", "unknown", ""} {
+		result, err := VerifyDetailed(nil, sign(keyName), opts)
+		c.Assert(err, IsNil, Commentf("KeyName %q", keyName))
+		c.Assert(result.KeyName, Equals, "partner-b")
+	}
+
+	tampered := []byte(strings.Replace(string(sign("partner-b")), "Hello", "Goodbye", 1))
+	result, err := VerifyDetailed(nil, tampered, opts)
+	c.Assert(err, Equals, ErrVerificationFailed)
+	c.Assert(result.KeyName, Equals, "partner-b")
+}
+
+func (testSuite *KeyStoreTest) TestVerifyDocumentKeyIgnored(c *C) {
+	keyStore, err := NewKeyStore()
+	c.Assert(err, IsNil)
+	defer keyStore.Close()
+	c.Assert(keyStore.AddCertificate("dsig", testSuite.DSig.Cert), IsNil)
+
+	rsaKeyValue, hmacKeyValue := forgedSignatures(c)
+	opts := SignatureOptions{KeyStore: keyStore}
+	for _, forged := range [][]byte{rsaKeyValue, hmacKeyValue} {
+		_, err := VerifyDetailed(nil, forged, opts)
+		c.Assert(err, Equals, ErrVerificationFailed)
+	}
+}
+
+func (testSuite *KeyStoreTest) TestDecryptKeyName(c *C) {
+	keyStore, err := NewKeyStore()
+	c.Assert(err, IsNil)
+	defer keyStore.Close()
+	c.Assert(keyStore.AddKey("old", testSuite.Chain.Chain.LeafKeyPEM, ""), IsNil)
+	c.Assert(keyStore.AddKey("current", testSuite.Encrypt.Key, ""), IsNil)
+
+	for _, keyName := range []string{"current", "old", ""} {
+		encrypted, err := Encrypt(testSuite.Encrypt.Cert, testSuite.Encrypt.Plaintext, EncryptOptions{
+			KeyName: keyName,
+		})
+		c.Assert(err, IsNil)
+
+		result, err := DecryptDetailed(nil, encrypted, DecryptOptions{KeyStore: keyStore})
+		c.Assert(err, IsNil, Commentf("KeyName %q", keyName))
+		c.Assert(result.KeyName, Equals, "current")
+		c.Assert(strings.Contains(string(result.Plaintext), "EncryptedData"), Equals, false)
+	}
+
+	wrongStore, err := NewKeyStore()
+	c.Assert(err, IsNil)
+	defer wrongStore.Close()
+	c.Assert(wrongStore.AddKey("old", testSuite.Chain.Chain.LeafKeyPEM, ""), IsNil)
+	encrypted, err := Encrypt(testSuite.Encrypt.Cert, testSuite.Encrypt.Plaintext, EncryptOptions{})
+	c.Assert(err, IsNil)
+	_, err = DecryptDetailed(nil, encrypted, DecryptOptions{KeyStore: wrongStore})
+	c.Assert(err, NotNil)
+}
+
+func (testSuite *KeyStoreTest) TestDecryptDocumentKeyIgnored(c *C) {
+	emptyStore, err := NewKeyStore()
+	c.Assert(err, IsNil)
+	defer emptyStore.Close()
+	keyStore, err := NewKeyStore()
+	c.Assert(err, IsNil)
+	defer keyStore.Close()
+	c.Assert(keyStore.AddKey("current", testSuite.Encrypt.Key, ""), IsNil)
+
+	encrypted := selfKeyedEncryption(c)
+	for _, store := range []*KeyStore{emptyStore, keyStore} {
+		result, err := DecryptDetailed(nil, encrypted, DecryptOptions{KeyStore: store})
+		c.Assert(err, NotNil)
+		c.Assert(result, IsNil)
+	}
+
+	_, err = Decrypt(testSuite.Encrypt.Key, encrypted)
+	c.Assert(err, NotNil)
+}
+
+func (testSuite *KeyStoreTest) TestConcurrentVerify(c *C) {
+	keyStore, err := NewKeyStore()
+	c.Assert(err, IsNil)
+	defer keyStore.Close()
+	c.Assert(keyStore.AddCertificate("", testSuite.DSig.Cert), IsNil)
+
+	signed, err := Sign(testSuite.DSig.Key, testSuite.DSig.DocStr, SignatureOptions{})
+	c.Assert(err, IsNil)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 64)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- Verify(nil, signed, SignatureOptions{KeyStore: keyStore})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		c.Assert(err, IsNil)
+	}
+}
+
+func (testSuite *KeyStoreTest) TestClosed(c *C) {
+	keyStore, err := NewKeyStore()
+	c.Assert(err, IsNil)
+	keyStore.Close()
+
+	err = keyStore.AddCertificate("", testSuite.DSig.Cert)
+	c.Assert(err, ErrorMatches, "KeyStore is closed")
+	err = Verify(nil, testSuite.DSig.DocStr, SignatureOptions{KeyStore: keyStore})
+	c.Assert(err, ErrorMatches, "KeyStore is closed")
+}
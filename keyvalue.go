@@ -0,0 +1,212 @@
+package xmlsec
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"unsafe"
+)
+
+// #include <stdlib.h>
+// #include <xmlsec/xmlsec.h>
+// #include <xmlsec/xmltree.h>
+// #include <xmlsec/strings.h>
+// #include <xmlsec/templates.h>
+import "C"
+
+// #include <libxml/tree.h>
+import "C"
+
+// global string constants
+// Note: the invocations of C.CString() here return a pointer to a string
+// allocated from the C heap that would normally need to freed by calling
+// C.free, but because these are global, we can just leak them.
+var (
+	constDsig11Namespace = (*C.xmlChar)(unsafe.Pointer(C.CString("http://www.w3.org/2009/xmldsig11#")))
+	constECKeyValue      = (*C.xmlChar)(unsafe.Pointer(C.CString("ECKeyValue")))
+	constNamedCurve      = (*C.xmlChar)(unsafe.Pointer(C.CString("NamedCurve")))
+	constPublicKey       = (*C.xmlChar)(unsafe.Pointer(C.CString("PublicKey")))
+	constURI             = (*C.xmlChar)(unsafe.Pointer(C.CString("URI")))
+)
+
+// namedCurveURIs maps the elliptic curves to the URIs that identify them in
+// an ECKeyValue element (XMLDSIG 1.1, section 4.5.2.3).
+var namedCurveURIs = map[elliptic.Curve]string{
+	elliptic.P256(): "urn:oid:1.2.840.10045.3.1.7",
+	elliptic.P384(): "urn:oid:1.3.132.0.34",
+	elliptic.P521(): "urn:oid:1.3.132.0.35",
+}
+
+// detachedNode is a node that has been temporarily removed from a document.
+type detachedNode struct {
+	Node   *C.xmlNode
+	Parent *C.xmlNode
+	Next   *C.xmlNode
+}
+
+// Restore puts the node back where it was.
+func (n detachedNode) Restore() {
+	if n.Next != nil {
+		C.xmlAddPrevSibling(n.Next, n.Node)
+	} else {
+		C.xmlAddChild(n.Parent, n.Node)
+	}
+}
+
+// detachKeyValueNodes removes the empty KeyValue elements from the KeyInfo of
+// the signature template signatureNode, so that xmlsec does not try to fill
+// them in. The caller must restore them.
+func detachKeyValueNodes(signatureNode *C.xmlNode) []detachedNode {
+	keyInfoNode := firstDSigChild(signatureNode, (*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeKeyInfo)))
+	keyValueName := (*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeKeyValue))
+
+	nodes := []detachedNode{}
+	for node := firstDSigChild(keyInfoNode, keyValueName); node != nil; {
+		next := nextDSigElement(node.next, keyValueName)
+		if C.xmlSecGetNextElementNode(node.children) == nil {
+			nodes = append(nodes, detachedNode{Node: node, Parent: node.parent, Next: node.next})
+			C.xmlUnlinkNode(node)
+		}
+		node = next
+	}
+	return nodes
+}
+
+// writeKeyValue adds the representation of publicKey to the KeyValue element
+// keyValueNode.
+func writeKeyValue(keyValueNode *C.xmlNode, publicKey crypto.PublicKey) error {
+	switch publicKey := publicKey.(type) {
+	case *rsa.PublicKey:
+		rsaKeyValueNode := C.xmlSecAddChild(keyValueNode,
+			(*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeRSAKeyValue)), constDsigNamespace)
+		if rsaKeyValueNode == nil {
+			return mustPopError()
+		}
+		if err := addChildWithContent(rsaKeyValueNode, (*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeRSAModulus)),
+			constDsigNamespace, formatBase64(publicKey.N.Bytes())); err != nil {
+			return err
+		}
+		return addChildWithContent(rsaKeyValueNode, (*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeRSAExponent)),
+			constDsigNamespace, formatBase64(big.NewInt(int64(publicKey.E)).Bytes()))
+
+	case *ecdsa.PublicKey:
+		curveURI, ok := namedCurveURIs[publicKey.Curve]
+		if !ok {
+			return fmt.Errorf("unsupported elliptic curve %s", publicKey.Curve.Params().Name)
+		}
+		ecKeyValueNode := C.xmlSecAddChild(keyValueNode, constECKeyValue, constDsig11Namespace)
+		if ecKeyValueNode == nil {
+			return mustPopError()
+		}
+		namedCurveNode := C.xmlSecAddChild(ecKeyValueNode, constNamedCurve, constDsig11Namespace)
+		if namedCurveNode == nil {
+			return mustPopError()
+		}
+		uri := C.CString(curveURI)
+		defer C.free(unsafe.Pointer(uri))
+		C.xmlSetProp(namedCurveNode, constURI, (*C.xmlChar)(unsafe.Pointer(uri)))
+
+		point := elliptic.Marshal(publicKey.Curve, publicKey.X, publicKey.Y)
+		return addChildWithContent(ecKeyValueNode, constPublicKey, constDsig11Namespace, formatBase64(point))
+
+	default:
+		return fmt.Errorf("cannot write KeyValue for key of type %T", publicKey)
+	}
+}
+
+// writeX509Identifiers adds the elements selected by opts that identify cert
+// to the X509Data element x509DataNode. Empty elements of the same name in
+// the template are filled in rather than duplicated.
+func writeX509Identifiers(x509DataNode *C.xmlNode, cert *x509.Certificate, opts X509DataOptions) error {
+	dsigNs := (*C.xmlChar)(unsafe.Pointer(&C.xmlSecDSigNs))
+
+	// xmlsec only writes the certificates into an X509Data element that
+	// has other content if the template asks for them explicitly.
+	if C.xmlSecFindChild(x509DataNode, (*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeX509Certificate)), dsigNs) == nil {
+		if C.xmlSecTmplX509DataAddCertificate(x509DataNode) == nil {
+			return mustPopError()
+		}
+	}
+
+	// emptyChild returns the empty child of x509DataNode named name, adding
+	// it if needed, or nil if the template already has a value for it.
+	emptyChild := func(name *C.xmlChar) (*C.xmlNode, error) {
+		node := C.xmlSecFindChild(x509DataNode, name, dsigNs)
+		if node == nil {
+			if node = C.xmlSecAddChild(x509DataNode, name, dsigNs); node == nil {
+				return nil, mustPopError()
+			}
+			return node, nil
+		}
+		if C.xmlSecIsEmptyNode(node) != 1 {
+			return nil, nil
+		}
+		return node, nil
+	}
+
+	if opts.SubjectName {
+		node, err := emptyChild((*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeX509SubjectName)))
+		if err != nil {
+			return err
+		}
+		if node != nil {
+			setContent(node, cert.Subject.String())
+		}
+	}
+
+	if opts.IssuerSerial {
+		node, err := emptyChild((*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeX509IssuerSerial)))
+		if err != nil {
+			return err
+		}
+		if node != nil {
+			if err := addChildWithContent(node, (*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeX509IssuerName)),
+				dsigNs, cert.Issuer.String()); err != nil {
+				return err
+			}
+			if err := addChildWithContent(node, (*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeX509SerialNumber)),
+				dsigNs, cert.SerialNumber.String()); err != nil {
+				return err
+			}
+		}
+	}
+
+	if opts.SKI {
+		if len(cert.SubjectKeyId) == 0 {
+			return errors.New("certificate does not have a subject key identifier")
+		}
+		node, err := emptyChild((*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeX509SKI)))
+		if err != nil {
+			return err
+		}
+		if node != nil {
+			setContent(node, formatBase64(cert.SubjectKeyId))
+		}
+	}
+	return nil
+}
+
+// addChildWithContent adds a child element to parent whose text is content.
+func addChildWithContent(parent *C.xmlNode, name, ns *C.xmlChar, content string) error {
+	node := C.xmlSecAddChild(parent, name, ns)
+	if node == nil {
+		return mustPopError()
+	}
+	setContent(node, content)
+	return nil
+}
+
+// setContent replaces the children of node with the text content. Unlike
+// xmlNodeSetContent alone, it does not interpret entity references in
+// content, such as in a subject name containing '&'.
+func setContent(node *C.xmlNode, content string) {
+	cContent := C.CString(content)
+	defer C.free(unsafe.Pointer(cContent))
+	C.xmlNodeSetContent(node, nil)
+	C.xmlNodeAddContent(node, (*C.xmlChar)(unsafe.Pointer(cContent)))
+}
@@ -0,0 +1,226 @@
+package xmlsec
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// maxOCSPResponseSize bounds the size of the OCSP responses we read.
+const maxOCSPResponseSize = 1 << 20
+
+// defaultOCSPTimeout bounds the time taken by an OCSP request when the
+// Timeout of the OCSPChecker is zero.
+const defaultOCSPTimeout = 10 * time.Second
+
+// OCSPChecker checks the revocation status of the certificates of
+// signatures using OCSP (RFC 6960). Set it in SignatureOptions to make
+// VerifyTrusted check the signing certificate. The responses are cached
+// until their nextUpdate time, so the same OCSPChecker should be reused.
+// Expired responses are dropped from the cache.
+// It is safe for concurrent use.
+type OCSPChecker struct {
+	// Transport sends the OCSP requests. If nil, http.DefaultTransport is
+	// used. Tests may provide a RoundTripper that answers in process.
+	Transport http.RoundTripper
+
+	// Responder is the URL of the OCSP responder. If empty, the first OCSP
+	// server of the certificate being checked is used.
+	Responder string
+
+	// Timeout bounds the time taken by each request to the responder,
+	// including reading the response. If zero, 10 seconds is used.
+	Timeout time.Duration
+
+	mu    sync.Mutex
+	cache map[string]*ocsp.Response
+}
+
+// OCSPError is returned from VerifyTrusted when the revocation status of the
+// signing certificate cannot be determined with OCSP.
+type OCSPError struct {
+	// Certificate is the certificate whose status was requested. It is nil
+	// if the signature was not verified with a certificate.
+	Certificate *x509.Certificate
+
+	// Err describes what went wrong.
+	Err error
+}
+
+func (e OCSPError) Error() string {
+	if e.Certificate == nil {
+		return fmt.Sprintf("cannot check the OCSP status of the signature: %s", e.Err)
+	}
+	return fmt.Sprintf("cannot check the OCSP status of certificate %q: %s",
+		e.Certificate.Subject.String(), e.Err)
+}
+
+func (e OCSPError) Unwrap() error {
+	return e.Err
+}
+
+// check returns an error if the signing certificate of result, as verified
+// by VerifyTrusted, is revoked or its status cannot be determined. A
+// CertificateRevokedError is returned for a revoked certificate, and an
+// OCSPError otherwise, including when there is no certificate or issuer to
+// ask about.
+func (checker *OCSPChecker) check(result *VerificationResult) error {
+	leaf := result.Certificate
+	if leaf == nil {
+		return OCSPError{Err: errors.New("the signature was not verified with a certificate")}
+	}
+	if len(result.Chain) < 2 {
+		return OCSPError{Certificate: leaf, Err: errors.New("the issuer of the certificate is not known")}
+	}
+	issuer := result.Chain[1]
+
+	response, err := checker.response(leaf, issuer)
+	if err != nil {
+		return OCSPError{Certificate: leaf, Err: err}
+	}
+	switch response.Status {
+	case ocsp.Good:
+		return nil
+	case ocsp.Revoked:
+		return CertificateRevokedError{
+			Issuer:       issuer.Subject.String(),
+			SerialNumber: leaf.SerialNumber,
+		}
+	default:
+		return OCSPError{Certificate: leaf, Err: errors.New("the responder does not know the certificate")}
+	}
+}
+
+// response returns a current OCSP response for cert, which was issued by
+// issuer, from the cache or else from the responder.
+func (checker *OCSPChecker) response(cert, issuer *x509.Certificate) (*ocsp.Response, error) {
+	// like the CertID of the request, the key identifies the issuer by its
+	// public key, since different issuers may have the same name
+	issuerKeyHash := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+	key := string(issuerKeyHash[:]) + "/" + cert.SerialNumber.String()
+	now := time.Now()
+
+	checker.mu.Lock()
+	response, ok := checker.cache[key]
+	if ok && !now.Before(response.NextUpdate) {
+		delete(checker.cache, key)
+		ok = false
+	}
+	checker.mu.Unlock()
+	if ok {
+		return response, nil
+	}
+
+	response, err := checker.fetch(cert, issuer)
+	if err != nil {
+		return nil, err
+	}
+	if response.ThisUpdate.After(now.Add(time.Minute)) {
+		return nil, errors.New("the response is not yet valid")
+	}
+	if !response.NextUpdate.IsZero() && response.NextUpdate.Before(now) {
+		return nil, errors.New("the response has expired")
+	}
+
+	// responses without a nextUpdate time are not cached, as RFC 5019
+	// suggests that newer information is always available
+	if !response.NextUpdate.IsZero() {
+		checker.mu.Lock()
+		if checker.cache == nil {
+			checker.cache = map[string]*ocsp.Response{}
+		}
+		for cachedKey, cached := range checker.cache {
+			if !now.Before(cached.NextUpdate) {
+				delete(checker.cache, cachedKey)
+			}
+		}
+		checker.cache[key] = response
+		checker.mu.Unlock()
+	}
+	return response, nil
+}
+
+// fetch asks the responder for the status of cert, which was issued by
+// issuer, and returns its validated response.
+func (checker *OCSPChecker) fetch(cert, issuer *x509.Certificate) (*ocsp.Response, error) {
+	responder := checker.Responder
+	if responder == "" {
+		if len(cert.OCSPServer) == 0 {
+			return nil, errors.New("the certificate has no OCSP responder")
+		}
+		responder = cert.OCSPServer[0]
+	}
+
+	request, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+	timeout := checker.Timeout
+	if timeout == 0 {
+		timeout = defaultOCSPTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	httpRequest, err := http.NewRequestWithContext(ctx, "POST", responder, bytes.NewReader(request))
+	if err != nil {
+		return nil, err
+	}
+	httpRequest.Header.Set("Content-Type", "application/ocsp-request")
+	httpRequest.Header.Set("Accept", "application/ocsp-response")
+
+	transport := checker.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	httpResponse, err := transport.RoundTrip(httpRequest)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResponse.Body.Close()
+	if httpResponse.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the responder returned %s", httpResponse.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(httpResponse.Body, maxOCSPResponseSize))
+	if err != nil {
+		return nil, err
+	}
+
+	// ParseResponseForCert checks that the response is signed by issuer,
+	// or by a responder certificate that issuer has signed.
+	response, err := ocsp.ParseResponseForCert(body, cert, issuer)
+	if err != nil {
+		return nil, err
+	}
+	if response.Certificate != nil && !response.Certificate.Equal(issuer) {
+		if err := checkOCSPResponder(response.Certificate); err != nil {
+			return nil, err
+		}
+	}
+	return response, nil
+}
+
+// checkOCSPResponder returns an error if the certificate responder, which
+// the issuer of the certificate being checked has signed, may not sign OCSP
+// responses for it.
+func checkOCSPResponder(responder *x509.Certificate) error {
+	now := time.Now()
+	if now.Before(responder.NotBefore) || now.After(responder.NotAfter) {
+		return errors.New("the certificate of the responder is not valid")
+	}
+	for _, usage := range responder.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageOCSPSigning {
+			return nil
+		}
+	}
+	return errors.New("the responder is not authorized to sign OCSP responses")
+}
@@ -0,0 +1,269 @@
+package xmlsec
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+	. "gopkg.in/check.v1"
+)
+
+// roundTripperFunc is an http.RoundTripper that calls itself.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+type OCSPTest struct {
+	Chain    ChainTest
+	Signed   []byte
+	Requests int
+}
+
+var _ = Suite(&OCSPTest{})
+
+func (testSuite *OCSPTest) SetUpSuite(c *C) {
+	testSuite.Chain.Chain = newTestChain(c, func(leaf *x509.Certificate) {
+		leaf.OCSPServer = []string{"http://ocsp.example.com/"}
+	})
+}
+
+func (testSuite *OCSPTest) SetUpTest(c *C) {
+	testSuite.Chain.SetUpTest(c)
+	testSuite.Requests = 0
+
+	chain := testSuite.Chain.Chain
+	var err error
+	testSuite.Signed, err = Sign(chain.LeafKeyPEM, testSuite.Chain.DocStr, SignatureOptions{
+		Certificates: []*x509.Certificate{chain.Leaf, chain.Intermediate},
+	})
+	c.Assert(err, IsNil)
+}
+
+// responder returns a transport that answers OCSP requests with template,
+// signed by the certificate responderCert with responderKey.
+func (testSuite *OCSPTest) responder(c *C, template ocsp.Response, responderCert *x509.Certificate, responderKey crypto.Signer) http.RoundTripper {
+	chain := testSuite.Chain.Chain
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		testSuite.Requests++
+		c.Assert(req.URL.String(), Equals, "http://ocsp.example.com/")
+		c.Assert(req.Header.Get("Content-Type"), Equals, "application/ocsp-request")
+
+		body, err := io.ReadAll(req.Body)
+		c.Assert(err, IsNil)
+		request, err := ocsp.ParseRequest(body)
+		c.Assert(err, IsNil)
+		c.Assert(request.SerialNumber.Cmp(chain.Leaf.SerialNumber), Equals, 0)
+
+		template.SerialNumber = request.SerialNumber
+		if template.ThisUpdate.IsZero() {
+			template.ThisUpdate = time.Now().Add(-time.Minute)
+		}
+		if responderCert != chain.Intermediate {
+			template.Certificate = responderCert
+		}
+		response, err := ocsp.CreateResponse(chain.Intermediate, responderCert, template, responderKey)
+		c.Assert(err, IsNil)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Status:     "200 OK",
+			Body:       io.NopCloser(bytes.NewReader(response)),
+		}, nil
+	})
+}
+
+func (testSuite *OCSPTest) verify(checker *OCSPChecker) error {
+	return VerifyTrusted([][]byte{testSuite.Chain.Chain.RootPEM}, testSuite.Signed, SignatureOptions{OCSP: checker})
+}
+
+func (testSuite *OCSPTest) TestGood(c *C) {
+	chain := testSuite.Chain.Chain
+	checker := &OCSPChecker{
+		Transport: testSuite.responder(c, ocsp.Response{
+			Status:     ocsp.Good,
+			NextUpdate: time.Now().Add(time.Hour),
+		}, chain.Intermediate, chain.IntermediateKey),
+	}
+
+	c.Assert(testSuite.verify(checker), IsNil)
+	c.Assert(testSuite.Requests, Equals, 1)
+
+	// the response is cached until its nextUpdate time
+	c.Assert(testSuite.verify(checker), IsNil)
+	c.Assert(testSuite.Requests, Equals, 1)
+
+	// responses without a nextUpdate time are not cached
+	checker = &OCSPChecker{
+		Transport: testSuite.responder(c, ocsp.Response{Status: ocsp.Good}, chain.Intermediate, chain.IntermediateKey),
+	}
+	c.Assert(testSuite.verify(checker), IsNil)
+	c.Assert(testSuite.verify(checker), IsNil)
+	c.Assert(testSuite.Requests, Equals, 3)
+}
+
+func (testSuite *OCSPTest) TestCache(c *C) {
+	chain := testSuite.Chain.Chain
+	checker := &OCSPChecker{
+		Transport: testSuite.responder(c, ocsp.Response{
+			Status:     ocsp.Good,
+			NextUpdate: time.Now().Add(time.Hour),
+		}, chain.Intermediate, chain.IntermediateKey),
+	}
+	c.Assert(testSuite.verify(checker), IsNil)
+	c.Assert(checker.cache, HasLen, 1)
+
+	// another issuer with the same name issued a certificate with the same
+	// serial number, whose status is not the one in the cache. The
+	// responder answers for the first issuer, so the response is rejected.
+	other := newTestChain(c, func(leaf *x509.Certificate) {
+		leaf.OCSPServer = []string{"http://ocsp.example.com/"}
+	})
+	c.Assert(other.Intermediate.RawSubject, DeepEquals, chain.Intermediate.RawSubject)
+	_, err := checker.response(other.Leaf, other.Intermediate)
+	c.Assert(err, NotNil)
+	c.Assert(testSuite.Requests, Equals, 2)
+
+	// expired responses are dropped from the cache
+	for _, response := range checker.cache {
+		response.NextUpdate = time.Now().Add(-time.Minute)
+	}
+	checker.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, errors.New("the responder is down")
+	})
+	c.Assert(testSuite.verify(checker), ErrorMatches, `.*the responder is down`)
+	c.Assert(checker.cache, HasLen, 0)
+}
+
+func (testSuite *OCSPTest) TestRevoked(c *C) {
+	chain := testSuite.Chain.Chain
+	err := testSuite.verify(&OCSPChecker{
+		Transport: testSuite.responder(c, ocsp.Response{
+			Status:           ocsp.Revoked,
+			RevokedAt:        time.Now().Add(-time.Hour),
+			RevocationReason: ocsp.KeyCompromise,
+		}, chain.Intermediate, chain.IntermediateKey),
+	})
+	c.Assert(err, ErrorMatches, "certificate 3 issued by CN=go-xmlsec test intermediate is revoked")
+	c.Assert(err, FitsTypeOf, CertificateRevokedError{})
+
+	err = testSuite.verify(&OCSPChecker{
+		Transport: testSuite.responder(c, ocsp.Response{Status: ocsp.Unknown}, chain.Intermediate, chain.IntermediateKey),
+	})
+	c.Assert(err, ErrorMatches, `cannot check the OCSP status of certificate "CN=go-xmlsec test signer": the responder does not know the certificate`)
+	c.Assert(err, FitsTypeOf, OCSPError{})
+}
+
+func (testSuite *OCSPTest) TestInvalidResponse(c *C) {
+	chain := testSuite.Chain.Chain
+
+	// signed by a key other than that of the issuer
+	err := testSuite.verify(&OCSPChecker{
+		Transport: testSuite.responder(c, ocsp.Response{Status: ocsp.Good}, chain.Intermediate, chain.RootKey),
+	})
+	c.Assert(err, ErrorMatches, `cannot check the OCSP status of certificate .*: .*verification error`)
+
+	// signed by a delegated responder that may not sign OCSP responses
+	newResponder := func(extKeyUsage []x509.ExtKeyUsage) (*x509.Certificate, *rsa.PrivateKey) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		c.Assert(err, IsNil)
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(4),
+			Subject:      pkix.Name{CommonName: "go-xmlsec test responder"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			ExtKeyUsage:  extKeyUsage,
+		}, chain.Intermediate, key.Public(), chain.IntermediateKey)
+		c.Assert(err, IsNil)
+		cert, err := x509.ParseCertificate(der)
+		c.Assert(err, IsNil)
+		return cert, key
+	}
+	responderCert, responderKey := newResponder(nil)
+	err = testSuite.verify(&OCSPChecker{
+		Transport: testSuite.responder(c, ocsp.Response{Status: ocsp.Good}, responderCert, responderKey),
+	})
+	c.Assert(err, ErrorMatches, `.*: the responder is not authorized to sign OCSP responses`)
+
+	responderCert, responderKey = newResponder([]x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning})
+	err = testSuite.verify(&OCSPChecker{
+		Transport: testSuite.responder(c, ocsp.Response{Status: ocsp.Good}, responderCert, responderKey),
+	})
+	c.Assert(err, IsNil)
+
+	// expired
+	err = testSuite.verify(&OCSPChecker{
+		Transport: testSuite.responder(c, ocsp.Response{
+			Status:     ocsp.Good,
+			ThisUpdate: time.Now().Add(-2 * time.Hour),
+			NextUpdate: time.Now().Add(-time.Hour),
+		}, chain.Intermediate, chain.IntermediateKey),
+	})
+	c.Assert(err, ErrorMatches, `.*: the response has expired`)
+
+	// not answered
+	err = testSuite.verify(&OCSPChecker{
+		Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusInternalServerError,
+				Status:     "500 Internal Server Error",
+				Body:       io.NopCloser(bytes.NewReader(nil)),
+			}, nil
+		}),
+	})
+	c.Assert(err, ErrorMatches, `.*: the responder returned 500 Internal Server Error`)
+}
+
+func (testSuite *OCSPTest) TestNoResponder(c *C) {
+	chain := newTestChain(c, nil)
+	signed, err := Sign(chain.LeafKeyPEM, testSuite.Chain.DocStr, SignatureOptions{
+		Certificates: []*x509.Certificate{chain.Leaf, chain.Intermediate},
+	})
+	c.Assert(err, IsNil)
+
+	err = VerifyTrusted([][]byte{chain.RootPEM}, signed, SignatureOptions{OCSP: &OCSPChecker{}})
+	c.Assert(err, ErrorMatches, `.*: the certificate has no OCSP responder`)
+}
+
+func (testSuite *OCSPTest) TestTimeout(c *C) {
+	checker := &OCSPChecker{
+		Timeout: 10 * time.Millisecond,
+		Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		}),
+	}
+	err := testSuite.verify(checker)
+	c.Assert(err, ErrorMatches, `.*: context deadline exceeded`)
+	c.Assert(errors.Is(err, context.DeadlineExceeded), Equals, true)
+}
+
+func (testSuite *OCSPTest) TestNoIssuer(c *C) {
+	chain := testSuite.Chain.Chain
+	checker := &OCSPChecker{
+		Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			c.Fatal("unexpected OCSP request")
+			return nil, nil
+		}),
+	}
+
+	// the signing certificate is trusted in its own right, so there is no
+	// issuer to ask about it
+	err := checker.check(&VerificationResult{Certificate: chain.Leaf, Chain: []*x509.Certificate{chain.Leaf}})
+	c.Assert(err, ErrorMatches, `cannot check the OCSP status of certificate "CN=go-xmlsec test signer": the issuer of the certificate is not known`)
+
+	err = checker.check(&VerificationResult{})
+	c.Assert(err, ErrorMatches, `cannot check the OCSP status of the signature: the signature was not verified with a certificate`)
+	_, ok := err.(OCSPError)
+	c.Assert(ok, Equals, true)
+}
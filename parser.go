@@ -0,0 +1,268 @@
+package xmlsec
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+)
+
+// #include <stdlib.h>
+// #include <libxml/parser.h>
+// #include <libxml/parserInternals.h>
+// #include <libxml/tree.h>
+// #include <libxml/valid.h>
+// #include <xmlsec/xmltree.h>
+//
+// #define MY_PARSER_ERROR_DEPTH 1
+// #define MY_PARSER_ERROR_ENTITY_REFERENCES 2
+// #define MY_PARSER_ERROR_EXTERNAL_ENTITY 3
+//
+// // MY_parserState holds the limits of a parser and what it has seen so
+// // far. It is stored in the _private field of the parser context.
+// typedef struct {
+//   int maxDepth;
+//   int maxEntityReferences;
+//   int entityReferences;
+//   int error;
+//   startElementNsSAX2Func startElementNs;
+//   getEntitySAXFunc getEntity;
+//   getParameterEntitySAXFunc getParameterEntity;
+//   entityDeclSAXFunc entityDecl;
+// } MY_parserState;
+//
+// static MY_parserState *MY_parserGetState(void *ctx) {
+//   return (MY_parserState *)((xmlParserCtxtPtr)ctx)->_private;
+// }
+//
+// static void MY_parserFail(void *ctx, int error) {
+//   MY_parserState *state = MY_parserGetState(ctx);
+//   if (state->error == 0) {
+//     state->error = error;
+//   }
+//   xmlStopParser((xmlParserCtxtPtr)ctx);
+// }
+//
+// static void MY_parserStartElementNs(void *ctx, const xmlChar *localname,
+//     const xmlChar *prefix, const xmlChar *URI, int nb_namespaces,
+//     const xmlChar **namespaces, int nb_attributes, int nb_defaulted,
+//     const xmlChar **attributes) {
+//   MY_parserState *state = MY_parserGetState(ctx);
+//   // nameNr counts the ancestors of the element
+//   if (state->maxDepth > 0 && ((xmlParserCtxtPtr)ctx)->nameNr >= state->maxDepth) {
+//     MY_parserFail(ctx, MY_PARSER_ERROR_DEPTH);
+//     return;
+//   }
+//   state->startElementNs(ctx, localname, prefix, URI, nb_namespaces,
+//     namespaces, nb_attributes, nb_defaulted, attributes);
+// }
+//
+// static int MY_parserCountEntityReference(void *ctx) {
+//   MY_parserState *state = MY_parserGetState(ctx);
+//   state->entityReferences++;
+//   if (state->maxEntityReferences > 0 && state->entityReferences > state->maxEntityReferences) {
+//     MY_parserFail(ctx, MY_PARSER_ERROR_ENTITY_REFERENCES);
+//     return -1;
+//   }
+//   return 0;
+// }
+//
+// static xmlEntityPtr MY_parserGetEntity(void *ctx, const xmlChar *name) {
+//   MY_parserState *state = MY_parserGetState(ctx);
+//   if (MY_parserCountEntityReference(ctx) < 0) {
+//     return NULL;
+//   }
+//   return state->getEntity(ctx, name);
+// }
+//
+// static xmlEntityPtr MY_parserGetParameterEntity(void *ctx, const xmlChar *name) {
+//   MY_parserState *state = MY_parserGetState(ctx);
+//   if (MY_parserCountEntityReference(ctx) < 0) {
+//     return NULL;
+//   }
+//   return state->getParameterEntity(ctx, name);
+// }
+//
+// static void MY_parserEntityDecl(void *ctx, const xmlChar *name, int type,
+//     const xmlChar *publicId, const xmlChar *systemId, xmlChar *content) {
+//   MY_parserState *state = MY_parserGetState(ctx);
+//   switch (type) {
+//   case XML_EXTERNAL_GENERAL_PARSED_ENTITY:
+//   case XML_EXTERNAL_GENERAL_UNPARSED_ENTITY:
+//   case XML_EXTERNAL_PARAMETER_ENTITY:
+//     MY_parserFail(ctx, MY_PARSER_ERROR_EXTERNAL_ENTITY);
+//     return;
+//   }
+//   state->entityDecl(ctx, name, type, publicId, systemId, content);
+// }
+//
+// // MY_parserInit applies the options of state to ctxt, which keeps a
+// // reference to state until it is freed.
+// static void MY_parserInit(xmlParserCtxtPtr ctxt, MY_parserState *state) {
+//   xmlCtxtUseOptions(ctxt, XML_PARSE_NONET);
+//   ctxt->_private = state;
+//
+//   state->startElementNs = ctxt->sax->startElementNs;
+//   state->getEntity = ctxt->sax->getEntity;
+//   state->getParameterEntity = ctxt->sax->getParameterEntity;
+//   state->entityDecl = ctxt->sax->entityDecl;
+//   ctxt->sax->startElementNs = MY_parserStartElementNs;
+//   ctxt->sax->getEntity = MY_parserGetEntity;
+//   ctxt->sax->getParameterEntity = MY_parserGetParameterEntity;
+//   ctxt->sax->entityDecl = MY_parserEntityDecl;
+// }
+import "C"
+
+// The default limits of ParserOptions.
+const (
+	DefaultMaxDocumentSize     = 16 << 20
+	DefaultMaxDepth            = 256
+	DefaultMaxEntityReferences = 1000
+)
+
+// ParserOptions configures the XML parser that reads the documents passed
+// to this package. The zero value is a hardened configuration: the parser
+// never accesses the network, does not load external DTDs, rejects
+// documents that declare external entities, and limits the size and nesting
+// depth of documents and the number of entity references in them. The
+// entity amplification limits of libxml2 also apply.
+type ParserOptions struct {
+	// MaxSize is the maximum size of a document in bytes. If zero,
+	// DefaultMaxDocumentSize is used. If negative, the size is not limited.
+	MaxSize int
+
+	// MaxDepth is the maximum nesting depth of the elements of a document,
+	// where the root element has a depth of 1. If zero, DefaultMaxDepth is
+	// used. If negative, only the limit of libxml2 applies.
+	MaxDepth int
+
+	// MaxEntityReferences is the maximum number of entity references that
+	// are resolved while parsing a document, including those in the
+	// replacement text of other entities. If zero,
+	// DefaultMaxEntityReferences is used. If negative, the number is not
+	// limited.
+	MaxEntityReferences int
+
+	// DTDIDs makes the attributes declared with type ID by ATTLIST
+	// declarations in the internal DTD subset of a document usable as the
+	// targets of References, like those of XMLIDOption. By default such
+	// declarations are ignored, because they let whoever wrote the document
+	// decide which elements a signature covers.
+	DTDIDs bool
+}
+
+// ParserLimitError is returned when a document exceeds one of the limits
+// of ParserOptions.
+type ParserLimitError struct {
+	// Limit names the limit: "size", "depth" or "number of entity
+	// references".
+	Limit string
+
+	// Max is the value of the limit.
+	Max int
+}
+
+func (e ParserLimitError) Error() string {
+	return fmt.Sprintf("document exceeds the maximum %s of %d", e.Limit, e.Max)
+}
+
+// xmlNamespace is the namespace of the xml prefix, as in xml:id.
+const xmlNamespace = "http://www.w3.org/XML/1998/namespace"
+
+// errExternalEntity is returned when a document declares an external entity.
+var errExternalEntity = errors.New("external entities are not allowed")
+
+// limit returns value, or def if value is zero, or zero (no limit) if value
+// is negative.
+func limit(value, def int) int {
+	switch {
+	case value == 0:
+		return def
+	case value < 0:
+		return 0
+	default:
+		return value
+	}
+}
+
+// newDoc parses buf according to opts and registers the ID attributes of
+// idattrs. The caller must free the returned document with closeDoc.
+func newDoc(buf []byte, idattrs []XMLIDOption, opts ParserOptions) (*C.xmlDoc, error) {
+	if len(buf) == 0 {
+		return nil, errors.New("empty document")
+	}
+	if maxSize := limit(opts.MaxSize, DefaultMaxDocumentSize); maxSize > 0 && len(buf) > maxSize {
+		return nil, ParserLimitError{Limit: "size", Max: maxSize}
+	}
+
+	ctx := C.xmlCreateMemoryParserCtxt((*C.char)(unsafe.Pointer(&buf[0])),
+		C.int(len(buf)))
+	if ctx == nil {
+		return nil, mustPopError()
+	}
+	defer C.xmlFreeParserCtxt(ctx)
+
+	// the state is referenced by the parser context, so it must not be
+	// allocated by Go
+	state := (*C.MY_parserState)(C.calloc(1, C.sizeof_MY_parserState))
+	if state == nil {
+		return nil, errors.New("out of memory")
+	}
+	defer C.free(unsafe.Pointer(state))
+	state.maxDepth = C.int(limit(opts.MaxDepth, DefaultMaxDepth))
+	state.maxEntityReferences = C.int(limit(opts.MaxEntityReferences, DefaultMaxEntityReferences))
+	C.MY_parserInit(ctx, state)
+
+	C.xmlParseDocument(ctx)
+
+	doc := ctx.myDoc
+	if state.error != 0 || ctx.wellFormed == C.int(0) {
+		if doc != nil {
+			C.xmlFreeDoc(doc)
+		}
+		switch state.error {
+		case C.MY_PARSER_ERROR_DEPTH:
+			popError()
+			return nil, ParserLimitError{Limit: "depth", Max: int(state.maxDepth)}
+		case C.MY_PARSER_ERROR_ENTITY_REFERENCES:
+			popError()
+			return nil, ParserLimitError{Limit: "number of entity references", Max: int(state.maxEntityReferences)}
+		case C.MY_PARSER_ERROR_EXTERNAL_ENTITY:
+			popError()
+			return nil, errExternalEntity
+		}
+		return nil, mustPopError()
+	}
+	if doc == nil {
+		return nil, mustPopError()
+	}
+
+	if !opts.DTDIDs && doc.intSubset != nil {
+		removeDTDIDs(C.xmlDocGetRootElement(doc))
+	}
+	for _, idattr := range idattrs {
+		addIDAttr(C.xmlDocGetRootElement(doc), idattr)
+	}
+	return doc, nil
+}
+
+// removeDTDIDs unregisters the ID attributes of node and its descendants
+// that libxml2 registered because of ATTLIST declarations in the DTD. The
+// declarations themselves are kept, so that the document is not changed.
+// xml:id attributes remain IDs.
+func removeDTDIDs(node *C.xmlNode) {
+	for cur := C.xmlSecGetNextElementNode(node.children); cur != nil; cur = C.xmlSecGetNextElementNode(cur.next) {
+		removeDTDIDs(cur)
+	}
+
+	for attr := node.properties; attr != nil; attr = attr.next {
+		if attr.atype != C.XML_ATTRIBUTE_ID {
+			continue
+		}
+		if attr.ns != nil && C.GoString((*C.char)(unsafe.Pointer(attr.ns.href))) == xmlNamespace &&
+			C.GoString((*C.char)(unsafe.Pointer(attr.name))) == "id" {
+			continue
+		}
+		C.xmlRemoveID(node.doc, attr)
+		attr.atype = 0
+	}
+}
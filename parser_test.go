@@ -0,0 +1,179 @@
+package xmlsec
+
+import (
+	"fmt"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type ParserTest struct {
+	DSig XMLDSigTest
+}
+
+var _ = Suite(&ParserTest{})
+
+func (testSuite *ParserTest) SetUpTest(c *C) {
+	testSuite.DSig.SetUpTest(c)
+}
+
+func (testSuite *ParserTest) TestEmpty(c *C) {
+	_, err := Sign(testSuite.DSig.Key, nil, SignatureOptions{})
+	c.Assert(err, ErrorMatches, "empty document")
+
+	err = Verify(testSuite.DSig.Cert, []byte{}, SignatureOptions{})
+	c.Assert(err, ErrorMatches, "empty document")
+}
+
+func (testSuite *ParserTest) TestSize(c *C) {
+	err := Verify(testSuite.DSig.Cert, testSuite.DSig.DocStr, SignatureOptions{
+		Parser: ParserOptions{MaxSize: 100},
+	})
+	c.Assert(err, ErrorMatches, "document exceeds the maximum size of 100")
+	c.Assert(err, Equals, ParserLimitError{Limit: "size", Max: 100})
+}
+
+func (testSuite *ParserTest) TestDepth(c *C) {
+	nested := func(depth int) []byte {
+		return []byte(strings.Repeat("<a>", depth) + strings.Repeat("</a>", depth))
+	}
+
+	err := Verify(testSuite.DSig.Cert, nested(DefaultMaxDepth), SignatureOptions{})
+	c.Assert(err, ErrorMatches, "cannot find start node")
+
+	err = Verify(testSuite.DSig.Cert, nested(DefaultMaxDepth+1), SignatureOptions{})
+	c.Assert(err, Equals, ParserLimitError{Limit: "depth", Max: DefaultMaxDepth})
+
+	err = Verify(testSuite.DSig.Cert, nested(10), SignatureOptions{Parser: ParserOptions{MaxDepth: 5}})
+	c.Assert(err, ErrorMatches, "document exceeds the maximum depth of 5")
+
+	err = Verify(testSuite.DSig.Cert, nested(DefaultMaxDepth+1), SignatureOptions{Parser: ParserOptions{MaxDepth: -1}})
+	c.Assert(err, ErrorMatches, "cannot find start node")
+}
+
+func (testSuite *ParserTest) TestEntities(c *C) {
+	doc := []byte(`<!DOCTYPE a [<!ENTITY e "entity">]><a>` + strings.Repeat("&e;", 10) + `</a>`)
+	err := Verify(testSuite.DSig.Cert, doc, SignatureOptions{})
+	c.Assert(err, ErrorMatches, "cannot find start node")
+
+	err = Verify(testSuite.DSig.Cert, doc, SignatureOptions{Parser: ParserOptions{MaxEntityReferences: 5}})
+	c.Assert(err, Equals, ParserLimitError{Limit: "number of entity references", Max: 5})
+
+	// billion laughs
+	laughs := `<!DOCTYPE a [<!ENTITY lol0 "lol">`
+	for i := 1; i < 10; i++ {
+		laughs += fmt.Sprintf(`<!ENTITY lol%d "%s">`, i, strings.Repeat(fmt.Sprintf("&lol%d;", i-1), 10))
+	}
+	laughs += `]><a>&lol9;</a>`
+	err = Verify(testSuite.DSig.Cert, []byte(laughs), SignatureOptions{})
+	c.Assert(err, NotNil)
+	c.Assert(err, Not(ErrorMatches), "cannot find start node")
+
+	for _, doc := range []string{
+		`<!DOCTYPE a [<!ENTITY e SYSTEM "file:///etc/passwd">]><a>&e;</a>`,
+		`<!DOCTYPE a [<!ENTITY e SYSTEM "http://example.com/">]><a/>`,
+		`<!DOCTYPE a [<!ENTITY % e SYSTEM "http://example.com/">%e;]><a/>`,
+	} {
+		err = Verify(testSuite.DSig.Cert, []byte(doc), SignatureOptions{})
+		c.Assert(err, ErrorMatches, "external entities are not allowed")
+	}
+}
+
+func (testSuite *ParserTest) TestDTDIDs(c *C) {
+	doc, err := InsertSignatureTemplate([]byte(`<!DOCTYPE Envelope [<!ATTLIST Data id ID #IMPLIED>]>
+<Envelope xmlns="urn:envelope"><Data id="data">Hello, World!</Data></Envelope>`), SignatureTemplate{
+		SignatureMethod: "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256",
+		References: []ReferenceTemplate{{
+			URI:          "#data",
+			DigestMethod: "http://www.w3.org/2001/04/xmlenc#sha256",
+		}},
+	}, TemplateLocation{}, SignatureOptions{})
+	c.Assert(err, IsNil)
+
+	opts := SignatureOptions{Parser: ParserOptions{DTDIDs: true}}
+	signed, err := Sign(testSuite.DSig.Key, doc, opts)
+	c.Assert(err, IsNil)
+
+	err = Verify(testSuite.DSig.Cert, signed, opts)
+	c.Assert(err, IsNil)
+
+	// the ID declared by the DTD is ignored by default
+	err = Verify(testSuite.DSig.Cert, signed, SignatureOptions{})
+	c.Assert(err, NotNil)
+	_, err = Sign(testSuite.DSig.Key, doc, SignatureOptions{})
+	c.Assert(err, NotNil)
+}
+
+func (testSuite *ParserTest) TestXMLIDPresets(c *C) {
+	signed, err := Sign(testSuite.DSig.Key, []byte(nestedSignaturesDocStr), SignatureOptions{
+		XMLID:            []XMLIDOption{SAML2ProtocolID, SAML2AssertionID},
+		SignAllTemplates: true,
+	})
+	c.Assert(err, IsNil)
+	err = Verify(testSuite.DSig.Cert, signed, SignatureOptions{
+		XMLID: []XMLIDOption{SAML2ProtocolID, SAML2AssertionID},
+	})
+	c.Assert(err, IsNil)
+
+	for _, t := range []struct {
+		doc   string
+		uri   string
+		xmlid XMLIDOption
+	}{
+		{
+			`<Envelope xmlns="urn:envelope" xmlns:wsu="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd"><Body wsu:Id="body">Hello, World!</Body></Envelope>`,
+			"#body", WSSecurityID,
+		},
+		{
+			`<Envelope xmlns="urn:envelope"><Body xml:id="body">Hello, World!</Body></Envelope>`,
+			"#body", XMLNamespaceID,
+		},
+		{
+			`<Envelope xmlns="urn:envelope"><Body AssertionID="body">Hello, World!</Body></Envelope>`,
+			"#body", XMLIDOption{ElementName: "*", AttributeName: "AssertionID"},
+		},
+	} {
+		doc, err := InsertSignatureTemplate([]byte(t.doc), SignatureTemplate{
+			SignatureMethod: "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256",
+			References: []ReferenceTemplate{{
+				URI:          t.uri,
+				DigestMethod: "http://www.w3.org/2001/04/xmlenc#sha256",
+			}},
+		}, TemplateLocation{}, SignatureOptions{})
+		c.Assert(err, IsNil)
+
+		opts := SignatureOptions{XMLID: []XMLIDOption{t.xmlid}}
+		signed, err := Sign(testSuite.DSig.Key, doc, opts)
+		c.Assert(err, IsNil)
+		err = Verify(testSuite.DSig.Cert, signed, opts)
+		c.Assert(err, IsNil)
+	}
+
+	// an attribute outside of AttributeNamespace is not an ID
+	doc := []byte(`<Envelope xmlns="urn:envelope"><Body Id="body">Hello, World!</Body></Envelope>`)
+	doc, err = InsertSignatureTemplate(doc, SignatureTemplate{
+		SignatureMethod: "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256",
+		References: []ReferenceTemplate{{
+			URI:          "#body",
+			DigestMethod: "http://www.w3.org/2001/04/xmlenc#sha256",
+		}},
+	}, TemplateLocation{}, SignatureOptions{})
+	c.Assert(err, IsNil)
+	_, err = Sign(testSuite.DSig.Key, doc, SignatureOptions{XMLID: []XMLIDOption{WSSecurityID}})
+	c.Assert(err, NotNil)
+
+	// nor is that of an element outside of ElementNamespace, including one
+	// without a namespace
+	for _, body := range []string{`<Foo ID="x">`, `<Foo xmlns="urn:envelope" ID="x">`} {
+		doc, err := InsertSignatureTemplate([]byte(`<Root>`+body+`Hello, World!</Foo></Root>`), SignatureTemplate{
+			SignatureMethod: "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256",
+			References: []ReferenceTemplate{{
+				URI:          "#x",
+				DigestMethod: "http://www.w3.org/2001/04/xmlenc#sha256",
+			}},
+		}, TemplateLocation{}, SignatureOptions{})
+		c.Assert(err, IsNil)
+		_, err = Sign(testSuite.DSig.Key, doc, SignatureOptions{XMLID: []XMLIDOption{SAML2ProtocolID}})
+		c.Assert(err, NotNil, Commentf("%s", body))
+	}
+}
@@ -0,0 +1,245 @@
+package xmlsec
+
+import (
+	"encoding/base64"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type PKCS12Test struct {
+	// Bundle contains a signing key, its certificate and an intermediate
+	// certificate, protected with the password "hunter2".
+	Bundle []byte
+
+	// RootCert is the root certificate that issued the intermediate
+	// certificate in Bundle.
+	RootCert []byte
+
+	DocStr []byte
+}
+
+var _ = Suite(&PKCS12Test{})
+
+func mustDecodeBase64(s string) []byte {
+	buf, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return buf
+}
+
+func (testSuite *PKCS12Test) SetUpTest(c *C) {
+	testSuite.Bundle = mustDecodeBase64(`
+MIIJDwIBAzCCCMUGCSqGSIb3DQEHAaCCCLYEggiyMIIIrjCCBXIGCSqGSIb3DQEH
+BqCCBWMwggVfAgEAMIIFWAYJKoZIhvcNAQcBMFcGCSqGSIb3DQEFDTBKMCkGCSqG
+SIb3DQEFDDAcBAj8ILGD8L1SNAICCAAwDAYIKoZIhvcNAgkFADAdBglghkgBZQME
+ASoEENUdEn9KqawVnxmaagNyk2+AggTwHukLvo3+SnbyRNuWDiKnlr+nxz/DhLYt
++tjklada8hTL8fYIZ6A+LDFLWjjkXQ2pD0W4u0GRcfKk0forlPOodRSOI/T0Y8ac
+qwBpBzDfasmuTLvQwoPL02eOfTbRB4PNeCaeAxSWiVagny9+V8S0IJZG8JJuTsX1
+Meox4Gqel9Fk1puozAJ1p9Xts+3AVts97Yz1q0xMzFYJMYRCIDa56i41FuXUUN6p
+jo5fdSkqz0qIwOQWRhfCbnUMGnmpM/mCDBiIKrVQbnaPOPx6AYtIuvNGo6UGVzbd
+J9MyfwZdGKiqqpXcTI+kqP6NNzNLP6r0abn9cMQzldMQi5Hq2E66Rfy5TPjF3kT0
+5Jh7Wa0niR/wjnX2GwesjHI4HaliVAu8n0e+3qg5vwWAOGbSfRfFTSHIF1MaxSLY
+nsKuW6I8HoKiMO5sw7JcW/HEx0GMTunvuoqgymPvSk35g5ieeNI3sKticMw0ZiC9
+CEjSCvyTnaKblPXp9uXu4Eun512+KI1XVNWeYIZ5YRsAVBqsJiU8X6CfFc/7hkAk
+ahwV7UA2Lg35+4tP++sW0G0GUIvxzwlNKOziXebWByaNK8RaSHphNLKQ2DkuqRGf
+6AzikWDzzRsQ0Dq4Tc5yWevH+SehaFUiTIsezC8uoEY0HVnL4ME5Udl2JObE0x5Q
+eKOxGDaqqE+AIqOb7+vWn4PjN37/O5i39oGG9Sj3EnQPfibXkAYMM8agSQPX7Q7m
+vmWLCAhVzbb4zximxNFamdAkkKyvLuAM2SUpdyV++1pTdRicpbrkUg+arCBoxPr7
+kW0oPMjzRyx3DfbUJuR6d53XY1RmK3s1lU4stW9p9XXo/I9msuJuKALqJEg9Mimo
+lqy8kxHLS9O0xuhMZpJuW6WJTAsQbInT51MxPEXd5aXyHF8BlZY32296NxzV1l7X
+D4BqzOfdc6m4SV4hvDdxWGRs9gKzsIdme0I1im1EwTUtOCK1vxPcVWj1MI3y3yNl
+7O0+J/O9FGWc+CcdqaYWlPMDW9E8e4R7HxWlRKvSpfJ4i96cKWfT6jowTL9Lea5A
+WD0cy84LjY/OL5Fs8E5w+40DxNRSjappSQep93e3pBI1p0UIGjY8MvACTz6BbF85
+yEtj3kR0Nn96IdFeyLpuvU1WpiJoLPZnm67b4wrqTQYOCKos+Bw0S7P9o3Rf8rAj
+vHVTgcRA6emkg826P6SaYNcfiWkPqZfVxDFQ0itPbOzVzEpyrCwXo9nv+6xAxwjx
+ePeQ1ofgG2NTCeLNgn/P9mU5C7cYyTii+r8puvYtK+R3YhSEP1kJ8Ilulh1SaNwf
+NnGF95apeCz/gxj+i99KIUqFzT4fWld1vZVpaxe9J4vPHLsggz02KiVpIsDLh9PG
+u+A90h+q8001Wpk6jQ36Dsw8c2TeNCxFyF6Kr/mgDwvynajb5k6Pkn4yl/F6qVHi
+EnqYWrCuOSfK+LKPn5EQjNMC5V2KQRJ9mdJ7Kat836nW1dXf73EO0NTZfyYkIlzn
+Nd2WEJU9FYz53EgxzywHiHGjxaJfLyYx8Qa+eZdOBq+7zQDnTNprllcpR/ViJIY1
+jk8K9E7D0QVc5+CkRx5Kww4ekJI+jIYk1HQ4Tot7UHpD3KjSo4QB4/zYX/0SY3V9
+QBZKH0KVr13NYxM/sqJ6YDxFTJ0PUH1JYI6dasWN71c9fX3DgMhjmTCCAzQGCSqG
+SIb3DQEHAaCCAyUEggMhMIIDHTCCAxkGCyqGSIb3DQEMCgECoIIC4TCCAt0wVwYJ
+KoZIhvcNAQUNMEowKQYJKoZIhvcNAQUMMBwECCrxHxJhQ5o+AgIIADAMBggqhkiG
+9w0CCQUAMB0GCWCGSAFlAwQBKgQQMhLRPAPqKwrBYM216AVD5wSCAoBWUDoJVYTZ
+4ykrO7oKAcSNz2sdV9ILSbmhq5R/vTsLo6Ly0TZTndMQdNXobyT1hEdLjOUDFL1Q
+1EB6ZBXBsHSf+JSpUZq0gckZmcJ1FM/DVnXoA2Qn40KLF79o/FiLowpGgdIf8wkF
+Fk2YVH5qRWW48nDrkDKphhm5E7DDJNWYnrIleztk/1kidcnQgR5rebLWSlUv91Oy
+sxdBCz4NQaoG/f+vqJ4cUQIDRV/lXuErdzt5Cjheu91jtNUV9X3doya3G9DfNlxe
+wydK4P5hS4Wdb1nfUgS2M2GVrIOGcp9EOmz8uDRX+yGYoEzSbYIe60pZ3wnd3w6R
+C8QMjaImBbpM4GitNmSc3XX9+jrL6/hhoxMc0UIQtsErnTmtkP93bGXa5+lY8pzL
+OvvdZqVWHhqQRbgE/CgEBWSCQb6rjHUMst2RTPeUsh/uR+wsYzvHreVOf38Pjdk9
+pWor568bLEYpbkLggNJoukfe4s50oXd8w6G1yYT80rEMTcxqYy1eIIW7N2qfMgir
+L3J9t/MaXv4ulUnyFez1zoY6jlNo4R8B8WFUye0f6DsEQObHw277RDKbn2HZT2U1
+8sJyV5MWYpUNGsY3yYQEbQNvZ8NNc9Q66i1DUPB07YMMbgmf/VVowGYIn+6cpDC3
+88Z+ZODAuBUgTc40tPihL+WJlHAmDSNMUoZ54OKUL4tjjaGfhu4fWm3hnwSp5NTE
+ryMDcBwIL3e+JgmuXSAS9AA4mHNjOX2ruR90pS5o8WJ2Q6Xa4yYPkgKYlrNmrQrL
+VwVuut9xBfq5mzwczUD6AKCTfdd4eF8ySIgmlaAAS38MXQ//kOPCgQ8Ckz6q2b7Q
+T+5oygoGk6ZjMSUwIwYJKoZIhvcNAQkVMRYEFCV+2A4iHIe7BTfLzzgD/Jan6bwu
+MEEwMTANBglghkgBZQMEAgEFAAQggLSJDYTA4x6kPWXLx0xVhL3nyAtQNiniIkYJ
+JwrLuusECO5wXUBHuywDAgIIAA==
+`)
+
+	testSuite.RootCert = []byte(`
+-----BEGIN CERTIFICATE-----
+MIICKjCCAZOgAwIBAgIUIPiUWuuNsPELFoUTBKH0+NcuGnQwDQYJKoZIhvcNAQEL
+BQAwHjEcMBoGA1UEAwwTZ28teG1sc2VjIHRlc3Qgcm9vdDAgFw0yNjEwMTcwNjM0
+MjRaGA8yMTI2MDkyMzA2MzQyNFowHjEcMBoGA1UEAwwTZ28teG1sc2VjIHRlc3Qg
+cm9vdDCBnzANBgkqhkiG9w0BAQEFAAOBjQAwgYkCgYEA1Rc5Z36r73JGqxsZecIL
+j5rG5hT5xDxHOuD2FeWqrPTELwRSpM2atjxxZUkMF3RKofuFhdiHN4IXYCxnLCNV
+XhMmSmhxEYssJSoiwDtEpq18ra07kSiQrmMOGCMb1A+jTs24grmup4JfttycDAXo
+9kXppvq6AWNoMVFIQx++8hUCAwEAAaNjMGEwHwYDVR0jBBgwFoAUXwk7rAszWRbe
+m1fphfB1ubkvE2YwDwYDVR0TAQH/BAUwAwEB/zAOBgNVHQ8BAf8EBAMCAQYwHQYD
+VR0OBBYEFF8JO6wLM1kW3ptX6YXwdbm5LxNmMA0GCSqGSIb3DQEBCwUAA4GBAL8/
+8YRDoYiFruWHj+NfdZTyp8u1X5AJyahjeYemJ8ep0SR/71ZhU18kz7m3nLe/5iRY
+fzJ1FXjcSk/10DoR1liIaDjQz0p+glWf+5ku72hqy0o1KwdWwrpD0wkLkHcpg/q1
+/e9/fVPffz26rNhDpLJTaQdTs56TPv9sOzTMYLuw
+-----END CERTIFICATE-----
+`)
+
+	testSuite.DocStr = []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Envelope xmlns="urn:envelope">
+  <Data>Hello, World!</Data>
+  <Signature xmlns="http://www.w3.org/2000/09/xmldsig#">
+    <SignedInfo>
+      <CanonicalizationMethod Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#"/>
+      <SignatureMethod Algorithm="http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"/>
+      <Reference URI="">
+        <Transforms>
+          <Transform Algorithm="http://www.w3.org/2000/09/xmldsig#enveloped-signature"/>
+        </Transforms>
+        <DigestMethod Algorithm="http://www.w3.org/2001/04/xmlenc#sha256"/>
+        <DigestValue></DigestValue>
+      </Reference>
+    </SignedInfo>
+    <SignatureValue></SignatureValue>
+  </Signature>
+</Envelope>
+`)
+}
+
+func (testSuite *PKCS12Test) TestSignPKCS12(c *C) {
+	signedDoc, err := SignPKCS12(testSuite.Bundle, testSuite.DocStr,
+		SignatureOptions{Password: "hunter2"})
+	c.Assert(err, IsNil)
+
+	// both the signing certificate and the intermediate are included
+	c.Assert(strings.Count(string(signedDoc), "<X509Certificate>"), Equals, 2)
+
+	err = VerifyTrusted([][]byte{testSuite.RootCert}, signedDoc, SignatureOptions{})
+	c.Assert(err, IsNil)
+}
+
+func (testSuite *PKCS12Test) TestSignPKCS12IncorrectPassword(c *C) {
+	_, err := SignPKCS12(testSuite.Bundle, testSuite.DocStr, SignatureOptions{})
+	c.Assert(err, Equals, ErrIncorrectPassword)
+
+	_, err = SignPKCS12(testSuite.Bundle, testSuite.DocStr,
+		SignatureOptions{Password: "wrong"})
+	c.Assert(err, Equals, ErrIncorrectPassword)
+
+	_, err = SignPKCS12([]byte("XXX"), testSuite.DocStr,
+		SignatureOptions{Password: "hunter2"})
+	c.Assert(err, Not(Equals), ErrIncorrectPassword)
+}
+
+func (testSuite *PKCS12Test) TestDecryptPKCS12(c *C) {
+	decryptTest := DecryptTest{}
+	decryptTest.SetUpTest(c)
+
+	// contains DecryptTest.Key and the certificate that it corresponds to,
+	// protected with the password "hunter2"
+	bundle := mustDecodeBase64(`
+MIIGbwIBAzCCBiUGCSqGSIb3DQEHAaCCBhYEggYSMIIGDjCCAtIGCSqGSIb3DQEH
+BqCCAsMwggK/AgEAMIICuAYJKoZIhvcNAQcBMFcGCSqGSIb3DQEFDTBKMCkGCSqG
+SIb3DQEFDDAcBAg8CRE//tE06gICCAAwDAYIKoZIhvcNAgkFADAdBglghkgBZQME
+ASoEEAm0NKk/7CvxSdo74rhDgsOAggJQW8n/L7wR3lPBhqAGxkaeATtiGQ7MDUQL
+FRFmbn501FBEaP+/zMu4CJk0WWXXi4yQXL2ZPmIvo3HGDZ+nlxXoh9p7xWCEgl9Z
+VI5YUSqL2tGZvZLIYLCdzEcZRyVL+Qi4nC2rNVbhc+3WDsLoGBE6ULA4f+IFMA8U
+N+FjiXPtesAJKvmrkvVZH+Cu4isntQMgjvWWaXWobRAfgX77mo887ZQiEmBvRpaI
+ChHBD2BChZjtC1Rfe8WNJCAf4XAYtV2+JGDh8EOgsh2bjxGhMknrr9OO+i+H0hjl
+XXfhkrdO83WOlHhbMWBet7F5FW7B9tP2joRsCjylA8i0q7uualLtYpNhCO+B9nFQ
+FNvzFZB09q2abg6nAorXUsnRVfM0lMT6ila6pkHJt3t2XUzZSMynRP2YZQf2SBip
+cJ3nNhRck2QHznd+RUItOV8N9JiG3jnMobsX88xBkYSPD/IsISvmaQ/BhttRMr7l
+msEj8IKImUMCUWj8rE5Ko/jpGYiUmMavbXxStGhZBlwkTxeM2CFzTBcEI5hYfdmz
+t8yZg2SW+nk0vAUjgt75REmXUEq5j/xDNyYhTkoaznjXqox0vLX4fm9SbY7wivVu
+AZuA9kn3l/lxeywBDrtPmDWEVE3rRFB7JA2gdQ92sSXov0PbeTu4zJC8S2Y/55sB
+rYJivyF63HeOEW3JCjdEOzR5B5gql9UvrLjpjIaOh5Z6A8oQTf/iNtcyvd6jhZqY
+T41Q7A8lybMifM2dtOANMN6WqKi82/ippVO0C7JnbHsXx37mNrkkSzCCAzQGCSqG
+SIb3DQEHAaCCAyUEggMhMIIDHTCCAxkGCyqGSIb3DQEMCgECoIIC4TCCAt0wVwYJ
+KoZIhvcNAQUNMEowKQYJKoZIhvcNAQUMMBwECPPZVjdoCdX0AgIIADAMBggqhkiG
+9w0CCQUAMB0GCWCGSAFlAwQBKgQQouSgS2kUfWWagOxZFFUyBASCAoByvVyQ1DC4
+lkvfVdGFZhNYh4YlDZDbYpVsjrRImWhINXzNihxod8Q5ZtRhyiVAnW+1iAWTup5v
+lnpjZpntc3YdQ6fQXGtlLZ42JwE63pFPGKtoKn7fm2wy6cuVfRzh/qOnKoce22k9
+U2fJDLD7GwLYCYlO1yX81EiMP5QvBZL0Ob2mg0/skkd/Fr2CAIa4bb0bbd58OmTG
+LhxYTZK4YFUHv3s+l8E8vVDFfM5u++614Y2vnv9G3jkyV7wZz/Xm02Sv8Ah6g/Dh
+o1vVNKVG0G6fOc7OiL6Tk+EhP8Pd8LMkacQSI9kwvmi4qEk2czRbImH/HO5ufBTb
+RoeK6Vu00woY/9fk+UEq8ZZ5HOwY56ukCxEvGb0iy4SI1jC8skm7n4wBHU13igcl
+psTO4Hu6jyq/2GCMAJZWGEBaNumx464ETZ8ZjXC6JToc1m0UBMmVdk6KdOB9X9rp
+FkH9vf3HUDbYRG9YyZDsUKy8Yr1LCVLsgHzoNZqs9YwpyAeVd2q1V+XhM4dWHiOJ
+xY0VZ3yy2VMilDWqwihlqxZpcX3mTOLY3HzOT45sj0vkvS8zjuznaazEI2HuG8i6
+tKYtLaiQUwUYnFbEuh8sCwnUG+HXdzJWzrblJKgPWyGQ3HDQTImofG2OOJDYwH5t
+lPqrlBAd48GjRRd7CWw/qj0AKweWppVVheWQXca4R7KEGD06zRtBeJoTiQLgJW/B
+CReZXyjw+JU979hzh4atdlAgFDJGQD++cc9MwoaeYDGN+0nXVqYH1P+APsLhtTZQ
+kDK/donm7yEU5GD8bWbw0WXzFXWLabMP/0zo+rjduyeGeZqY7S9OcIf1VG/sYfzU
+eKWn+Jq+gtTfMSUwIwYJKoZIhvcNAQkVMRYEFGD2MSF2a9iKJL4gNtwy6JcMG6/2
+MEEwMTANBglghkgBZQMEAgEFAAQg0VQpJbz2NZj/vE3hnLyzn1Sjnxo6/+u27eAG
++5FP9N0ECMfz8UcghCqnAgIIAA==
+`)
+
+	actualPlaintext, err := DecryptPKCS12(bundle, decryptTest.DocStr,
+		DecryptOptions{Password: "hunter2"})
+	c.Assert(err, IsNil)
+	c.Assert(string(actualPlaintext), Equals, string(decryptTest.ExpectedPlaintext))
+
+	_, err = DecryptPKCS12(bundle, decryptTest.DocStr, DecryptOptions{Password: "wrong"})
+	c.Assert(err, Equals, ErrIncorrectPassword)
+
+	keyStore, err := NewKeyStore()
+	c.Assert(err, IsNil)
+	defer keyStore.Close()
+	_, err = DecryptPKCS12(bundle, decryptTest.DocStr, DecryptOptions{Password: "hunter2", KeyStore: keyStore})
+	c.Assert(err, ErrorMatches, "cannot use both a key and a KeyStore")
+	_, err = DecryptPKCS12(bundle, decryptTest.DocStr, DecryptOptions{
+		Password:    "hunter2",
+		KeyResolver: func(keyInfo *KeyInfo) ([]byte, error) { return nil, nil },
+	})
+	c.Assert(err, ErrorMatches, "cannot use both a key and a KeyResolver")
+}
+
+func (testSuite *PKCS12Test) TestLegacyPKCS12(c *C) {
+	// an EC key and certificate protected with pbeWithSHA1And40BitRC2-CBC
+	// and pbeWithSHA1And3-KeyTripleDES-CBC, which OpenSSL 3 only supports
+	// with its legacy provider, and the password "hunter2"
+	bundle := mustDecodeBase64(`
+MIIDkgIBAzCCA1gGCSqGSIb3DQEHAaCCA0kEggNFMIIDQTCCAjcGCSqGSIb3DQEH
+BqCCAigwggIkAgEAMIICHQYJKoZIhvcNAQcBMBwGCiqGSIb3DQEMAQYwDgQIdczF
+FANYfpgCAggAgIIB8PsWwbRKdYHqZ71StMVikcY41TCwqTdurCK4rK5xJD3tiEYA
+zV8a9t6MnQq3KxLUWj998cIgGKq4oJ1A3JPVTfXj2bXFPIR1XnRkv8i2DOPe/kx0
+7LKWl+AI7lUDwJ/wBkQSUQg2iUHMAp2qfXDXQaeiT0z/XMtkeu9Nmg1gCaDYQeQA
+UAWILSZKioHlbg/Va6CDNgFzegiulfPBuWc3P12eJ61+j2cFb7K9kS2mRvTbCcC/
+hrJAPDB/xqO62AGYXYguAmOvTs/n7wden38QQj+FXdL0yxPqIFJ9c4Kybw+KNCAM
+vfLooYxmZ3Fg0GUgfYB8rWGGfRnu4mutS5hrcbu+I6LtoClBAjJuGLqiYwcdiaFd
+UhVC1FRn5vr4w8WbXV5sHI10rW+RbAy0Mt6ewwwB5t5upqD9x0SXLPPwRxqF2iNE
++SXQV7Ia2ceR8VunODXFuWWu31F3gAdnfdwGTkh0RBSto4rLL8aBREX62sRnb4E0
+ZiamMbhboTrHS5/4cuV/IJlQkXcpub5hZCEGy7KOsMRQLAJvgZ7nDz5c1w8tPYmE
+8fjiLAZjF6TNYDuJeP3gPmgNgziRvMKdJgyIJx0i1MP1iG2IF1hB43Hg5amIDgSB
+XjGC/c2KvzlMaF6nU40aihtPEjWy1PKMTrNCbz0wggECBgkqhkiG9w0BBwGggfQE
+gfEwge4wgesGCyqGSIb3DQEMCgECoIG0MIGxMBwGCiqGSIb3DQEMAQMwDgQIh2t3
+Ds3jzdACAggABIGQG7517hJUpvWqiXfbXoROI6UwCBbVVRcr56svvRB1UeT5dHRD
+IzHPq0SrHYjeiZ7jeUuURXDsjti8YCe9uCyPDzghJiPHghwg/jFJXv5BqwJUbuf7
+ZFklXVSVE0t02jzhLgLmFyD+qe2zyjldaCvyCzflewn7KiPky/u3YLnfq3WSYmcw
+uh8o5vNmQqJ20SrwMSUwIwYJKoZIhvcNAQkVMRYEFO0Xp1z5K/m7RsLGKACBhyQ4
+Mo2eMDEwITAJBgUrDgMCGgUABBRDBoSI3KGuWwbm+NBz8AghxYnmNgQIQNARju9R
+zewCAggA
+`)
+
+	// whether or not the legacy provider is loaded, the password is right
+	_, err := SignPKCS12(bundle, testSuite.DocStr, SignatureOptions{Password: "hunter2"})
+	c.Assert(err, Not(Equals), ErrIncorrectPassword)
+
+	_, err = SignPKCS12(bundle, testSuite.DocStr, SignatureOptions{Password: "wrong"})
+	c.Assert(err, Equals, ErrIncorrectPassword)
+}
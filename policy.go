@@ -0,0 +1,257 @@
+package xmlsec
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// #include <xmlsec/xmlsec.h>
+// #include <xmlsec/xmltree.h>
+// #include <xmlsec/xmldsig.h>
+// #include <xmlsec/list.h>
+// #include <xmlsec/transforms.h>
+import "C"
+
+// AlgorithmPolicy restricts the algorithms that a signature may use in order
+// to be accepted by Verify and the related functions. Algorithms are
+// identified by their URIs. A nil or empty list does not restrict that kind
+// of algorithm.
+type AlgorithmPolicy struct {
+	// SignatureMethods lists the allowed SignatureMethod algorithms.
+	SignatureMethods []string
+
+	// DigestMethods lists the allowed DigestMethod algorithms.
+	DigestMethods []string
+
+	// CanonicalizationMethods lists the allowed algorithms for the
+	// CanonicalizationMethod of SignedInfo.
+	CanonicalizationMethods []string
+
+	// Transforms lists the allowed Transform algorithms of References.
+	Transforms []string
+}
+
+// canonicalizationMethods are the canonicalization algorithms allowed by
+// SecureAlgorithmPolicy.
+var canonicalizationMethods = []string{
+	"http://www.w3.org/TR/2001/REC-xml-c14n-20010315",
+	"http://www.w3.org/TR/2001/REC-xml-c14n-20010315#WithComments",
+	"http://www.w3.org/2006/12/xml-c14n11",
+	"http://www.w3.org/2006/12/xml-c14n11#WithComments",
+	"http://www.w3.org/2001/10/xml-exc-c14n#",
+	"http://www.w3.org/2001/10/xml-exc-c14n#WithComments",
+}
+
+// SecureAlgorithmPolicy returns a policy that only allows algorithms without
+// known weaknesses. In particular it rejects SHA-1 signatures and digests,
+// and transforms other than canonicalization and enveloped-signature, such as
+// XPath and XSLT, which have been used to attack verifiers.
+//
+// It allows no HMAC signature methods, since those are checked with a shared
+// secret rather than a public key. VerifyHMAC, which only accepts HMACs,
+// allows those whose hash is one of the allowed digest methods.
+func SecureAlgorithmPolicy() *AlgorithmPolicy {
+	return &AlgorithmPolicy{
+		SignatureMethods: []string{
+			"http://www.w3.org/2001/04/xmldsig-more#rsa-sha256",
+			"http://www.w3.org/2001/04/xmldsig-more#rsa-sha384",
+			"http://www.w3.org/2001/04/xmldsig-more#rsa-sha512",
+			"http://www.w3.org/2007/05/xmldsig-more#rsa-pss",
+			"http://www.w3.org/2007/05/xmldsig-more#sha256-rsa-MGF1",
+			"http://www.w3.org/2007/05/xmldsig-more#sha384-rsa-MGF1",
+			"http://www.w3.org/2007/05/xmldsig-more#sha512-rsa-MGF1",
+			"http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256",
+			"http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha384",
+			"http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha512",
+			"http://www.w3.org/2021/04/xmldsig-more#eddsa-ed25519",
+			"http://www.w3.org/2021/04/xmldsig-more#eddsa-ed448",
+		},
+		DigestMethods: []string{
+			"http://www.w3.org/2001/04/xmlenc#sha256",
+			"http://www.w3.org/2001/04/xmldsig-more#sha384",
+			"http://www.w3.org/2001/04/xmlenc#sha512",
+		},
+		CanonicalizationMethods: append([]string{}, canonicalizationMethods...),
+		Transforms: append([]string{
+			"http://www.w3.org/2000/09/xmldsig#enveloped-signature",
+		}, canonicalizationMethods...),
+	}
+}
+
+// AlgorithmNotAllowedError is returned from Verify when the signature uses
+// an algorithm that is not allowed by the AlgorithmPolicy in SignatureOptions.
+type AlgorithmNotAllowedError struct {
+	// Kind describes how the algorithm is used, for example "signature
+	// method" or "transform".
+	Kind string
+
+	// Algorithm is the URI of the rejected algorithm.
+	Algorithm string
+}
+
+func (e AlgorithmNotAllowedError) Error() string {
+	return fmt.Sprintf("%s %q is not allowed", e.Kind, e.Algorithm)
+}
+
+// ReferenceNotAllowedError is returned from Verify when a Reference of the
+// signature, or a RetrievalMethod of its KeyInfo, has a URI that refers to
+// something other than the document itself, such as a file or an http URL,
+// and SignatureOptions.AllowExternalReferences is not set.
+type ReferenceNotAllowedError struct {
+	// URI is the URI of the rejected reference.
+	URI string
+}
+
+func (e ReferenceNotAllowedError) Error() string {
+	return fmt.Sprintf("reference to %q is not allowed: only references within the document are", e.URI)
+}
+
+// sameDocumentURIs are the URI types of references within the document:
+// empty URIs and fragments such as #id.
+const sameDocumentURIs = C.xmlSecTransformUriTypeEmpty | C.xmlSecTransformUriTypeSameDocument
+
+// checkSameDocumentReferences returns a ReferenceNotAllowedError if a
+// Reference or RetrievalMethod element in the signature in signatureNode,
+// including those of Manifests, has a URI outside of the document.
+func checkSameDocumentReferences(signatureNode *C.xmlNode) error {
+	for node := C.xmlSecGetNextElementNode(signatureNode.children); node != nil; node = C.xmlSecGetNextElementNode(node.next) {
+		isNamed := func(name *C.xmlChar) bool {
+			return C.xmlSecCheckNodeName(node, name, (*C.xmlChar)(unsafe.Pointer(&C.xmlSecDSigNs))) == 1
+		}
+		if isNamed((*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeReference))) ||
+			isNamed((*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeRetrievalMethod))) {
+			if uri := getAttr(node, "URI"); uri != "" && !strings.HasPrefix(uri, "#") {
+				return ReferenceNotAllowedError{URI: uri}
+			}
+		}
+		if err := checkSameDocumentReferences(node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restrictToSameDocument makes xmlsec refuse to follow references outside
+// of the document in dsigCtx, as a second line of defense after
+// checkSameDocumentReferences.
+func restrictToSameDocument(dsigCtx *C.xmlSecDSigCtx) {
+	dsigCtx.enabledReferenceUris = sameDocumentURIs
+	dsigCtx.keyInfoReadCtx.retrievalMethodCtx.enabledUris = sameDocumentURIs
+}
+
+// check returns an AlgorithmNotAllowedError if the SignedInfo of the
+// signature in signatureNode uses algorithms that the policy does not allow.
+func (p *AlgorithmPolicy) check(signatureNode *C.xmlNode) error {
+	signedInfoNode := C.xmlSecFindChild(signatureNode,
+		(*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeSignedInfo)),
+		(*C.xmlChar)(unsafe.Pointer(&C.xmlSecDSigNs)))
+	if signedInfoNode == nil {
+		return nil // xmlsec rejects the signature
+	}
+
+	checkAlgorithm := func(node *C.xmlNode, allowed []string, kind string) error {
+		if node == nil || len(allowed) == 0 {
+			return nil
+		}
+		algorithm := getAttr(node, "Algorithm")
+		for _, a := range allowed {
+			if a == algorithm {
+				return nil
+			}
+		}
+		return AlgorithmNotAllowedError{Kind: kind, Algorithm: algorithm}
+	}
+
+	if err := checkAlgorithm(firstDSigChild(signedInfoNode,
+		(*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeCanonicalizationMethod))),
+		p.CanonicalizationMethods, "canonicalization method"); err != nil {
+		return err
+	}
+	signatureMethodNode := firstDSigChild(signedInfoNode,
+		(*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeSignatureMethod)))
+	if err := checkAlgorithm(signatureMethodNode, p.SignatureMethods, "signature method"); err != nil {
+		return err
+	}
+
+	// the digests used by RSASSA-PSS are parameters of the signature method
+	digestMethodName := (*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeDigestMethod))
+	if signatureMethodNode != nil {
+		if paramsNode := C.xmlSecFindChild(signatureMethodNode, constRSAPSSParams, constPSSNamespace); paramsNode != nil {
+			if err := checkAlgorithm(firstDSigChild(paramsNode, digestMethodName),
+				p.DigestMethods, "digest method"); err != nil {
+				return err
+			}
+			if mgfNode := C.xmlSecFindChild(paramsNode, constMaskGenerationFunction, constPSSNamespace); mgfNode != nil {
+				if err := checkAlgorithm(firstDSigChild(mgfNode, digestMethodName),
+					p.DigestMethods, "digest method"); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	referenceName := (*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeReference))
+	transformName := (*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeTransform))
+	for referenceNode := firstDSigChild(signedInfoNode, referenceName); referenceNode != nil; referenceNode = nextDSigElement(referenceNode.next, referenceName) {
+		transformsNode := firstDSigChild(referenceNode, (*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeTransforms)))
+		for transformNode := firstDSigChild(transformsNode, transformName); transformNode != nil; transformNode = nextDSigElement(transformNode.next, transformName) {
+			if err := checkAlgorithm(transformNode, p.Transforms, "transform"); err != nil {
+				return err
+			}
+		}
+
+		if err := checkAlgorithm(firstDSigChild(referenceNode, digestMethodName),
+			p.DigestMethods, "digest method"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply restricts the transforms that xmlsec will use in dsigCtx to those
+// allowed by the policy. This also covers the References of any Manifests.
+func (p *AlgorithmPolicy) apply(dsigCtx *C.xmlSecDSigCtx) error {
+	signatureTransforms := append(
+		enabledTransformIDs(p.CanonicalizationMethods, C.xmlSecTransformUsageC14NMethod),
+		enabledTransformIDs(p.SignatureMethods, C.xmlSecTransformUsageSignatureMethod)...)
+	for _, id := range signatureTransforms {
+		if rv := C.xmlSecDSigCtxEnableSignatureTransform(dsigCtx, id); rv < 0 {
+			return mustPopError()
+		}
+	}
+
+	referenceTransforms := append(
+		enabledTransformIDs(p.Transforms, C.xmlSecTransformUsageDSigTransform),
+		enabledTransformIDs(p.DigestMethods, C.xmlSecTransformUsageDigestMethod)...)
+	for _, id := range referenceTransforms {
+		if rv := C.xmlSecDSigCtxEnableReferenceTransform(dsigCtx, id); rv < 0 {
+			return mustPopError()
+		}
+	}
+	return nil
+}
+
+// enabledTransformIDs returns the xmlsec transforms for the algorithms in
+// hrefs, or all the registered transforms for usage if hrefs is empty.
+// Algorithms that xmlsec does not implement are ignored.
+func enabledTransformIDs(hrefs []string, usage C.xmlSecTransformUsage) []C.xmlSecTransformId {
+	ids := []C.xmlSecTransformId{}
+	if len(hrefs) == 0 {
+		allIDs := C.xmlSecTransformIdsGet()
+		for i := C.xmlSecSize(0); i < C.xmlSecPtrListGetSize(allIDs); i++ {
+			id := C.xmlSecTransformId(C.xmlSecPtrListGetItem(allIDs, i))
+			if id != nil && id.usage&usage != 0 {
+				ids = append(ids, id)
+			}
+		}
+		return ids
+	}
+
+	for _, href := range hrefs {
+		if id := findTransformID(href, usage); id != nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,169 @@
+package xmlsec
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type PolicyTest struct {
+	DSig XMLDSigTest
+}
+
+var _ = Suite(&PolicyTest{})
+
+func (testSuite *PolicyTest) SetUpTest(c *C) {
+	testSuite.DSig.SetUpTest(c)
+}
+
+// signSAMLAssertion returns a signed SAML assertion using the given
+// algorithms and exclusive canonicalization.
+func (testSuite *PolicyTest) signSAMLAssertion(c *C, signatureMethod, digestMethod string) []byte {
+	doc := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="assertion-id"><saml:Issuer>https://idp.example.com/</saml:Issuer><saml:Subject><saml:NameID>alice</saml:NameID></saml:Subject></saml:Assertion>
+`)
+	opts := SignatureOptions{XMLID: []XMLIDOption{{
+		ElementName:      "Assertion",
+		ElementNamespace: "urn:oasis:names:tc:SAML:2.0:assertion",
+		AttributeName:    "ID",
+	}}}
+	doc, err := InsertSignatureTemplate(doc, SignatureTemplate{
+		Prefix:          "ds",
+		SignatureMethod: signatureMethod,
+		References: []ReferenceTemplate{{
+			URI:          "#assertion-id",
+			DigestMethod: digestMethod,
+			Transforms: []TransformTemplate{
+				{Algorithm: "http://www.w3.org/2000/09/xmldsig#enveloped-signature"},
+				{Algorithm: "http://www.w3.org/2001/10/xml-exc-c14n#"},
+			},
+		}},
+	}, TemplateLocation{After: "Issuer"}, opts)
+	c.Assert(err, IsNil)
+
+	signed, err := Sign(testSuite.DSig.Key, doc, opts)
+	c.Assert(err, IsNil)
+	return signed
+}
+
+func (testSuite *PolicyTest) verifyOptions(policy *AlgorithmPolicy) SignatureOptions {
+	return SignatureOptions{
+		XMLID: []XMLIDOption{{
+			ElementName:      "Assertion",
+			ElementNamespace: "urn:oasis:names:tc:SAML:2.0:assertion",
+			AttributeName:    "ID",
+		}},
+		AlgorithmPolicy: policy,
+	}
+}
+
+func (testSuite *PolicyTest) TestSecurePolicyAllowsSHA256(c *C) {
+	signed := testSuite.signSAMLAssertion(c,
+		"http://www.w3.org/2001/04/xmldsig-more#rsa-sha256",
+		"http://www.w3.org/2001/04/xmlenc#sha256")
+
+	err := Verify(testSuite.DSig.Cert, signed, testSuite.verifyOptions(SecureAlgorithmPolicy()))
+	c.Assert(err, IsNil)
+}
+
+func (testSuite *PolicyTest) TestSecurePolicyRejectsHMAC(c *C) {
+	_, hmacKeyValue := forgedSignatures(c)
+	err := Verify(testSuite.DSig.Cert, hmacKeyValue, SignatureOptions{AlgorithmPolicy: SecureAlgorithmPolicy()})
+	c.Assert(err, Equals, AlgorithmNotAllowedError{
+		Kind:      "signature method",
+		Algorithm: "http://www.w3.org/2001/04/xmldsig-more#hmac-sha256",
+	})
+
+	// VerifyHMAC allows the HMACs whose hash the policy allows
+	policy := SecureAlgorithmPolicy().hmacOnly()
+	c.Assert(policy.SignatureMethods, DeepEquals, []string{
+		"http://www.w3.org/2001/04/xmldsig-more#hmac-sha256",
+		"http://www.w3.org/2001/04/xmldsig-more#hmac-sha384",
+		"http://www.w3.org/2001/04/xmldsig-more#hmac-sha512",
+	})
+}
+
+func (testSuite *PolicyTest) TestSecurePolicyRejectsSHA1(c *C) {
+	signed, err := Sign(testSuite.DSig.Key, testSuite.DSig.DocStr, SignatureOptions{})
+	c.Assert(err, IsNil)
+
+	// without a policy any algorithm is accepted
+	err = Verify(testSuite.DSig.Cert, signed, SignatureOptions{})
+	c.Assert(err, IsNil)
+
+	err = Verify(testSuite.DSig.Cert, signed, SignatureOptions{AlgorithmPolicy: SecureAlgorithmPolicy()})
+	c.Assert(err, Equals, AlgorithmNotAllowedError{
+		Kind:      "signature method",
+		Algorithm: "http://www.w3.org/2000/09/xmldsig#rsa-sha1",
+	})
+	c.Assert(err, ErrorMatches, `signature method "http://www.w3.org/2000/09/xmldsig#rsa-sha1" is not allowed`)
+
+	signed = testSuite.signSAMLAssertion(c,
+		"http://www.w3.org/2001/04/xmldsig-more#rsa-sha256",
+		"http://www.w3.org/2000/09/xmldsig#sha1")
+	err = Verify(testSuite.DSig.Cert, signed, testSuite.verifyOptions(SecureAlgorithmPolicy()))
+	c.Assert(err, Equals, AlgorithmNotAllowedError{
+		Kind:      "digest method",
+		Algorithm: "http://www.w3.org/2000/09/xmldsig#sha1",
+	})
+}
+
+func (testSuite *PolicyTest) TestRestrictTransforms(c *C) {
+	signed := testSuite.signSAMLAssertion(c,
+		"http://www.w3.org/2001/04/xmldsig-more#rsa-sha256",
+		"http://www.w3.org/2001/04/xmlenc#sha256")
+
+	policy := &AlgorithmPolicy{
+		Transforms: []string{"http://www.w3.org/2000/09/xmldsig#enveloped-signature"},
+	}
+	err := Verify(testSuite.DSig.Cert, signed, testSuite.verifyOptions(policy))
+	c.Assert(err, Equals, AlgorithmNotAllowedError{
+		Kind:      "transform",
+		Algorithm: "http://www.w3.org/2001/10/xml-exc-c14n#",
+	})
+
+	// restricting only the signature methods leaves the other kinds of
+	// algorithm unrestricted
+	policy = &AlgorithmPolicy{
+		SignatureMethods: []string{"http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"},
+	}
+	err = Verify(testSuite.DSig.Cert, signed, testSuite.verifyOptions(policy))
+	c.Assert(err, IsNil)
+}
+
+func (testSuite *PolicyTest) TestExternalReferences(c *C) {
+	path := filepath.Join(c.MkDir(), "data.txt")
+	c.Assert(os.WriteFile(path, []byte("Hello, World!"), 0600), IsNil)
+	uri := "file://" + path
+
+	doc, err := InsertSignatureTemplate([]byte(`<Envelope xmlns="urn:envelope"></Envelope>`), SignatureTemplate{
+		SignatureMethod: "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256",
+		References: []ReferenceTemplate{{
+			URI:          uri,
+			DigestMethod: "http://www.w3.org/2001/04/xmlenc#sha256",
+		}},
+	}, TemplateLocation{}, SignatureOptions{})
+	c.Assert(err, IsNil)
+	signed, err := Sign(testSuite.DSig.Key, doc, SignatureOptions{})
+	c.Assert(err, IsNil)
+
+	err = Verify(testSuite.DSig.Cert, signed, SignatureOptions{})
+	c.Assert(err, ErrorMatches, `reference to ".*data.txt" is not allowed: only references within the document are`)
+	c.Assert(err, DeepEquals, ReferenceNotAllowedError{URI: uri})
+
+	err = Verify(testSuite.DSig.Cert, signed, SignatureOptions{AllowExternalReferences: true})
+	c.Assert(err, IsNil)
+
+	// the References of Manifests and the RetrievalMethods of KeyInfo are
+	// restricted too
+	for _, element := range []string{
+		`<Object><Manifest><Reference URI="http://example.com/data"><DigestMethod Algorithm="http://www.w3.org/2001/04/xmlenc#sha256"/><DigestValue/></Reference></Manifest></Object>`,
+		`<KeyInfo><RetrievalMethod URI="http://example.com/key" Type="http://www.w3.org/2000/09/xmldsig#RSAKeyValue"/></KeyInfo>`,
+	} {
+		doc := strings.Replace(string(testSuite.DSig.DocStr), "</Signature>", element+"</Signature>", 1)
+		err = Verify(testSuite.DSig.Cert, []byte(doc), SignatureOptions{})
+		c.Assert(err, FitsTypeOf, ReferenceNotAllowedError{})
+	}
+}
@@ -0,0 +1,497 @@
+package xmlsec
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"strconv"
+	"unsafe"
+)
+
+// xmlsec does not implement the RSASSA-PSS signature methods of RFC 9231, so
+// we provide them as additional transforms backed by OpenSSL. Once they are
+// registered, they are used by Sign, Verify and the other functions just
+// like the built in signature methods.
+
+// #include <stdlib.h>
+// #include <string.h>
+// #include <xmlsec/xmlsec.h>
+// #include <xmlsec/xmltree.h>
+// #include <xmlsec/keys.h>
+// #include <xmlsec/transforms.h>
+// #include <xmlsec/errors.h>
+// #include <xmlsec/openssl/crypto.h>
+// #include <xmlsec/openssl/evp.h>
+// #include <openssl/err.h>
+// #include <openssl/evp.h>
+// #include <openssl/rsa.h>
+//
+// #define MY_PSS_NS "http://www.w3.org/2007/05/xmldsig-more#"
+//
+// // MY_pssMethod describes an RSASSA-PSS signature method and the digest it
+// // uses unless RSAPSSParams specifies another one.
+// typedef struct {
+//   const char *name;
+//   const char *href;
+//   const char *digest;
+// } MY_pssMethod;
+//
+// static const MY_pssMethod MY_pssMethods[] = {
+//   { "rsa-pss", MY_PSS_NS "rsa-pss", "http://www.w3.org/2001/04/xmlenc#sha256" },
+//   { "sha224-rsa-MGF1", MY_PSS_NS "sha224-rsa-MGF1", "http://www.w3.org/2001/04/xmldsig-more#sha224" },
+//   { "sha256-rsa-MGF1", MY_PSS_NS "sha256-rsa-MGF1", "http://www.w3.org/2001/04/xmlenc#sha256" },
+//   { "sha384-rsa-MGF1", MY_PSS_NS "sha384-rsa-MGF1", "http://www.w3.org/2001/04/xmldsig-more#sha384" },
+//   { "sha512-rsa-MGF1", MY_PSS_NS "sha512-rsa-MGF1", "http://www.w3.org/2001/04/xmlenc#sha512" },
+// };
+//
+// #define MY_PSS_METHODS_COUNT (sizeof(MY_pssMethods) / sizeof(MY_pssMethods[0]))
+//
+// // MY_pssDigest returns the OpenSSL digest identified by the DigestMethod
+// // algorithm href, or NULL if it is not supported.
+// static const EVP_MD* MY_pssDigest(const xmlChar *href) {
+//   if (href == NULL) {
+//     return NULL;
+//   } else if (xmlStrEqual(href, BAD_CAST "http://www.w3.org/2000/09/xmldsig#sha1")) {
+//     return EVP_sha1();
+//   } else if (xmlStrEqual(href, BAD_CAST "http://www.w3.org/2001/04/xmldsig-more#sha224")) {
+//     return EVP_sha224();
+//   } else if (xmlStrEqual(href, BAD_CAST "http://www.w3.org/2001/04/xmlenc#sha256")) {
+//     return EVP_sha256();
+//   } else if (xmlStrEqual(href, BAD_CAST "http://www.w3.org/2001/04/xmldsig-more#sha384")) {
+//     return EVP_sha384();
+//   } else if (xmlStrEqual(href, BAD_CAST "http://www.w3.org/2001/04/xmlenc#sha512")) {
+//     return EVP_sha512();
+//   }
+//   return NULL;
+// }
+//
+// // MY_pssCtx is the state of an RSASSA-PSS transform, which xmlsec
+// // allocates right after the xmlSecTransform.
+// typedef struct {
+//   const EVP_MD *md;
+//   const EVP_MD *mgfMd; // NULL means the same as md
+//   int saltLen;
+//   EVP_PKEY *pKey;
+//   EVP_MD_CTX *mdCtx;
+// } MY_pssCtx;
+//
+// #define MY_pssGetCtx(transform) \
+//   ((MY_pssCtx*)(((xmlSecByte*)(transform)) + sizeof(xmlSecTransform)))
+//
+// static struct _xmlSecTransformKlass MY_pssKlasses[MY_PSS_METHODS_COUNT];
+//
+// static int MY_pssIsTransform(xmlSecTransformPtr transform) {
+//   return transform != NULL && transform->id >= &MY_pssKlasses[0] &&
+//     transform->id < &MY_pssKlasses[MY_PSS_METHODS_COUNT];
+// }
+//
+// static int MY_pssError(xmlSecTransformPtr transform, const char *subject, int reason, const char *msg) {
+//   xmlSecError(XMLSEC_ERRORS_HERE, (const char*)xmlSecTransformGetName(transform),
+//     subject, reason, "%s", msg);
+//   return -1;
+// }
+//
+// static int MY_pssInitialize(xmlSecTransformPtr transform) {
+//   MY_pssCtx *ctx = MY_pssGetCtx(transform);
+//   memset(ctx, 0, sizeof(MY_pssCtx));
+//   ctx->md = MY_pssDigest(BAD_CAST MY_pssMethods[transform->id - MY_pssKlasses].digest);
+//   ctx->saltLen = RSA_PSS_SALTLEN_DIGEST;
+//   return 0;
+// }
+//
+// static void MY_pssFinalize(xmlSecTransformPtr transform) {
+//   MY_pssCtx *ctx = MY_pssGetCtx(transform);
+//   if (ctx->mdCtx != NULL) {
+//     EVP_MD_CTX_free(ctx->mdCtx);
+//   }
+//   if (ctx->pKey != NULL) {
+//     EVP_PKEY_free(ctx->pKey);
+//   }
+//   memset(ctx, 0, sizeof(MY_pssCtx));
+// }
+//
+// static const EVP_MD* MY_pssNodeDigest(xmlNodePtr node) {
+//   xmlChar *href = xmlGetProp(node, xmlSecAttrAlgorithm);
+//   const EVP_MD *md = MY_pssDigest(href);
+//   xmlFree(href);
+//   return md;
+// }
+//
+// static int MY_pssNodeInt(xmlNodePtr node, int *value) {
+//   xmlChar *content = xmlNodeGetContent(node);
+//   char *end = NULL;
+//   long v;
+//   if (content == NULL) {
+//     return -1;
+//   }
+//   v = strtol((const char*)content, &end, 10);
+//   while (end != NULL && (*end == ' ' || *end == '\t' || *end == '\r' || *end == '\n')) {
+//     end++;
+//   }
+//   if (end == (char*)content || end == NULL || *end != '\0' || v < 0 || v > 0xffff) {
+//     xmlFree(content);
+//     return -1;
+//   }
+//   xmlFree(content);
+//   *value = (int)v;
+//   return 0;
+// }
+//
+// // MY_pssNodeRead reads the optional RSAPSSParams child of the
+// // SignatureMethod element node (RFC 9231, section 2.3.10).
+// static int MY_pssNodeRead(xmlSecTransformPtr transform, xmlNodePtr node, xmlSecTransformCtxPtr transformCtx) {
+//   MY_pssCtx *ctx = MY_pssGetCtx(transform);
+//   xmlNodePtr cur = xmlSecGetNextElementNode(node->children);
+//   if (cur == NULL) {
+//     return 0;
+//   }
+//   if (!xmlSecCheckNodeName(cur, BAD_CAST "RSAPSSParams", BAD_CAST MY_PSS_NS) ||
+//       xmlSecGetNextElementNode(cur->next) != NULL) {
+//     return MY_pssError(transform, (const char*)cur->name, XMLSEC_ERRORS_R_UNEXPECTED_NODE, "expected RSAPSSParams");
+//   }
+//
+//   cur = xmlSecGetNextElementNode(cur->children);
+//   if (cur != NULL && xmlSecCheckNodeName(cur, xmlSecNodeDigestMethod, xmlSecDSigNs)) {
+//     if ((ctx->md = MY_pssNodeDigest(cur)) == NULL) {
+//       return MY_pssError(transform, "DigestMethod", XMLSEC_ERRORS_R_INVALID_NODE, "unsupported digest method");
+//     }
+//     cur = xmlSecGetNextElementNode(cur->next);
+//   }
+//   if (cur != NULL && xmlSecCheckNodeName(cur, BAD_CAST "MaskGenerationFunction", BAD_CAST MY_PSS_NS)) {
+//     xmlChar *href = xmlGetProp(cur, xmlSecAttrAlgorithm);
+//     int isMGF1 = href == NULL || xmlStrEqual(href, BAD_CAST MY_PSS_NS "MGF1");
+//     xmlNodePtr digestNode = xmlSecGetNextElementNode(cur->children);
+//     xmlFree(href);
+//     if (!isMGF1) {
+//       return MY_pssError(transform, "MaskGenerationFunction", XMLSEC_ERRORS_R_INVALID_NODE, "unsupported mask generation function");
+//     }
+//     if (digestNode != NULL) {
+//       if (!xmlSecCheckNodeName(digestNode, xmlSecNodeDigestMethod, xmlSecDSigNs) ||
+//           xmlSecGetNextElementNode(digestNode->next) != NULL ||
+//           (ctx->mgfMd = MY_pssNodeDigest(digestNode)) == NULL) {
+//         return MY_pssError(transform, "MaskGenerationFunction", XMLSEC_ERRORS_R_INVALID_NODE, "unsupported digest method");
+//       }
+//     }
+//     cur = xmlSecGetNextElementNode(cur->next);
+//   }
+//   if (cur != NULL && xmlSecCheckNodeName(cur, BAD_CAST "SaltLength", BAD_CAST MY_PSS_NS)) {
+//     if (MY_pssNodeInt(cur, &ctx->saltLen) < 0) {
+//       return MY_pssError(transform, "SaltLength", XMLSEC_ERRORS_R_INVALID_NODE_CONTENT, "invalid salt length");
+//     }
+//     cur = xmlSecGetNextElementNode(cur->next);
+//   }
+//   if (cur != NULL && xmlSecCheckNodeName(cur, BAD_CAST "TrailerField", BAD_CAST MY_PSS_NS)) {
+//     int trailerField = 0;
+//     if (MY_pssNodeInt(cur, &trailerField) < 0 || trailerField != 1) {
+//       return MY_pssError(transform, "TrailerField", XMLSEC_ERRORS_R_INVALID_NODE_CONTENT, "unsupported trailer field");
+//     }
+//     cur = xmlSecGetNextElementNode(cur->next);
+//   }
+//   if (cur != NULL) {
+//     return MY_pssError(transform, (const char*)cur->name, XMLSEC_ERRORS_R_UNEXPECTED_NODE, "unexpected element in RSAPSSParams");
+//   }
+//   return 0;
+// }
+//
+// static int MY_pssSetKeyReq(xmlSecTransformPtr transform, xmlSecKeyReqPtr keyReq) {
+//   keyReq->keyId = xmlSecOpenSSLKeyDataRsaId;
+//   if (transform->operation == xmlSecTransformOperationSign) {
+//     keyReq->keyType = xmlSecKeyDataTypePrivate;
+//     keyReq->keyUsage = xmlSecKeyUsageSign;
+//   } else {
+//     keyReq->keyType = xmlSecKeyDataTypePublic;
+//     keyReq->keyUsage = xmlSecKeyUsageVerify;
+//   }
+//   return 0;
+// }
+//
+// static int MY_pssSetKey(xmlSecTransformPtr transform, xmlSecKeyPtr key) {
+//   MY_pssCtx *ctx = MY_pssGetCtx(transform);
+//   EVP_PKEY *pKey = xmlSecOpenSSLKeyDataRsaGetEvp(xmlSecKeyGetValue(key));
+//   if (pKey == NULL) {
+//     return MY_pssError(transform, "xmlSecOpenSSLKeyDataRsaGetEvp", XMLSEC_ERRORS_R_INVALID_KEY_DATA, "not an RSA key");
+//   }
+//   if (ctx->pKey != NULL) {
+//     EVP_PKEY_free(ctx->pKey);
+//   }
+//   ctx->pKey = xmlSecOpenSSLEvpKeyDup(pKey);
+//   if (ctx->pKey == NULL) {
+//     return MY_pssError(transform, "xmlSecOpenSSLEvpKeyDup", XMLSEC_ERRORS_R_CRYPTO_FAILED, "cannot copy key");
+//   }
+//   return 0;
+// }
+//
+// static int MY_pssStart(xmlSecTransformPtr transform) {
+//   MY_pssCtx *ctx = MY_pssGetCtx(transform);
+//   EVP_PKEY_CTX *pKeyCtx = NULL;
+//   int rv;
+//   if (ctx->pKey == NULL || ctx->md == NULL) {
+//     return MY_pssError(transform, NULL, XMLSEC_ERRORS_R_INVALID_TRANSFORM, "transform is not initialized");
+//   }
+//   ctx->mdCtx = EVP_MD_CTX_new();
+//   if (ctx->mdCtx == NULL) {
+//     return MY_pssError(transform, "EVP_MD_CTX_new", XMLSEC_ERRORS_R_CRYPTO_FAILED, "cannot create digest context");
+//   }
+//   if (transform->operation == xmlSecTransformOperationSign) {
+//     rv = EVP_DigestSignInit(ctx->mdCtx, &pKeyCtx, ctx->md, NULL, ctx->pKey);
+//   } else {
+//     rv = EVP_DigestVerifyInit(ctx->mdCtx, &pKeyCtx, ctx->md, NULL, ctx->pKey);
+//   }
+//   if (rv != 1 ||
+//       EVP_PKEY_CTX_set_rsa_padding(pKeyCtx, RSA_PKCS1_PSS_PADDING) <= 0 ||
+//       EVP_PKEY_CTX_set_rsa_pss_saltlen(pKeyCtx, ctx->saltLen) <= 0 ||
+//       EVP_PKEY_CTX_set_rsa_mgf1_md(pKeyCtx, ctx->mgfMd != NULL ? ctx->mgfMd : ctx->md) <= 0) {
+//     ERR_clear_error();
+//     return MY_pssError(transform, "EVP_DigestSignInit", XMLSEC_ERRORS_R_CRYPTO_FAILED, "cannot initialize RSASSA-PSS");
+//   }
+//   return 0;
+// }
+//
+// static int MY_pssExecute(xmlSecTransformPtr transform, int last, xmlSecTransformCtxPtr transformCtx) {
+//   MY_pssCtx *ctx = MY_pssGetCtx(transform);
+//   xmlSecBufferPtr in = &(transform->inBuf);
+//   xmlSecBufferPtr out = &(transform->outBuf);
+//   xmlSecSize inSize = xmlSecBufferGetSize(in);
+//
+//   if (transform->status == xmlSecTransformStatusNone) {
+//     if (MY_pssStart(transform) < 0) {
+//       return -1;
+//     }
+//     transform->status = xmlSecTransformStatusWorking;
+//   }
+//   if (transform->status == xmlSecTransformStatusWorking && inSize > 0) {
+//     if (EVP_DigestUpdate(ctx->mdCtx, xmlSecBufferGetData(in), inSize) != 1) {
+//       ERR_clear_error();
+//       return MY_pssError(transform, "EVP_DigestUpdate", XMLSEC_ERRORS_R_CRYPTO_FAILED, "cannot digest data");
+//     }
+//     if (xmlSecBufferRemoveHead(in, inSize) < 0) {
+//       return -1;
+//     }
+//   }
+//   if (transform->status == xmlSecTransformStatusWorking && last) {
+//     if (transform->operation == xmlSecTransformOperationSign) {
+//       size_t size = 0;
+//       if (EVP_DigestSignFinal(ctx->mdCtx, NULL, &size) != 1 ||
+//           xmlSecBufferSetMaxSize(out, size) < 0 ||
+//           EVP_DigestSignFinal(ctx->mdCtx, xmlSecBufferGetData(out), &size) != 1 ||
+//           xmlSecBufferSetSize(out, size) < 0) {
+//         ERR_clear_error();
+//         return MY_pssError(transform, "EVP_DigestSignFinal", XMLSEC_ERRORS_R_CRYPTO_FAILED, "cannot sign data");
+//       }
+//     }
+//     transform->status = xmlSecTransformStatusFinished;
+//   }
+//   return 0;
+// }
+//
+// static int MY_pssVerify(xmlSecTransformPtr transform, const xmlSecByte *data, xmlSecSize dataSize, xmlSecTransformCtxPtr transformCtx) {
+//   MY_pssCtx *ctx = MY_pssGetCtx(transform);
+//   if (transform->operation != xmlSecTransformOperationVerify ||
+//       transform->status != xmlSecTransformStatusFinished || ctx->mdCtx == NULL) {
+//     return MY_pssError(transform, NULL, XMLSEC_ERRORS_R_INVALID_STATUS, "transform is not finished");
+//   }
+//   if (EVP_DigestVerifyFinal(ctx->mdCtx, data, dataSize) == 1) {
+//     transform->status = xmlSecTransformStatusOk;
+//   } else {
+//     ERR_clear_error();
+//     transform->status = xmlSecTransformStatusFail;
+//   }
+//   return 0;
+// }
+//
+// // MY_pssRegister registers the RSASSA-PSS signature methods that xmlsec
+// // does not already provide.
+// static int MY_pssRegister(void) {
+//   size_t i;
+//   for (i = 0; i < MY_PSS_METHODS_COUNT; i++) {
+//     struct _xmlSecTransformKlass *klass = &MY_pssKlasses[i];
+//     if (xmlSecTransformIdListFindByHref(xmlSecTransformIdsGet(), BAD_CAST MY_pssMethods[i].href,
+//         xmlSecTransformUsageSignatureMethod) != xmlSecTransformIdUnknown) {
+//       continue;
+//     }
+//     memset(klass, 0, sizeof(*klass));
+//     klass->klassSize = sizeof(xmlSecTransformKlass);
+//     klass->objSize = sizeof(xmlSecTransform) + sizeof(MY_pssCtx);
+//     klass->name = BAD_CAST MY_pssMethods[i].name;
+//     klass->href = BAD_CAST MY_pssMethods[i].href;
+//     klass->usage = xmlSecTransformUsageSignatureMethod;
+//     klass->initialize = MY_pssInitialize;
+//     klass->finalize = MY_pssFinalize;
+//     klass->readNode = MY_pssNodeRead;
+//     klass->setKeyReq = MY_pssSetKeyReq;
+//     klass->setKey = MY_pssSetKey;
+//     klass->verify = MY_pssVerify;
+//     klass->getDataType = xmlSecTransformDefaultGetDataType;
+//     klass->pushBin = xmlSecTransformDefaultPushBin;
+//     klass->popBin = xmlSecTransformDefaultPopBin;
+//     klass->execute = MY_pssExecute;
+//     if (xmlSecTransformIdsRegister(klass) < 0) {
+//       return -1;
+//     }
+//   }
+//   return 0;
+// }
+//
+// // MY_pssGetParams stores the digests and the salt length used by the
+// // RSASSA-PSS transform and returns 0, or returns -1 if transform is not one
+// // of the RSASSA-PSS transforms registered by MY_pssRegister.
+// static int MY_pssGetParams(xmlSecTransformPtr transform, int *mdType, int *mgfMdType, int *saltLen) {
+//   MY_pssCtx *ctx;
+//   if (!MY_pssIsTransform(transform)) {
+//     return -1;
+//   }
+//   ctx = MY_pssGetCtx(transform);
+//   *mdType = EVP_MD_type(ctx->md);
+//   *mgfMdType = EVP_MD_type(ctx->mgfMd != NULL ? ctx->mgfMd : ctx->md);
+//   *saltLen = ctx->saltLen == RSA_PSS_SALTLEN_DIGEST ? EVP_MD_size(ctx->md) : ctx->saltLen;
+//   return 0;
+// }
+import "C"
+
+// #include <libxml/tree.h>
+import "C"
+
+// global string constants
+// Note: the invocations of C.CString() here return a pointer to a string
+// allocated from the C heap that would normally need to freed by calling
+// C.free, but because these are global, we can just leak them.
+var (
+	constPSSNamespace           = (*C.xmlChar)(unsafe.Pointer(C.CString(pssNamespace)))
+	constRSAPSSParams           = (*C.xmlChar)(unsafe.Pointer(C.CString("RSAPSSParams")))
+	constMaskGenerationFunction = (*C.xmlChar)(unsafe.Pointer(C.CString("MaskGenerationFunction")))
+	constSaltLength             = (*C.xmlChar)(unsafe.Pointer(C.CString("SaltLength")))
+	constTrailerField           = (*C.xmlChar)(unsafe.Pointer(C.CString("TrailerField")))
+)
+
+// pssNamespace is the namespace of the RSASSA-PSS algorithms and of the
+// RSAPSSParams element.
+const pssNamespace = "http://www.w3.org/2007/05/xmldsig-more#"
+
+// mgf1Method is the URI of the MGF1 mask generation function, which is the
+// only one supported for RSASSA-PSS.
+const mgf1Method = pssNamespace + "MGF1"
+
+// RSAPSSParams describes the parameters of an RSASSA-PSS SignatureMethod, as
+// specified by RFC 9231, section 2.3.10. Parameters that are not specified
+// take their default values: the digest implied by the SignatureMethod URI
+// (SHA-256 for http://www.w3.org/2007/05/xmldsig-more#rsa-pss), MGF1 with the
+// same digest, and a salt as long as the digest output.
+type RSAPSSParams struct {
+	// DigestMethod is the digest applied to SignedInfo.
+	DigestMethod *Method `xml:"http://www.w3.org/2000/09/xmldsig# DigestMethod,omitempty"`
+
+	// MaskGenerationFunction is the mask generation function and its digest.
+	MaskGenerationFunction *MaskGenerationFunction `xml:"MaskGenerationFunction,omitempty"`
+
+	// SaltLength is the length of the salt in bytes. Zero selects the
+	// default.
+	SaltLength int `xml:"SaltLength,omitempty"`
+
+	// TrailerField must be zero, which leaves it out, or 1.
+	TrailerField int `xml:"TrailerField,omitempty"`
+}
+
+// MaskGenerationFunction is part of RSAPSSParams.
+type MaskGenerationFunction struct {
+	Algorithm    string  `xml:",attr,omitempty"`
+	DigestMethod *Method `xml:"http://www.w3.org/2000/09/xmldsig# DigestMethod,omitempty"`
+}
+
+// pssHashes maps the OpenSSL digest types to the hash functions that
+// SignWithSigner supports for RSASSA-PSS.
+var pssHashes = map[C.int]crypto.Hash{
+	C.NID_sha1:   crypto.SHA1,
+	C.NID_sha224: crypto.SHA224,
+	C.NID_sha256: crypto.SHA256,
+	C.NID_sha384: crypto.SHA384,
+	C.NID_sha512: crypto.SHA512,
+}
+
+// registerPSSTransforms makes the RSASSA-PSS signature methods available to
+// xmlsec. It must be called after xmlsec is initialized.
+func registerPSSTransforms() error {
+	if rv := C.MY_pssRegister(); rv < 0 {
+		return mustPopError()
+	}
+	return nil
+}
+
+// pssSignerOpts returns the options that make a crypto.Signer compute the
+// signature of the RSASSA-PSS signature method transform, or nil if
+// transform is not RSASSA-PSS.
+func pssSignerOpts(transform *C.xmlSecTransform) (*rsa.PSSOptions, error) {
+	var mdType, mgfMdType, saltLength C.int
+	if rv := C.MY_pssGetParams(transform, &mdType, &mgfMdType, &saltLength); rv < 0 {
+		return nil, nil
+	}
+	hash, ok := pssHashes[mdType]
+	if !ok {
+		return nil, errors.New("RSASSA-PSS digest method is not supported by SignWithSigner")
+	}
+	if mgfMdType != mdType {
+		return nil, errors.New("SignWithSigner requires the MGF1 digest method to match the RSASSA-PSS digest method")
+	}
+	return &rsa.PSSOptions{SaltLength: int(saltLength), Hash: hash}, nil
+}
+
+// addRSAPSSParams adds an RSAPSSParams element describing params to the
+// SignatureMethod element signMethodNode.
+func addRSAPSSParams(signMethodNode *C.xmlNode, params RSAPSSParams) error {
+	paramsNode := C.xmlSecAddChild(signMethodNode, constRSAPSSParams, constPSSNamespace)
+	if paramsNode == nil {
+		return mustPopError()
+	}
+
+	if params.DigestMethod != nil {
+		if err := addDigestMethod(paramsNode, params.DigestMethod.Algorithm); err != nil {
+			return err
+		}
+	}
+	if mgf := params.MaskGenerationFunction; mgf != nil {
+		mgfNode := C.xmlSecAddChild(paramsNode, constMaskGenerationFunction, constPSSNamespace)
+		if mgfNode == nil {
+			return mustPopError()
+		}
+		if mgf.Algorithm != "" {
+			algorithm := C.CString(mgf.Algorithm)
+			defer C.free(unsafe.Pointer(algorithm))
+			C.xmlSetProp(mgfNode, (*C.xmlChar)(unsafe.Pointer(&C.xmlSecAttrAlgorithm)),
+				(*C.xmlChar)(unsafe.Pointer(algorithm)))
+		}
+		if mgf.DigestMethod != nil {
+			if err := addDigestMethod(mgfNode, mgf.DigestMethod.Algorithm); err != nil {
+				return err
+			}
+		}
+	}
+	if params.SaltLength != 0 {
+		if err := addChildWithContent(paramsNode, constSaltLength, constPSSNamespace,
+			strconv.Itoa(params.SaltLength)); err != nil {
+			return err
+		}
+	}
+	if params.TrailerField != 0 {
+		if err := addChildWithContent(paramsNode, constTrailerField, constPSSNamespace,
+			strconv.Itoa(params.TrailerField)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addDigestMethod adds a DigestMethod element for algorithm to parent.
+func addDigestMethod(parent *C.xmlNode, algorithm string) error {
+	if findTransformID(algorithm, C.xmlSecTransformUsageDigestMethod) == nil {
+		return fmt.Errorf("unsupported digest method %q", algorithm)
+	}
+	node := C.xmlSecAddChild(parent, (*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeDigestMethod)), constDsigNamespace)
+	if node == nil {
+		return mustPopError()
+	}
+	cAlgorithm := C.CString(algorithm)
+	defer C.free(unsafe.Pointer(cAlgorithm))
+	C.xmlSetProp(node, (*C.xmlChar)(unsafe.Pointer(&C.xmlSecAttrAlgorithm)),
+		(*C.xmlChar)(unsafe.Pointer(cAlgorithm)))
+	return nil
+}
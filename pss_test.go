@@ -0,0 +1,169 @@
+package xmlsec
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/xml"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type PSSTest struct {
+	PKCS12 PKCS12Test
+
+	// RSASSA-PSS needs a larger key than the one in XMLDSigTest
+	PrivateKey *rsa.PrivateKey
+	Key        []byte
+	Cert       []byte
+}
+
+var _ = Suite(&PSSTest{})
+
+func (testSuite *PSSTest) SetUpSuite(c *C) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, IsNil)
+	testSuite.PrivateKey = privateKey
+	testSuite.Key, testSuite.Cert = newSelfSignedKey(c, privateKey)
+}
+
+func (testSuite *PSSTest) SetUpTest(c *C) {
+	testSuite.PKCS12.SetUpTest(c)
+}
+
+// pssTemplate returns a document containing a signature template that uses
+// signatureMethod with params.
+func (testSuite *PSSTest) pssTemplate(c *C, signatureMethod string, params *RSAPSSParams) []byte {
+	doc := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Envelope xmlns="urn:envelope"><Data>Hello, World!</Data></Envelope>
+`)
+	tmpl := SignatureTemplate{
+		SignatureMethod: signatureMethod,
+		RSAPSSParams:    params,
+		References: []ReferenceTemplate{{
+			DigestMethod: "http://www.w3.org/2001/04/xmlenc#sha256",
+			Transforms: []TransformTemplate{
+				{Algorithm: "http://www.w3.org/2000/09/xmldsig#enveloped-signature"},
+			},
+		}},
+	}
+	docWithTemplate, err := InsertSignatureTemplate(doc, tmpl, TemplateLocation{}, SignatureOptions{})
+	c.Assert(err, IsNil)
+	return docWithTemplate
+}
+
+func (testSuite *PSSTest) TestDefaultPSSSignature(c *C) {
+	doc := struct {
+		XMLName   xml.Name `xml:"urn:envelope Envelope"`
+		Data      string   `xml:"Data"`
+		Signature Signature
+	}{Data: "Hello, World!", Signature: DefaultPSSSignature(testSuite.Cert)}
+	docStr, err := xml.Marshal(doc)
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(string(docStr),
+		`<SignatureMethod Algorithm="http://www.w3.org/2007/05/xmldsig-more#sha256-rsa-MGF1">`+
+			`<RSAPSSParams xmlns="http://www.w3.org/2007/05/xmldsig-more#">`+
+			`<MaskGenerationFunction Algorithm="http://www.w3.org/2007/05/xmldsig-more#MGF1">`+
+			`<DigestMethod xmlns="http://www.w3.org/2000/09/xmldsig#" Algorithm="http://www.w3.org/2001/04/xmlenc#sha256"></DigestMethod>`+
+			`</MaskGenerationFunction><SaltLength>32</SaltLength></RSAPSSParams></SignatureMethod>`), Equals, true)
+
+	signed, err := Sign(testSuite.Key, docStr, SignatureOptions{})
+	c.Assert(err, IsNil)
+
+	result, err := VerifyDetailed(testSuite.Cert, signed, SignatureOptions{AlgorithmPolicy: SecureAlgorithmPolicy()})
+	c.Assert(err, IsNil)
+	c.Assert(result.SignatureMethod, Equals, "http://www.w3.org/2007/05/xmldsig-more#sha256-rsa-MGF1")
+
+	tampered := []byte(strings.Replace(string(signed), "Hello", "Goodbye", 1))
+	err = Verify(testSuite.Cert, tampered, SignatureOptions{})
+	c.Assert(err, Equals, ErrVerificationFailed)
+}
+
+func (testSuite *PSSTest) TestParams(c *C) {
+	docWithTemplate := testSuite.pssTemplate(c, "http://www.w3.org/2007/05/xmldsig-more#rsa-pss", &RSAPSSParams{
+		DigestMethod: &Method{Algorithm: "http://www.w3.org/2001/04/xmlenc#sha512"},
+		MaskGenerationFunction: &MaskGenerationFunction{
+			DigestMethod: &Method{Algorithm: "http://www.w3.org/2001/04/xmlenc#sha256"},
+		},
+		SaltLength:   20,
+		TrailerField: 1,
+	})
+	c.Assert(strings.Contains(string(docWithTemplate), `<RSAPSSParams xmlns="http://www.w3.org/2007/05/xmldsig-more#">
+<DigestMethod xmlns="http://www.w3.org/2000/09/xmldsig#" Algorithm="http://www.w3.org/2001/04/xmlenc#sha512"/>
+<MaskGenerationFunction>
+<DigestMethod xmlns="http://www.w3.org/2000/09/xmldsig#" Algorithm="http://www.w3.org/2001/04/xmlenc#sha256"/>
+</MaskGenerationFunction>
+<SaltLength>20</SaltLength>
+<TrailerField>1</TrailerField>
+</RSAPSSParams>`), Equals, true)
+
+	signed, err := Sign(testSuite.Key, docWithTemplate, SignatureOptions{})
+	c.Assert(err, IsNil)
+
+	err = Verify(testSuite.Cert, signed, SignatureOptions{})
+	c.Assert(err, IsNil)
+
+	// the parameters are part of SignedInfo, and the signature does not
+	// verify with different ones
+	for _, params := range []struct{ From, To string }{
+		{"<SaltLength>20</SaltLength>", "<SaltLength>21</SaltLength>"},
+		{`Algorithm="http://www.w3.org/2001/04/xmlenc#sha256"/>
+</MaskGenerationFunction>`, `Algorithm="http://www.w3.org/2001/04/xmlenc#sha512"/>
+</MaskGenerationFunction>`},
+	} {
+		c.Assert(strings.Contains(string(signed), params.From), Equals, true)
+		changed := []byte(strings.Replace(string(signed), params.From, params.To, 1))
+		err = Verify(testSuite.Cert, changed, SignatureOptions{})
+		c.Assert(err, Equals, ErrVerificationFailed)
+	}
+
+	// SHA-1 is not allowed as a PSS digest by the secure policy
+	sha1Template := testSuite.pssTemplate(c, "http://www.w3.org/2007/05/xmldsig-more#rsa-pss", &RSAPSSParams{
+		DigestMethod: &Method{Algorithm: "http://www.w3.org/2000/09/xmldsig#sha1"},
+	})
+	signed, err = Sign(testSuite.Key, sha1Template, SignatureOptions{})
+	c.Assert(err, IsNil)
+	err = Verify(testSuite.Cert, signed, SignatureOptions{AlgorithmPolicy: SecureAlgorithmPolicy()})
+	c.Assert(err, Equals, AlgorithmNotAllowedError{
+		Kind:      "digest method",
+		Algorithm: "http://www.w3.org/2000/09/xmldsig#sha1",
+	})
+}
+
+func (testSuite *PSSTest) TestSignWithSigner(c *C) {
+	key := testSuite.PrivateKey
+	for _, signatureMethod := range []string{
+		"http://www.w3.org/2007/05/xmldsig-more#sha256-rsa-MGF1",
+		"http://www.w3.org/2007/05/xmldsig-more#sha384-rsa-MGF1",
+		"http://www.w3.org/2007/05/xmldsig-more#sha512-rsa-MGF1",
+	} {
+		signer := &countingSigner{Signer: key}
+		signed, err := SignWithSigner(signer, nil, testSuite.pssTemplate(c, signatureMethod, nil), SignatureOptions{})
+		c.Assert(err, IsNil)
+		c.Assert(signer.Count, Equals, 1)
+
+		err = Verify(testSuite.Cert, signed, SignatureOptions{})
+		c.Assert(err, IsNil)
+	}
+
+	docWithTemplate := testSuite.pssTemplate(c, "http://www.w3.org/2007/05/xmldsig-more#rsa-pss", &RSAPSSParams{
+		MaskGenerationFunction: &MaskGenerationFunction{
+			DigestMethod: &Method{Algorithm: "http://www.w3.org/2001/04/xmlenc#sha512"},
+		},
+	})
+	_, err := SignWithSigner(key, nil, docWithTemplate, SignatureOptions{})
+	c.Assert(err, ErrorMatches, "SignWithSigner requires the MGF1 digest method to match the RSASSA-PSS digest method")
+}
+
+func (testSuite *PSSTest) TestVerifyTrusted(c *C) {
+	docStr := []byte(strings.Replace(string(testSuite.PKCS12.DocStr),
+		"http://www.w3.org/2001/04/xmldsig-more#rsa-sha256",
+		"http://www.w3.org/2007/05/xmldsig-more#sha256-rsa-MGF1", 1))
+	signed, err := SignPKCS12(testSuite.PKCS12.Bundle, docStr, SignatureOptions{Password: "hunter2"})
+	c.Assert(err, IsNil)
+
+	result, err := VerifyTrustedDetailed([][]byte{testSuite.PKCS12.RootCert}, signed, SignatureOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(result.SignatureMethod, Equals, "http://www.w3.org/2007/05/xmldsig-more#sha256-rsa-MGF1")
+	c.Assert(result.PublicKey, FitsTypeOf, &rsa.PublicKey{})
+}
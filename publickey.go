@@ -0,0 +1,246 @@
+package xmlsec
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"unsafe"
+)
+
+// #include <xmlsec/xmlsec.h>
+// #include <xmlsec/keys.h>
+// #include <xmlsec/crypto.h>
+import "C"
+
+// subjectPublicKeyInfo is the SubjectPublicKeyInfo of RFC 5280, section 4.1.
+type subjectPublicKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+// VerifyWithPublicKey is like Verify, except that the key is a Go value:
+// either an *x509.Certificate or a public key such as an *rsa.PublicKey,
+// *ecdsa.PublicKey or ed25519.PublicKey.
+func VerifyWithPublicKey(publicKey crypto.PublicKey, doc []byte, opts SignatureOptions) error {
+	der, err := marshalPublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+	return Verify(der, doc, opts)
+}
+
+// marshalPublicKey returns the DER encoding of the certificate or public key
+// publicKey.
+func marshalPublicKey(publicKey crypto.PublicKey) ([]byte, error) {
+	if cert, ok := publicKey.(*x509.Certificate); ok {
+		if cert == nil || len(cert.Raw) == 0 {
+			return nil, errors.New("empty certificate")
+		}
+		return cert.Raw, nil
+	}
+	return x509.MarshalPKIXPublicKey(publicKey)
+}
+
+// loadPublicKey returns an xmlsec key for the public key key, which may be
+// in any of the forms accepted by Verify. If key is a certificate, it is
+// attached to the returned key. The caller owns the returned key.
+//
+// This function must be called between startProcessingXML() and
+// stopProcessingXML().
+func loadPublicKey(key []byte) (*C.xmlSecKey, error) {
+	if len(key) == 0 {
+		return nil, errors.New("empty public key")
+	}
+	der, isCertificate, err := publicKeyDER(key)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case der == nil:
+		// let xmlsec report what is wrong with key
+		return loadKeyMemory(key, C.xmlSecKeyDataFormatCertPem)
+	case isCertificate && isEdDSACertificate(der):
+		xmlsecKey, err := loadEdDSACertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		if rv := C.xmlSecCryptoAppKeyCertLoadMemory(xmlsecKey,
+			(*C.xmlSecByte)(unsafe.Pointer(&der[0])),
+			C.xmlSecSize(len(der)),
+			C.xmlSecKeyDataFormatCertDer); rv < 0 {
+			C.xmlSecKeyDestroy(xmlsecKey)
+			return nil, mustPopError()
+		}
+		return xmlsecKey, nil
+	case isCertificate:
+		return loadKeyMemory(der, C.xmlSecKeyDataFormatCertDer)
+	case isEdDSAPublicKey(der):
+		return loadEdDSAPublicKey(der)
+	default:
+		return loadKeyMemory(der, C.xmlSecKeyDataFormatDer)
+	}
+}
+
+// loadKeyMemory returns an xmlsec key for the public key or certificate
+// data, of the given format.
+func loadKeyMemory(data []byte, format C.xmlSecKeyDataFormat) (*C.xmlSecKey, error) {
+	key := C.xmlSecCryptoAppKeyLoadMemory(
+		(*C.xmlSecByte)(unsafe.Pointer(&data[0])),
+		C.xmlSecSize(len(data)),
+		format,
+		nil, nil, nil)
+	if key == nil {
+		return nil, mustPopError()
+	}
+	return key, nil
+}
+
+// publicKeyDER returns the DER encoding of the certificate or
+// SubjectPublicKeyInfo in key, which may be a PEM or DER encoded
+// certificate, SubjectPublicKeyInfo or PKCS#1 RSA public key, or a JSON Web
+// Key. isCertificate reports which of the two der is. der is nil if key is
+// in none of these forms.
+func publicKeyDER(key []byte) (der []byte, isCertificate bool, err error) {
+	if trimmed := bytes.TrimSpace(key); len(trimmed) != 0 && trimmed[0] == '{' {
+		der, err := jwkPublicKeyDER(trimmed)
+		return der, false, err
+	}
+
+	if block, _ := pem.Decode(key); block != nil {
+		switch block.Type {
+		case "CERTIFICATE":
+			return block.Bytes, true, nil
+		case "PUBLIC KEY":
+			return block.Bytes, false, nil
+		case "RSA PUBLIC KEY":
+			der, err := pkcs1PublicKeyDER(block.Bytes)
+			return der, false, err
+		default:
+			return nil, false, nil
+		}
+	}
+
+	// Certificate from RFC 5280, section 4.1
+	var certificate struct {
+		TBSCertificate     asn1.RawValue
+		SignatureAlgorithm pkix.AlgorithmIdentifier
+		SignatureValue     asn1.BitString
+	}
+	if rest, err := asn1.Unmarshal(key, &certificate); err == nil && len(rest) == 0 {
+		return key, true, nil
+	}
+	var publicKeyInfo subjectPublicKeyInfo
+	if rest, err := asn1.Unmarshal(key, &publicKeyInfo); err == nil && len(rest) == 0 {
+		return key, false, nil
+	}
+	if der, err := pkcs1PublicKeyDER(key); err == nil {
+		return der, false, nil
+	}
+	return nil, false, nil
+}
+
+// pkcs1PublicKeyDER returns the SubjectPublicKeyInfo for the PKCS#1 RSA
+// public key der.
+func pkcs1PublicKeyDER(der []byte) ([]byte, error) {
+	publicKey, err := x509.ParsePKCS1PublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	return x509.MarshalPKIXPublicKey(publicKey)
+}
+
+// jsonWebKey holds the public members of a JSON Web Key of RFC 7517, for the
+// key types of RFC 7518 and RFC 8037.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwkPublicKeyDER returns the SubjectPublicKeyInfo for the JSON Web Key data.
+func jwkPublicKeyDER(data []byte) ([]byte, error) {
+	var jwk jsonWebKey
+	if err := json.Unmarshal(data, &jwk); err != nil {
+		return nil, fmt.Errorf("cannot parse JSON Web Key: %s", err)
+	}
+
+	var err error
+	decode := func(s string) []byte {
+		b, decodeErr := base64.RawURLEncoding.DecodeString(s)
+		if decodeErr != nil || len(b) == 0 {
+			err = errors.New("invalid JSON Web Key")
+		}
+		return b
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		n, e := decode(jwk.N), decode(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if len(e) > 4 {
+			return nil, errors.New("invalid JSON Web Key")
+		}
+		return x509.MarshalPKIXPublicKey(&rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		})
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported JSON Web Key curve %q", jwk.Crv)
+		}
+		x, y := decode(jwk.X), decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return x509.MarshalPKIXPublicKey(&ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		})
+	case "OKP":
+		var algorithm asn1.ObjectIdentifier
+		switch jwk.Crv {
+		case "Ed25519":
+			algorithm = oidEd25519
+		case "Ed448":
+			algorithm = oidEd448
+		default:
+			return nil, fmt.Errorf("unsupported JSON Web Key curve %q", jwk.Crv)
+		}
+		x := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		return asn1.Marshal(subjectPublicKeyInfo{
+			Algorithm: pkix.AlgorithmIdentifier{Algorithm: algorithm},
+			PublicKey: asn1.BitString{Bytes: x, BitLength: 8 * len(x)},
+		})
+	default:
+		return nil, fmt.Errorf("unsupported JSON Web Key type %q", jwk.Kty)
+	}
+}
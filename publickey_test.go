@@ -0,0 +1,123 @@
+package xmlsec
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"math/big"
+
+	. "gopkg.in/check.v1"
+)
+
+type PublicKeyTest struct {
+	DSig   XMLDSigTest
+	Signed []byte
+	Cert   *x509.Certificate
+}
+
+var _ = Suite(&PublicKeyTest{})
+
+func (testSuite *PublicKeyTest) SetUpTest(c *C) {
+	testSuite.DSig.SetUpTest(c)
+
+	var err error
+	testSuite.Signed, err = Sign(testSuite.DSig.Key, testSuite.DSig.DocStr, SignatureOptions{})
+	c.Assert(err, IsNil)
+
+	block, _ := pem.Decode(testSuite.DSig.Cert)
+	testSuite.Cert, err = x509.ParseCertificate(block.Bytes)
+	c.Assert(err, IsNil)
+}
+
+func (testSuite *PublicKeyTest) TestVerifyEncodings(c *C) {
+	publicKey := testSuite.Cert.PublicKey.(*rsa.PublicKey)
+	spki, err := x509.MarshalPKIXPublicKey(publicKey)
+	c.Assert(err, IsNil)
+	pkcs1 := x509.MarshalPKCS1PublicKey(publicKey)
+	jwk := fmt.Sprintf(`{"kty": "RSA", "n": %q, "e": %q}`,
+		base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()))
+
+	for _, key := range [][]byte{
+		testSuite.Cert.Raw,
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: spki}),
+		spki,
+		pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: pkcs1}),
+		pkcs1,
+		[]byte(jwk),
+	} {
+		result, err := VerifyDetailed(key, testSuite.Signed, SignatureOptions{})
+		c.Assert(err, IsNil)
+		c.Assert(publicKey.Equal(result.PublicKey), Equals, true)
+	}
+
+	result, err := VerifyDetailed(testSuite.Cert.Raw, testSuite.Signed, SignatureOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(result.Certificate.Equal(testSuite.Cert), Equals, true)
+
+	result, err = VerifyDetailed(spki, testSuite.Signed, SignatureOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(result.Certificate, IsNil)
+
+	_, err = VerifyDetailed([]byte(`{"kty": "oct", "k": "c2VjcmV0"}`), testSuite.Signed, SignatureOptions{})
+	c.Assert(err, ErrorMatches, `unsupported JSON Web Key type "oct"`)
+}
+
+func (testSuite *PublicKeyTest) TestVerifyWithPublicKey(c *C) {
+	err := VerifyWithPublicKey(testSuite.Cert, testSuite.Signed, SignatureOptions{})
+	c.Assert(err, IsNil)
+
+	err = VerifyWithPublicKey(testSuite.Cert.PublicKey, testSuite.Signed, SignatureOptions{})
+	c.Assert(err, IsNil)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, IsNil)
+	err = VerifyWithPublicKey(&otherKey.PublicKey, testSuite.Signed, SignatureOptions{})
+	c.Assert(err, Equals, ErrVerificationFailed)
+}
+
+func (testSuite *PublicKeyTest) TestVerifyECDSAAndEdDSA(c *C) {
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	c.Assert(err, IsNil)
+
+	for _, tc := range []struct {
+		PrivateKey crypto.Signer
+		JWK        string
+	}{
+		{ecdsaKey, fmt.Sprintf(`{"kty": "EC", "crv": "P-256", "x": %q, "y": %q}`,
+			base64.RawURLEncoding.EncodeToString(ecdsaKey.X.FillBytes(make([]byte, 32))),
+			base64.RawURLEncoding.EncodeToString(ecdsaKey.Y.FillBytes(make([]byte, 32))))},
+		{ed25519Key, fmt.Sprintf(`{"kty": "OKP", "crv": "Ed25519", "x": %q}`,
+			base64.RawURLEncoding.EncodeToString(ed25519Key.Public().(ed25519.PublicKey)))},
+	} {
+		key, cert := newSelfSignedKey(c, tc.PrivateKey)
+		doc, err := xml.Marshal(struct {
+			XMLName   xml.Name `xml:"urn:envelope Envelope"`
+			Data      string   `xml:"Data"`
+			Signature Signature
+		}{Data: "Hello, World!", Signature: DefaultSignature(cert)})
+		c.Assert(err, IsNil)
+		signed, err := Sign(key, doc, SignatureOptions{})
+		c.Assert(err, IsNil)
+
+		err = VerifyWithPublicKey(tc.PrivateKey.Public(), signed, SignatureOptions{})
+		c.Assert(err, IsNil)
+
+		err = Verify([]byte(tc.JWK), signed, SignatureOptions{})
+		c.Assert(err, IsNil)
+
+		block, _ := pem.Decode(cert)
+		err = Verify(block.Bytes, signed, SignatureOptions{})
+		c.Assert(err, IsNil)
+	}
+}
@@ -0,0 +1,311 @@
+package xmlsec
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"runtime/cgo"
+	"strings"
+	"unsafe"
+)
+
+// #include <stdint.h>
+// #include <xmlsec/xmlsec.h>
+// #include <xmlsec/xmltree.h>
+// #include <xmlsec/keys.h>
+// #include <xmlsec/keyinfo.h>
+// #include <xmlsec/keysdata.h>
+// #include <xmlsec/keysmngr.h>
+//
+// void MY_resolverInitKeysMngr(xmlSecKeysMngrPtr keysMngr);  // defined in resolver_thunk.go
+// void MY_resolverAttach(xmlSecKeyInfoCtxPtr keyInfoCtx, uintptr_t handle);  // defined in resolver_thunk.go
+// int MY_resolverVerifyCerts(xmlSecKeyInfoCtxPtr keyInfoCtx, const xmlSecByte *der, xmlSecSize size);  // defined in resolver_thunk.go
+import "C"
+
+// KeyInfo describes the KeyInfo element that identifies the key of a
+// signature or of encrypted data. It is passed to a KeyResolver.
+type KeyInfo struct {
+	// KeyNames lists the contents of the KeyName elements.
+	KeyNames []string
+
+	// Certificates lists the certificates of the X509Certificate elements
+	// that could be parsed, in order.
+	Certificates []*x509.Certificate
+
+	// SubjectNames lists the contents of the X509SubjectName elements.
+	SubjectNames []string
+
+	// IssuerSerials lists the X509IssuerSerial elements.
+	IssuerSerials []X509IssuerSerial
+
+	// SKIs lists the decoded contents of the X509SKI elements.
+	SKIs [][]byte
+
+	// CRLs lists the certificate revocation lists of the X509CRL elements
+	// that could be parsed, in order.
+	CRLs []*x509.RevocationList
+
+	// KeyValue is the public key of the KeyValue element, or nil if there
+	// is none or it cannot be read.
+	KeyValue crypto.PublicKey
+}
+
+// X509IssuerSerial identifies a certificate by its issuer and serial number.
+type X509IssuerSerial struct {
+	IssuerName   string
+	SerialNumber *big.Int
+}
+
+// KeyResolver returns the key to use for the KeyInfo element keyInfo, in the
+// form accepted by the key argument of the function it was passed to: a
+// certificate or public key for Verify and VerifyTrusted, or a private key
+// for Decrypt. It lets the key be looked up only when it is needed, rather
+// than loaded in advance.
+//
+// If the resolver returns an empty key, or a key that is not suitable, the
+// key is found as if there were no resolver. If it returns an error, the
+// operation fails with that error.
+//
+// VerifyTrusted accepts only certificates from the resolver, PEM encoded
+// or as a single DER certificate, and uses the key of the one that the
+// trusted certificates validate, as for the certificates of the signature.
+// Any other certificates returned, such as intermediates, may help to
+// validate it.
+//
+// The resolver may be called more than once per document, for example for
+// both an EncryptedData element and its EncryptedKey. It must not call the
+// functions of this package.
+type KeyResolver func(keyInfo *KeyInfo) ([]byte, error)
+
+// keyResolverCall holds the state of a KeyResolver while xmlsec processes a
+// document.
+type keyResolverCall struct {
+	resolve KeyResolver
+	load    func(key []byte) (*C.xmlSecKey, error)
+
+	// key, if not nil, is used when resolve is nil or returns an empty
+	// key. It remains owned by the caller.
+	key *C.xmlSecKey
+
+	// exclusive limits xmlsec to the key of the call and to the keys it
+	// reads from the KeyInfo, so that it never falls back to the keys held
+	// by the keys manager.
+	exclusive bool
+
+	// trusted requires resolve to return certificates, and accepts only
+	// the one that the X509 store of the keys manager validates.
+	trusted bool
+
+	handle cgo.Handle
+	err    error
+}
+
+// attachKeyResolver makes keyInfoCtx ask resolve, which may be nil, for the
+// key when it reads a KeyInfo element. load turns the keys that resolve
+// returns into xmlsec keys. The caller must call detach when keyInfoCtx is no
+// longer used, and check err for an error from the resolver.
+func attachKeyResolver(keyInfoCtx *C.xmlSecKeyInfoCtx, resolve KeyResolver, load func([]byte) (*C.xmlSecKey, error)) *keyResolverCall {
+	call := &keyResolverCall{resolve: resolve, load: load}
+	call.handle = cgo.NewHandle(call)
+	C.MY_resolverAttach(keyInfoCtx, C.uintptr_t(call.handle))
+	return call
+}
+
+// detach releases the resources of the call.
+func (call *keyResolverCall) detach() {
+	call.handle.Delete()
+}
+
+//export onGetKey
+func onGetKey(keyInfoNode *C.xmlNode, keyInfoCtx *C.xmlSecKeyInfoCtx, handle C.uintptr_t) *C.xmlSecKey {
+	call := cgo.Handle(handle).Value().(*keyResolverCall)
+	if call.err != nil {
+		return nil
+	}
+
+	var key []byte
+	if call.resolve != nil {
+		var err error
+		if key, err = call.resolve(readKeyInfo(keyInfoNode)); err != nil {
+			call.err = err
+			return nil
+		}
+	}
+	if len(key) == 0 {
+		if call.key != nil {
+			return C.xmlSecKeyDuplicate(call.key)
+		}
+		return nil
+	}
+	if call.trusted {
+		var err error
+		if key, err = verifyResolvedCertificates(keyInfoCtx, key); err != nil {
+			call.err = err
+			return nil
+		}
+		if key == nil {
+			return nil
+		}
+	}
+
+	xmlsecKey, err := call.load(key)
+	if err != nil {
+		call.err = err
+		return nil
+	}
+	return xmlsecKey
+}
+
+// verifyResolvedCertificates returns the DER encoding of the certificate in
+// key that the X509 store of the keys manager of keyInfoCtx validates, or
+// nil if there is none. key holds the certificates returned by a
+// KeyResolver, either PEM encoded or as a single DER certificate.
+func verifyResolvedCertificates(keyInfoCtx *C.xmlSecKeyInfoCtx, key []byte) ([]byte, error) {
+	var certs [][]byte
+	for rest := key; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, errResolvedNotCertificate
+		}
+		certs = append(certs, block.Bytes)
+	}
+	if len(certs) == 0 {
+		certs = [][]byte{key}
+	}
+	for _, cert := range certs {
+		if _, err := x509.ParseCertificate(cert); err != nil {
+			return nil, errResolvedNotCertificate
+		}
+	}
+
+	der := bytes.Join(certs, nil)
+	i := C.MY_resolverVerifyCerts(keyInfoCtx, (*C.xmlSecByte)(unsafe.Pointer(&der[0])), C.xmlSecSize(len(der)))
+	if i < 0 {
+		return nil, nil
+	}
+	return certs[i], nil
+}
+
+// errKeyAndKeyResolver is returned when a KeyResolver is passed to a
+// function that takes its key from elsewhere.
+var errKeyAndKeyResolver = errors.New("cannot use both a key and a KeyResolver")
+
+// errResolvedNotCertificate is the error of VerifyTrusted when its
+// KeyResolver returns something other than certificates.
+var errResolvedNotCertificate = errors.New("the KeyResolver of VerifyTrusted must return certificates")
+
+//export onGetKeyExclusive
+func onGetKeyExclusive(handle C.uintptr_t) C.int {
+	if cgo.Handle(handle).Value().(*keyResolverCall).exclusive {
+		return 1
+	}
+	return 0
+}
+
+// initKeyResolverKeysMngr makes keysMngr consult the KeyResolver attached to
+// the KeyInfo context, if any, before it looks for a key itself. It must be
+// called after initEdDSAKeysMngr.
+func initKeyResolverKeysMngr(keysMngr *C.xmlSecKeysMngr) {
+	C.MY_resolverInitKeysMngr(keysMngr)
+}
+
+// readKeyInfo returns a description of the KeyInfo element keyInfoNode,
+// which may be nil.
+func readKeyInfo(keyInfoNode *C.xmlNode) *KeyInfo {
+	keyInfo := &KeyInfo{}
+	if keyInfoNode == nil {
+		return keyInfo
+	}
+
+	for node := firstDSigChild(keyInfoNode, (*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeKeyName))); node != nil; node = nextDSigElement(node.next, (*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeKeyName))) {
+		keyInfo.KeyNames = append(keyInfo.KeyNames, strings.TrimSpace(getContent(node)))
+	}
+
+	if node := firstDSigChild(keyInfoNode, (*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeKeyValue))); node != nil {
+		keyInfo.KeyValue = readKeyValue(node)
+	}
+
+	x509DataName := (*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeX509Data))
+	for x509DataNode := firstDSigChild(keyInfoNode, x509DataName); x509DataNode != nil; x509DataNode = nextDSigElement(x509DataNode.next, x509DataName) {
+		readX509Data(x509DataNode, keyInfo)
+	}
+	return keyInfo
+}
+
+// readX509Data adds the contents of the X509Data element x509DataNode to
+// keyInfo.
+func readX509Data(x509DataNode *C.xmlNode, keyInfo *KeyInfo) {
+	for node := C.xmlSecGetNextElementNode(x509DataNode.children); node != nil; node = C.xmlSecGetNextElementNode(node.next) {
+		isNamed := func(name *C.xmlChar) bool {
+			return C.xmlSecCheckNodeName(node, name, (*C.xmlChar)(unsafe.Pointer(&C.xmlSecDSigNs))) == 1
+		}
+		switch {
+		case isNamed((*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeX509Certificate))):
+			der, err := decodeBase64(getContent(node))
+			if err != nil {
+				continue
+			}
+			if cert, err := x509.ParseCertificate(der); err == nil {
+				keyInfo.Certificates = append(keyInfo.Certificates, cert)
+			}
+		case isNamed((*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeX509SubjectName))):
+			keyInfo.SubjectNames = append(keyInfo.SubjectNames, strings.TrimSpace(getContent(node)))
+		case isNamed((*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeX509IssuerSerial))):
+			issuerSerial := X509IssuerSerial{}
+			if issuerNameNode := firstDSigChild(node, (*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeX509IssuerName))); issuerNameNode != nil {
+				issuerSerial.IssuerName = strings.TrimSpace(getContent(issuerNameNode))
+			}
+			if serialNode := firstDSigChild(node, (*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeX509SerialNumber))); serialNode != nil {
+				issuerSerial.SerialNumber, _ = new(big.Int).SetString(strings.TrimSpace(getContent(serialNode)), 10)
+			}
+			keyInfo.IssuerSerials = append(keyInfo.IssuerSerials, issuerSerial)
+		case isNamed((*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeX509SKI))):
+			if ski, err := decodeBase64(getContent(node)); err == nil {
+				keyInfo.SKIs = append(keyInfo.SKIs, ski)
+			}
+		case isNamed((*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeX509CRL))):
+			der, err := decodeBase64(getContent(node))
+			if err != nil {
+				continue
+			}
+			if crl, err := x509.ParseRevocationList(der); err == nil {
+				keyInfo.CRLs = append(keyInfo.CRLs, crl)
+			}
+		}
+	}
+}
+
+// readKeyValue returns the public key of the KeyValue element keyValueNode,
+// or nil if xmlsec cannot read it.
+func readKeyValue(keyValueNode *C.xmlNode) crypto.PublicKey {
+	keyInfoCtx := C.xmlSecKeyInfoCtxCreate(nil)
+	if keyInfoCtx == nil {
+		return nil
+	}
+	defer C.xmlSecKeyInfoCtxDestroy(keyInfoCtx)
+
+	key := C.xmlSecKeyCreate()
+	if key == nil {
+		return nil
+	}
+	defer C.xmlSecKeyDestroy(key)
+
+	if rv := C.xmlSecKeyDataXmlRead(C.xmlSecKeyDataValueGetKlass(), key, keyValueNode, keyInfoCtx); rv < 0 {
+		return nil
+	}
+	publicKey, _ := keyPublicParts(key)
+	return publicKey
+}
+
+// decodeBase64 decodes the base64 content of an element, which may be
+// broken into lines.
+func decodeBase64(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+}
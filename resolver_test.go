@@ -0,0 +1,191 @@
+package xmlsec
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type KeyResolverTest struct {
+	DSig    XMLDSigTest
+	Encrypt EncryptTest
+	Chain   ChainTest
+}
+
+var _ = Suite(&KeyResolverTest{})
+
+func (testSuite *KeyResolverTest) SetUpSuite(c *C) {
+	testSuite.Chain.SetUpSuite(c)
+}
+
+func (testSuite *KeyResolverTest) SetUpTest(c *C) {
+	testSuite.DSig.SetUpTest(c)
+	testSuite.Encrypt.SetUpTest(c)
+}
+
+// signedDoc returns a document signed with key whose KeyInfo is described
+// by keyInfo.
+func (testSuite *KeyResolverTest) signedDoc(c *C, key []byte, keyInfo KeyInfoTemplate, opts SignatureOptions) []byte {
+	doc, err := InsertSignatureTemplate([]byte(`<Envelope xmlns="urn:envelope"><Data>Hello, World!</Data></Envelope>`), SignatureTemplate{
+		SignatureMethod: "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256",
+		References: []ReferenceTemplate{{
+			DigestMethod: "http://www.w3.org/2001/04/xmlenc#sha256",
+			Transforms: []TransformTemplate{
+				{Algorithm: "http://www.w3.org/2000/09/xmldsig#enveloped-signature"},
+			},
+		}},
+		KeyInfo: &keyInfo,
+	}, TemplateLocation{}, SignatureOptions{})
+	c.Assert(err, IsNil)
+	signed, err := Sign(key, doc, opts)
+	c.Assert(err, IsNil)
+	return signed
+}
+
+func (testSuite *KeyResolverTest) TestVerifyX509Data(c *C) {
+	chain := testSuite.Chain.Chain
+	signed := testSuite.signedDoc(c, chain.LeafKeyPEM, KeyInfoTemplate{KeyName: "signer", X509Data: true}, SignatureOptions{
+		Certificates: []*x509.Certificate{chain.Leaf, chain.Intermediate},
+		X509Data:     X509DataOptions{IssuerSerial: true, SubjectName: true, SKI: true},
+	})
+
+	var keyInfos []*KeyInfo
+	err := Verify(nil, signed, SignatureOptions{
+		KeyResolver: func(keyInfo *KeyInfo) ([]byte, error) {
+			keyInfos = append(keyInfos, keyInfo)
+			return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: keyInfo.Certificates[0].Raw}), nil
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(keyInfos, HasLen, 1)
+
+	keyInfo := keyInfos[0]
+	c.Assert(keyInfo.KeyNames, DeepEquals, []string{"signer"})
+	c.Assert(keyInfo.Certificates, HasLen, 2)
+	c.Assert(keyInfo.Certificates[0].Equal(chain.Leaf), Equals, true)
+	c.Assert(keyInfo.SubjectNames, DeepEquals, []string{"CN=go-xmlsec test signer"})
+	c.Assert(keyInfo.IssuerSerials, HasLen, 1)
+	c.Assert(keyInfo.IssuerSerials[0].IssuerName, Equals, "CN=go-xmlsec test intermediate")
+	c.Assert(keyInfo.IssuerSerials[0].SerialNumber.Int64(), Equals, int64(3))
+	c.Assert(keyInfo.SKIs, DeepEquals, [][]byte{{1, 2, 3, 4}})
+	c.Assert(keyInfo.KeyValue, IsNil)
+}
+
+func (testSuite *KeyResolverTest) TestVerifyKeyValue(c *C) {
+	signed := testSuite.signedDoc(c, testSuite.DSig.Key, KeyInfoTemplate{KeyValue: true}, SignatureOptions{})
+
+	block, _ := pem.Decode(testSuite.DSig.Cert)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	c.Assert(err, IsNil)
+
+	err = Verify(nil, signed, SignatureOptions{
+		KeyResolver: func(keyInfo *KeyInfo) ([]byte, error) {
+			publicKey, ok := keyInfo.KeyValue.(*rsa.PublicKey)
+			c.Assert(ok, Equals, true)
+			c.Assert(publicKey.Equal(cert.PublicKey), Equals, true)
+			return testSuite.DSig.Cert, nil
+		},
+	})
+	c.Assert(err, IsNil)
+}
+
+func (testSuite *KeyResolverTest) TestVerifyFallback(c *C) {
+	signed := testSuite.signedDoc(c, testSuite.DSig.Key, KeyInfoTemplate{KeyName: "unknown"}, SignatureOptions{})
+	resolver := func(keyInfo *KeyInfo) ([]byte, error) {
+		return nil, nil
+	}
+
+	err := Verify(testSuite.DSig.Cert, signed, SignatureOptions{KeyResolver: resolver})
+	c.Assert(err, IsNil)
+
+	err = Verify(nil, signed, SignatureOptions{KeyResolver: resolver})
+	c.Assert(err, Equals, ErrVerificationFailed)
+
+	err = Verify(nil, signed, SignatureOptions{
+		KeyResolver: func(keyInfo *KeyInfo) ([]byte, error) {
+			return nil, errors.New("no such partner")
+		},
+	})
+	c.Assert(err, ErrorMatches, "no such partner")
+}
+
+func (testSuite *KeyResolverTest) TestDocumentKeyIgnored(c *C) {
+	resolver := func(keyInfo *KeyInfo) ([]byte, error) {
+		return nil, nil
+	}
+
+	rsaKeyValue, hmacKeyValue := forgedSignatures(c)
+	for _, forged := range [][]byte{rsaKeyValue, hmacKeyValue} {
+		_, err := VerifyDetailed(nil, forged, SignatureOptions{KeyResolver: resolver})
+		c.Assert(err, Equals, ErrVerificationFailed)
+	}
+
+	_, err := DecryptWithOptions(nil, selfKeyedEncryption(c), DecryptOptions{KeyResolver: resolver})
+	c.Assert(err, NotNil)
+}
+
+func (testSuite *KeyResolverTest) TestVerifyTrusted(c *C) {
+	chain := testSuite.Chain.Chain
+	signed := testSuite.signedDoc(c, chain.LeafKeyPEM, KeyInfoTemplate{KeyName: "signer"}, SignatureOptions{})
+	certs := append(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: chain.Leaf.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: chain.Intermediate.Raw})...)
+
+	result, err := VerifyTrustedDetailed([][]byte{chain.RootPEM}, signed, SignatureOptions{
+		KeyResolver: func(keyInfo *KeyInfo) ([]byte, error) {
+			return certs, nil
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(result.Certificate.Equal(chain.Leaf), Equals, true)
+
+	publicKey, err := x509.MarshalPKIXPublicKey(chain.Leaf.PublicKey)
+	c.Assert(err, IsNil)
+	err = VerifyTrusted([][]byte{chain.RootPEM}, signed, SignatureOptions{
+		KeyResolver: func(keyInfo *KeyInfo) ([]byte, error) {
+			return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey}), nil
+		},
+	})
+	c.Assert(err, ErrorMatches, "the KeyResolver of VerifyTrusted must return certificates")
+
+	// a certificate that the trusted certificates do not validate
+	signed = testSuite.signedDoc(c, testSuite.DSig.Key, KeyInfoTemplate{KeyName: "signer"}, SignatureOptions{})
+	err = VerifyTrusted([][]byte{chain.RootPEM}, signed, SignatureOptions{
+		KeyResolver: func(keyInfo *KeyInfo) ([]byte, error) {
+			return testSuite.DSig.Cert, nil
+		},
+	})
+	c.Assert(err, Equals, ErrVerificationFailed)
+}
+
+func (testSuite *KeyResolverTest) TestDecrypt(c *C) {
+	encrypted, err := Encrypt(testSuite.Encrypt.Cert, testSuite.Encrypt.Plaintext, EncryptOptions{
+		KeyName: "current",
+	})
+	c.Assert(err, IsNil)
+
+	var keyNames []string
+	plaintext, err := DecryptWithOptions(nil, encrypted, DecryptOptions{
+		KeyResolver: func(keyInfo *KeyInfo) ([]byte, error) {
+			keyNames = append(keyNames, keyInfo.KeyNames...)
+			if len(keyInfo.KeyNames) == 1 && keyInfo.KeyNames[0] == "current" {
+				return testSuite.Encrypt.Key, nil
+			}
+			return nil, nil
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(string(plaintext), "EncryptedData"), Equals, false)
+	c.Assert(keyNames, DeepEquals, []string{"current"})
+
+	_, err = DecryptWithOptions(nil, encrypted, DecryptOptions{
+		KeyResolver: func(keyInfo *KeyInfo) ([]byte, error) {
+			return nil, errors.New("no such key")
+		},
+	})
+	c.Assert(err, ErrorMatches, "no such key")
+}
@@ -0,0 +1,80 @@
+package xmlsec
+
+// #include <stdint.h>
+// #include <xmlsec/xmlsec.h>
+// #include <xmlsec/keys.h>
+// #include <xmlsec/keyinfo.h>
+// #include <xmlsec/keysmngr.h>
+// #include <xmlsec/openssl/x509.h>
+// #include <openssl/x509.h>
+//
+// xmlSecKeyPtr onGetKey(xmlNodePtr keyInfoNode, xmlSecKeyInfoCtxPtr keyInfoCtx, uintptr_t handle);  // implemented in go
+// int onGetKeyExclusive(uintptr_t handle);  // implemented in go
+// xmlSecKeyPtr MY_eddsaGetKey(xmlNodePtr keyInfoNode, xmlSecKeyInfoCtxPtr keyInfoCtx);  // defined in eddsa.go
+// xmlSecKeyPtr MY_eddsaGetKeyFromKeyInfo(xmlNodePtr keyInfoNode, xmlSecKeyInfoCtxPtr keyInfoCtx);  // defined in eddsa.go
+//
+// // MY_resolverGetKey is the keys manager callback that finds the key for
+// // a KeyInfo. If a KeyResolver is attached to keyInfoCtx we ask it first,
+// // and otherwise (or if it does not return a suitable key) fall back to the
+// // usual lookup, or only to the contents of the KeyInfo if the call is
+// // exclusive. Either way only the key data enabled in keyInfoCtx is read,
+// // which never includes keys carried by the document itself.
+// static xmlSecKeyPtr MY_resolverGetKey(xmlNodePtr keyInfoNode, xmlSecKeyInfoCtxPtr keyInfoCtx) {
+//   if (keyInfoCtx->userData != NULL) {
+//     uintptr_t handle = (uintptr_t)keyInfoCtx->userData;
+//     xmlSecKeyPtr key = onGetKey(keyInfoNode, keyInfoCtx, handle);
+//     if (key != NULL) {
+//       if (xmlSecKeyMatch(key, NULL, &(keyInfoCtx->keyReq)) == 1) {
+//         return key;
+//       }
+//       xmlSecKeyDestroy(key);
+//     }
+//     if (onGetKeyExclusive(handle)) {
+//       return MY_eddsaGetKeyFromKeyInfo(keyInfoNode, keyInfoCtx);
+//     }
+//   }
+//   return MY_eddsaGetKey(keyInfoNode, keyInfoCtx);
+// }
+//
+// // MY_resolverVerifyCerts returns the index of the certificate among the
+// // DER encoded certificates concatenated in der that the X509 store of the
+// // keys manager validates, or -1 if there is none.
+// int MY_resolverVerifyCerts(xmlSecKeyInfoCtxPtr keyInfoCtx, const xmlSecByte *der, xmlSecSize size) {
+//   xmlSecKeyDataStorePtr store = xmlSecKeysMngrGetDataStore(keyInfoCtx->keysMngr, xmlSecOpenSSLX509StoreId);
+//   const unsigned char *p = der;
+//   STACK_OF(X509) *certs;
+//   X509 *cert;
+//   int i, rv = -1;
+//
+//   if (store == NULL || (certs = sk_X509_new_null()) == NULL) {
+//     return -1;
+//   }
+//   while (p < der + size && (cert = d2i_X509(NULL, &p, (long)(der + size - p))) != NULL) {
+//     if (sk_X509_push(certs, cert) <= 0) {
+//       X509_free(cert);
+//       break;
+//     }
+//   }
+//   if (sk_X509_num(certs) > 0 &&
+//       (cert = xmlSecOpenSSLX509StoreVerify(store, certs, NULL, keyInfoCtx)) != NULL) {
+//     for (i = 0; i < sk_X509_num(certs); i++) {
+//       if (sk_X509_value(certs, i) == cert) {
+//         rv = i;
+//       }
+//     }
+//   }
+//   sk_X509_pop_free(certs, X509_free);
+//   return rv;
+// }
+//
+// void MY_resolverInitKeysMngr(xmlSecKeysMngrPtr keysMngr) {
+//   keysMngr->getKey = MY_resolverGetKey;
+// }
+//
+// // MY_resolverAttach stores handle in keyInfoCtx. xmlsec never touches
+// // userData, but copies it to the contexts it creates to read the KeyInfo
+// // of an EncryptedKey.
+// void MY_resolverAttach(xmlSecKeyInfoCtxPtr keyInfoCtx, uintptr_t handle) {
+//   keyInfoCtx->userData = (void*)handle;
+// }
+import "C"
@@ -0,0 +1,336 @@
+package xmlsec
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/xml"
+	"regexp"
+	"unsafe"
+)
+
+// #include <stdlib.h>
+// #include <xmlsec/xmlsec.h>
+// #include <xmlsec/xmltree.h>
+// #include <xmlsec/xmldsig.h>
+// #include <xmlsec/keys.h>
+// #include <xmlsec/list.h>
+// #include <xmlsec/transforms.h>
+// #include <xmlsec/openssl/crypto.h>
+// #include <xmlsec/openssl/evp.h>
+// #include <xmlsec/openssl/x509.h>
+// #include <openssl/crypto.h>
+// #include <openssl/evp.h>
+// #include <openssl/x509.h>
+//
+// // defined in eddsa.go
+// EVP_PKEY* MY_eddsaKeyDataGetEvp(xmlSecKeyDataPtr data);
+//
+// // MY_xmlSecKeyGetEvp returns the OpenSSL key of key, or NULL if it is not
+// // an asymmetric key.
+// static EVP_PKEY* MY_xmlSecKeyGetEvp(xmlSecKeyPtr key) {
+//   xmlSecKeyDataPtr value = xmlSecKeyGetValue(key);
+//   if (value == NULL) {
+//     return NULL;
+//   }
+//   if (xmlSecKeyDataCheckId(value, xmlSecOpenSSLKeyDataRsaId)
+// #ifndef XMLSEC_NO_ECDSA
+//       || xmlSecKeyDataCheckId(value, xmlSecOpenSSLKeyDataEcdsaId)
+// #endif
+// #ifndef XMLSEC_NO_DSA
+//       || xmlSecKeyDataCheckId(value, xmlSecOpenSSLKeyDataDsaId)
+// #endif
+//   ) {
+//     return xmlSecOpenSSLEvpKeyDataGetEvp(value);
+//   }
+//   return MY_eddsaKeyDataGetEvp(value);
+// }
+//
+// // MY_xmlSecKeyPublicKeyDER stores the DER encoded SubjectPublicKeyInfo of
+// // the public part of key in *out and returns its length, or returns -1 if
+// // the key is not an asymmetric key. The result must be freed with
+// // MY_OPENSSL_free.
+// static int MY_xmlSecKeyPublicKeyDER(xmlSecKeyPtr key, unsigned char **out) {
+//   EVP_PKEY *pKey = MY_xmlSecKeyGetEvp(key);
+//   if (pKey == NULL) {
+//     return -1;
+//   }
+//   *out = NULL;
+//   return i2d_PUBKEY(pKey, out);
+// }
+//
+// // MY_xmlSecKeyCertDER stores the DER encoded certificate of key in *out and
+// // returns its length, or returns -1 if the key does not have a certificate.
+// // Keys loaded from a certificate do not record which of their certificates
+// // holds the key, so in that case we look for it. The result must be freed
+// // with MY_OPENSSL_free.
+// static int MY_xmlSecKeyCertDER(xmlSecKeyPtr key, unsigned char **out) {
+//   xmlSecKeyDataPtr data = xmlSecKeyGetData(key, xmlSecOpenSSLKeyDataX509Id);
+//   EVP_PKEY *pKey = MY_xmlSecKeyGetEvp(key);
+//   X509 *cert = NULL;
+//   xmlSecSize i;
+//   if (data == NULL) {
+//     return -1;
+//   }
+//   cert = xmlSecOpenSSLKeyDataX509GetKeyCert(data);
+//   for (i = 0; cert == NULL && pKey != NULL && i < xmlSecOpenSSLKeyDataX509GetCertsSize(data); i++) {
+//     X509 *c = xmlSecOpenSSLKeyDataX509GetCert(data, i);
+// #if OPENSSL_VERSION_NUMBER >= 0x30000000L
+//     if (c != NULL && EVP_PKEY_eq(X509_get0_pubkey(c), pKey) == 1) {
+// #else
+//     if (c != NULL && EVP_PKEY_cmp(X509_get0_pubkey(c), pKey) == 1) {
+// #endif
+//       cert = c;
+//     }
+//   }
+//   if (cert == NULL) {
+//     return -1;
+//   }
+//   *out = NULL;
+//   return i2d_X509(cert, out);
+// }
+//
+// // OPENSSL_free is a macro, so we need to wrap it in order to be able to
+// // call it from go code.
+// static inline void MY_OPENSSL_free(void *p) {
+//   OPENSSL_free(p);
+// }
+import "C"
+
+// VerificationResult describes a signature checked by VerifyDetailed.
+type VerificationResult struct {
+	// SignatureMethod is the URI of the signature algorithm, for example
+	// "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256".
+	SignatureMethod string
+
+	// CanonicalizationMethod is the URI of the algorithm used to
+	// canonicalize SignedInfo.
+	CanonicalizationMethod string
+
+	// References describes each Reference element of SignedInfo, in order.
+	References []ReferenceResult
+
+	// KeyName is the name of the key used to check the signature, if any.
+	KeyName string
+
+	// PublicKey is the key used to check the signature. It is nil if no
+	// suitable key was found.
+	PublicKey crypto.PublicKey
+
+	// Certificate is the certificate of the key used to check the
+	// signature, if the key has one.
+	Certificate *x509.Certificate
+
+	// Chain is the chain of certificates from Certificate to the trusted
+	// certificate that issued it, starting with Certificate. It is set by
+	// VerifyTrustedDetailed when the signature is valid.
+	Chain []*x509.Certificate
+
+	// keyInfoCertificates are the certificates of the KeyInfo element of
+	// the signature, which may be used to build Chain.
+	keyInfoCertificates []*x509.Certificate
+}
+
+// ReferenceResult describes a Reference element of a signature checked
+// by VerifyDetailed.
+type ReferenceResult struct {
+	// URI is the URI attribute of the Reference element.
+	URI string
+
+	// ID is the ID of the element that URI refers to, or empty if the
+	// reference covers the whole document or is not a same-document
+	// reference.
+	ID string
+
+	// Element is the name of the element that URI refers to, or the name
+	// of the root element if the reference covers the whole document. It is
+	// empty if the reference is not a same-document reference.
+	Element xml.Name
+
+	// Type is the Type attribute of the Reference element.
+	Type string
+
+	// Transforms lists the URIs of the transforms applied to the referenced
+	// data, in order.
+	Transforms []string
+
+	// DigestMethod is the URI of the digest algorithm.
+	DigestMethod string
+
+	// Status tells whether the digest of the referenced data matched.
+	Status ReferenceStatus
+}
+
+// ReferenceStatus is the outcome of checking the digest of a Reference.
+type ReferenceStatus int
+
+const (
+	// ReferenceNotChecked means that the reference was not processed,
+	// typically because verification failed before reaching it.
+	ReferenceNotChecked ReferenceStatus = xmlSecDSigStatusUnknown
+
+	// ReferenceValid means the digest of the referenced data matched.
+	ReferenceValid ReferenceStatus = xmlSecDSigStatusSucceeded
+
+	// ReferenceInvalid means the digest of the referenced data did not match.
+	ReferenceInvalid ReferenceStatus = xmlSecDSigStatusInvalid
+)
+
+func (s ReferenceStatus) String() string {
+	switch s {
+	case ReferenceValid:
+		return "valid"
+	case ReferenceInvalid:
+		return "invalid"
+	default:
+		return "not checked"
+	}
+}
+
+// newVerificationResult returns a description of the signature in
+// signatureNode which has been processed by dsigCtx.
+func newVerificationResult(dsigCtx *C.xmlSecDSigCtx, signatureNode *C.xmlNode) *VerificationResult {
+	result := &VerificationResult{}
+	if dsigCtx.signMethod != nil {
+		result.SignatureMethod = transformHref(dsigCtx.signMethod)
+	}
+	if dsigCtx.c14nMethod != nil {
+		result.CanonicalizationMethod = transformHref(dsigCtx.c14nMethod)
+	}
+
+	// xmlsec records the references in the order of the Reference elements,
+	// but not which transforms they declare, so we read those from the
+	// document.
+	signedInfoNode := C.xmlSecFindChild(signatureNode,
+		(*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeSignedInfo)),
+		(*C.xmlChar)(unsafe.Pointer(&C.xmlSecDSigNs)))
+	referenceCtxs := &dsigCtx.signedInfoReferences
+	referenceName := (*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeReference))
+	i := C.xmlSecSize(0)
+	for referenceNode := firstDSigChild(signedInfoNode, referenceName); referenceNode != nil; referenceNode = nextDSigElement(referenceNode.next, referenceName) {
+		reference := ReferenceResult{
+			URI:  getAttr(referenceNode, "URI"),
+			Type: getAttr(referenceNode, "Type"),
+		}
+		reference.ID, reference.Element = resolveReference(referenceNode.doc, reference.URI)
+
+		transformsNode := C.xmlSecFindChild(referenceNode,
+			(*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeTransforms)),
+			(*C.xmlChar)(unsafe.Pointer(&C.xmlSecDSigNs)))
+		transformName := (*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeTransform))
+		for transformNode := firstDSigChild(transformsNode, transformName); transformNode != nil; transformNode = nextDSigElement(transformNode.next, transformName) {
+			reference.Transforms = append(reference.Transforms, getAttr(transformNode, "Algorithm"))
+		}
+
+		if digestMethodNode := C.xmlSecFindChild(referenceNode,
+			(*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeDigestMethod)),
+			(*C.xmlChar)(unsafe.Pointer(&C.xmlSecDSigNs))); digestMethodNode != nil {
+			reference.DigestMethod = getAttr(digestMethodNode, "Algorithm")
+		}
+
+		if i < C.xmlSecPtrListGetSize(referenceCtxs) {
+			referenceCtx := (*C.xmlSecDSigReferenceCtx)(C.xmlSecPtrListGetItem(referenceCtxs, i))
+			if referenceCtx != nil {
+				reference.Status = ReferenceStatus(referenceCtx.status)
+			}
+		}
+		i++
+
+		result.References = append(result.References, reference)
+	}
+
+	result.keyInfoCertificates = readKeyInfo(C.xmlSecFindChild(signatureNode,
+		(*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeKeyInfo)),
+		(*C.xmlChar)(unsafe.Pointer(&C.xmlSecDSigNs)))).Certificates
+
+	if dsigCtx.signKey != nil {
+		result.KeyName = getKeyName(dsigCtx.signKey)
+		result.PublicKey, result.Certificate = keyPublicParts(dsigCtx.signKey)
+	}
+	return result
+}
+
+// keyPublicParts returns the public key and the certificate of key, if any.
+func keyPublicParts(key *C.xmlSecKey) (crypto.PublicKey, *x509.Certificate) {
+	var publicKey crypto.PublicKey
+	var cert *x509.Certificate
+
+	var buf *C.uchar
+	if size := C.MY_xmlSecKeyPublicKeyDER(key, &buf); size > 0 {
+		publicKey, _ = x509.ParsePKIXPublicKey(C.GoBytes(unsafe.Pointer(buf), size))
+		C.MY_OPENSSL_free(unsafe.Pointer(buf))
+	}
+	if size := C.MY_xmlSecKeyCertDER(key, &buf); size > 0 {
+		cert, _ = x509.ParseCertificate(C.GoBytes(unsafe.Pointer(buf), size))
+		C.MY_OPENSSL_free(unsafe.Pointer(buf))
+	}
+	if publicKey == nil && cert != nil {
+		publicKey = cert.PublicKey
+	}
+	return publicKey, cert
+}
+
+// xpointerIDPattern matches the XPointer forms of same-document references
+// that xmlsec understands, such as #xpointer(id('foo')).
+var xpointerIDPattern = regexp.MustCompile(`^#xpointer\(id\(['"]([^'"]*)['"]\)\)$`)
+
+// resolveReference returns the ID and the name of the element of doc that
+// the same-document reference uri refers to.
+func resolveReference(doc *C.xmlDoc, uri string) (string, xml.Name) {
+	var id string
+	var node *C.xmlNode
+	switch {
+	case uri == "" || uri == "#xpointer(/)":
+		node = C.xmlDocGetRootElement(doc)
+	case xpointerIDPattern.MatchString(uri):
+		id = xpointerIDPattern.FindStringSubmatch(uri)[1]
+	case len(uri) > 1 && uri[0] == '#':
+		id = uri[1:]
+	default:
+		return "", xml.Name{}
+	}
+
+	if id != "" {
+		cID := C.CString(id)
+		defer C.free(unsafe.Pointer(cID))
+		if attr := C.xmlGetID(doc, (*C.xmlChar)(unsafe.Pointer(cID))); attr != nil {
+			node = attr.parent
+		}
+	}
+	if node == nil {
+		return id, xml.Name{}
+	}
+
+	name := xml.Name{Local: C.GoString((*C.char)(unsafe.Pointer(node.name)))}
+	if node.ns != nil {
+		name.Space = C.GoString((*C.char)(unsafe.Pointer(node.ns.href)))
+	}
+	return id, name
+}
+
+// firstDSigChild returns the first child element of node in the XMLDSIG
+// namespace named name, or nil.
+func firstDSigChild(node *C.xmlNode, name *C.xmlChar) *C.xmlNode {
+	if node == nil {
+		return nil
+	}
+	return nextDSigElement(C.xmlSecGetNextElementNode(node.children), name)
+}
+
+// nextDSigElement returns node or the first of its following siblings that
+// is an element in the XMLDSIG namespace named name, or nil.
+func nextDSigElement(node *C.xmlNode, name *C.xmlChar) *C.xmlNode {
+	for ; node != nil; node = C.xmlSecGetNextElementNode(node.next) {
+		if C.xmlSecCheckNodeName(node, name, (*C.xmlChar)(unsafe.Pointer(&C.xmlSecDSigNs))) == 1 {
+			return node
+		}
+	}
+	return nil
+}
+
+// transformHref returns the URI of the algorithm implemented by transform.
+func transformHref(transform *C.xmlSecTransform) string {
+	if transform.id == nil || transform.id.href == nil {
+		return ""
+	}
+	return C.GoString((*C.char)(unsafe.Pointer(transform.id.href)))
+}
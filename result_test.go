@@ -0,0 +1,89 @@
+package xmlsec
+
+import (
+	"crypto/rsa"
+	"encoding/xml"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type ResultTest struct {
+	DSig   XMLDSigTest
+	PKCS12 PKCS12Test
+}
+
+var _ = Suite(&ResultTest{})
+
+func (testSuite *ResultTest) SetUpTest(c *C) {
+	testSuite.DSig.SetUpTest(c)
+	testSuite.PKCS12.SetUpTest(c)
+}
+
+func (testSuite *ResultTest) TestVerifyDetailed(c *C) {
+	signed, err := Sign(testSuite.DSig.Key, testSuite.DSig.DocStr, SignatureOptions{})
+	c.Assert(err, IsNil)
+
+	result, err := VerifyDetailed(testSuite.DSig.Cert, signed, SignatureOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(result.SignatureMethod, Equals, "http://www.w3.org/2000/09/xmldsig#rsa-sha1")
+	c.Assert(result.CanonicalizationMethod, Equals, "http://www.w3.org/TR/2001/REC-xml-c14n-20010315")
+	c.Assert(result.References, DeepEquals, []ReferenceResult{{
+		URI:          "",
+		Element:      xml.Name{Space: "urn:envelope", Local: "Envelope"},
+		Transforms:   []string{"http://www.w3.org/2000/09/xmldsig#enveloped-signature"},
+		DigestMethod: "http://www.w3.org/2000/09/xmldsig#sha1",
+		Status:       ReferenceValid,
+	}})
+	c.Assert(result.Certificate, NotNil)
+	c.Assert(result.Certificate.Subject.CommonName, Equals, "Aleksey Sanin")
+	c.Assert(result.PublicKey, FitsTypeOf, &rsa.PublicKey{})
+	c.Assert(result.PublicKey.(*rsa.PublicKey).N.Cmp(result.Certificate.PublicKey.(*rsa.PublicKey).N), Equals, 0)
+}
+
+func (testSuite *ResultTest) TestVerifyDetailedFailure(c *C) {
+	signed, err := Sign(testSuite.DSig.Key, testSuite.DSig.DocStr, SignatureOptions{})
+	c.Assert(err, IsNil)
+	signed = []byte(strings.Replace(string(signed), "Hello", "Goodbye", 1))
+
+	result, err := VerifyDetailed(testSuite.DSig.Cert, signed, SignatureOptions{})
+	c.Assert(err, Equals, ErrVerificationFailed)
+	c.Assert(result, NotNil)
+	c.Assert(len(result.References), Equals, 1)
+	c.Assert(result.References[0].Status, Equals, ReferenceInvalid)
+}
+
+func (testSuite *ResultTest) TestVerifyDetailedReferencedElement(c *C) {
+	signed, err := Sign(testSuite.DSig.Key, []byte(nestedSignaturesDocStr), SignatureOptions{
+		XMLID:      nestedSignaturesXMLID,
+		TemplateID: "response-signature",
+	})
+	c.Assert(err, IsNil)
+
+	result, err := VerifyDetailed(testSuite.DSig.Cert, signed, SignatureOptions{XMLID: nestedSignaturesXMLID})
+	c.Assert(err, IsNil)
+	c.Assert(len(result.References), Equals, 1)
+	c.Assert(result.References[0].URI, Equals, "#response-id")
+	c.Assert(result.References[0].ID, Equals, "response-id")
+	c.Assert(result.References[0].Element, Equals, xml.Name{
+		Space: "urn:oasis:names:tc:SAML:2.0:protocol",
+		Local: "Response",
+	})
+	c.Assert(result.References[0].Transforms, DeepEquals, []string{
+		"http://www.w3.org/2000/09/xmldsig#enveloped-signature",
+		"http://www.w3.org/2001/10/xml-exc-c14n#",
+	})
+	c.Assert(result.References[0].Status, Equals, ReferenceValid)
+}
+
+func (testSuite *ResultTest) TestVerifyTrustedDetailed(c *C) {
+	signed, err := SignPKCS12(testSuite.PKCS12.Bundle, testSuite.PKCS12.DocStr,
+		SignatureOptions{Password: "hunter2"})
+	c.Assert(err, IsNil)
+
+	result, err := VerifyTrustedDetailed([][]byte{testSuite.PKCS12.RootCert}, signed, SignatureOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(result.SignatureMethod, Equals, "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256")
+	c.Assert(result.Certificate, NotNil)
+	c.Assert(result.Certificate.Subject.CommonName, Equals, "go-xmlsec test signer")
+}
@@ -1,6 +1,10 @@
 package xmlsec
 
 import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
 	"encoding/xml"
@@ -11,6 +15,15 @@ type Method struct {
 	Algorithm string `xml:",attr"`
 }
 
+// SignatureMethod is the SignatureMethod of a Signature.
+type SignatureMethod struct {
+	Algorithm string `xml:",attr"`
+
+	// RSAPSSParams holds the parameters of an RSASSA-PSS signature method,
+	// as set by DefaultPSSSignature. It must be nil for other algorithms.
+	RSAPSSParams *RSAPSSParams `xml:"http://www.w3.org/2007/05/xmldsig-more# RSAPSSParams,omitempty"`
+}
+
 // Signature is a model for the Signature object specified by XMLDSIG. This is
 // convenience object when constructing XML that you'd like to sign. For example:
 //
@@ -28,32 +41,71 @@ type Signature struct {
 	XMLName xml.Name `xml:"http://www.w3.org/2000/09/xmldsig# Signature"`
 
 	CanonicalizationMethod Method             `xml:"SignedInfo>CanonicalizationMethod"`
-	SignatureMethod        Method             `xml:"SignedInfo>SignatureMethod"`
+	SignatureMethod        SignatureMethod    `xml:"SignedInfo>SignatureMethod"`
 	ReferenceTransforms    []Method           `xml:"SignedInfo>Reference>Transforms>Transform"`
 	DigestMethod           Method             `xml:"SignedInfo>Reference>DigestMethod"`
 	DigestValue            string             `xml:"SignedInfo>Reference>DigestValue"`
 	SignatureValue         string             `xml:"SignatureValue"`
 	KeyName                string             `xml:"KeyInfo>KeyName,omitempty"`
+	KeyValue               *SignatureKeyValue `xml:"KeyInfo>KeyValue,omitempty"`
 	X509Certificate        *SignatureX509Data `xml:"KeyInfo>X509Data,omitempty"`
 }
 
+// SignatureKeyValue represents the <KeyValue> element of <Signature>. Sign
+// fills it in with the RSAKeyValue or ECKeyValue of the signing key.
+type SignatureKeyValue struct{}
+
 // SignatureX509Data represents the <X509Data> element of <Signature>
 type SignatureX509Data struct {
 	X509Certificate string `xml:"X509Certificate,omitempty"`
 }
 
 // DefaultSignature returns a Signature struct that uses the default c14n and SHA1 settings.
+// If the certificate has an ECDSA key, the signature uses ECDSA and the SHA-2 hash
+// matching the size of the curve instead, and if it has an Ed25519 key, Ed25519
+// and SHA-256.
 func DefaultSignature(pemEncodedPublicKey []byte) Signature {
 	// xmlsec wants the key to be base64-encoded but *not* wrapped with the
 	// PEM flags
 	pemBlock, _ := pem.Decode(pemEncodedPublicKey)
 	certStr := base64.StdEncoding.EncodeToString(pemBlock.Bytes)
 
+	if cert, err := x509.ParseCertificate(pemBlock.Bytes); err == nil {
+		var signatureMethod, digestMethod string
+		switch publicKey := cert.PublicKey.(type) {
+		case *ecdsa.PublicKey:
+			signatureMethod, digestMethod = ecdsaSignatureMethod(publicKey.Curve)
+		case ed25519.PublicKey:
+			signatureMethod = "http://www.w3.org/2021/04/xmldsig-more#eddsa-ed25519"
+			digestMethod = "http://www.w3.org/2001/04/xmlenc#sha256"
+		}
+		if signatureMethod != "" {
+			return Signature{
+				CanonicalizationMethod: Method{
+					Algorithm: "http://www.w3.org/2001/10/xml-exc-c14n#",
+				},
+				SignatureMethod: SignatureMethod{
+					Algorithm: signatureMethod,
+				},
+				ReferenceTransforms: []Method{
+					Method{Algorithm: "http://www.w3.org/2000/09/xmldsig#enveloped-signature"},
+					Method{Algorithm: "http://www.w3.org/2001/10/xml-exc-c14n#"},
+				},
+				DigestMethod: Method{
+					Algorithm: digestMethod,
+				},
+				X509Certificate: &SignatureX509Data{
+					X509Certificate: certStr,
+				},
+			}
+		}
+	}
+
 	return Signature{
 		CanonicalizationMethod: Method{
 			Algorithm: "http://www.w3.org/TR/2001/REC-xml-c14n-20010315",
 		},
-		SignatureMethod: Method{
+		SignatureMethod: SignatureMethod{
 			Algorithm: "http://www.w3.org/2000/09/xmldsig#rsa-sha1",
 		},
 		ReferenceTransforms: []Method{
@@ -67,3 +119,44 @@ func DefaultSignature(pemEncodedPublicKey []byte) Signature {
 		},
 	}
 }
+
+// DefaultPSSSignature returns a Signature struct that uses exclusive c14n,
+// SHA-256 digests and RSASSA-PSS with SHA-256 (RFC 9231). The MGF1 digest and
+// the salt length are written explicitly in the SignatureMethod.
+func DefaultPSSSignature(pemEncodedPublicKey []byte) Signature {
+	signature := DefaultSignature(pemEncodedPublicKey)
+	signature.CanonicalizationMethod = Method{
+		Algorithm: "http://www.w3.org/2001/10/xml-exc-c14n#",
+	}
+	signature.SignatureMethod = SignatureMethod{
+		Algorithm: "http://www.w3.org/2007/05/xmldsig-more#sha256-rsa-MGF1",
+		RSAPSSParams: &RSAPSSParams{
+			MaskGenerationFunction: &MaskGenerationFunction{
+				Algorithm:    mgf1Method,
+				DigestMethod: &Method{Algorithm: "http://www.w3.org/2001/04/xmlenc#sha256"},
+			},
+			SaltLength: 32,
+		},
+	}
+	signature.ReferenceTransforms = []Method{
+		Method{Algorithm: "http://www.w3.org/2000/09/xmldsig#enveloped-signature"},
+		Method{Algorithm: "http://www.w3.org/2001/10/xml-exc-c14n#"},
+	}
+	signature.DigestMethod = Method{
+		Algorithm: "http://www.w3.org/2001/04/xmlenc#sha256",
+	}
+	return signature
+}
+
+// ecdsaSignatureMethod returns the ECDSA signature method and the digest
+// method whose strength matches curve.
+func ecdsaSignatureMethod(curve elliptic.Curve) (signatureMethod string, digestMethod string) {
+	switch size := curve.Params().BitSize; {
+	case size > 384:
+		return "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha512", "http://www.w3.org/2001/04/xmlenc#sha512"
+	case size > 256:
+		return "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha384", "http://www.w3.org/2001/04/xmldsig-more#sha384"
+	default:
+		return "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256", "http://www.w3.org/2001/04/xmlenc#sha256"
+	}
+}
@@ -0,0 +1,229 @@
+package xmlsec
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha1" // register hash functions used by signatureMethodHashes
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+// #include <xmlsec/xmlsec.h>
+// #include <xmlsec/xmltree.h>
+// #include <xmlsec/xmldsig.h>
+// #include <xmlsec/base64.h>
+// #include <xmlsec/buffer.h>
+// #include <xmlsec/crypto.h>
+import "C"
+
+// #include <stdlib.h>
+// #include <libxml/tree.h>
+import "C"
+
+// signatureMethodHashes maps the signature methods that SignWithSigner
+// supports to the hash function that they use. EdDSA signs SignedInfo
+// itself rather than a hash of it.
+var signatureMethodHashes = map[string]crypto.Hash{
+	"http://www.w3.org/2000/09/xmldsig#rsa-sha1":           crypto.SHA1,
+	"http://www.w3.org/2001/04/xmldsig-more#rsa-sha224":    crypto.SHA224,
+	"http://www.w3.org/2001/04/xmldsig-more#rsa-sha256":    crypto.SHA256,
+	"http://www.w3.org/2001/04/xmldsig-more#rsa-sha384":    crypto.SHA384,
+	"http://www.w3.org/2001/04/xmldsig-more#rsa-sha512":    crypto.SHA512,
+	"http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha1":    crypto.SHA1,
+	"http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha224":  crypto.SHA224,
+	"http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256":  crypto.SHA256,
+	"http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha384":  crypto.SHA384,
+	"http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha512":  crypto.SHA512,
+	"http://www.w3.org/2021/04/xmldsig-more#eddsa-ed25519": crypto.Hash(0),
+}
+
+// SignWithSigner returns a version of doc signed according to the XMLDSIG
+// standard, like Sign. Rather than using a private key directly, xmlsec
+// computes the reference digests and the canonical SignedInfo element, and
+// the signature over SignedInfo is computed by signer. This allows signing
+// with keys that are held in an HSM, a cloud KMS or a signing agent.
+//
+// signer must hold an RSA, ECDSA or Ed25519 key that matches the SignatureMethod of
+// the template in doc. For RSASSA-PSS signature methods, signer is passed
+// *rsa.PSSOptions, and the MGF1 digest must be the same as the signature
+// digest. If cert is not nil, it is written to an X509Data element in the
+// signature's KeyInfo, followed by opts.Certificates. cert may also be nil,
+// or the same as the first of opts.Certificates.
+func SignWithSigner(signer crypto.Signer, cert *x509.Certificate, doc []byte, opts SignatureOptions) ([]byte, error) {
+	placeholder, err := placeholderKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	startProcessingXML()
+	defer stopProcessingXML()
+
+	signKey, err := loadPrivateKey(placeholder, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if cert != nil && (len(opts.Certificates) == 0 || !cert.Equal(opts.Certificates[0])) {
+		opts.Certificates = append([]*x509.Certificate{cert}, opts.Certificates...)
+	}
+
+	return signWithKey(signKey, doc, opts, signer)
+}
+
+// signerOpts returns the options that make a crypto.Signer compute the
+// signature of the signature method transform signMethod.
+func signerOpts(signMethod *C.xmlSecTransform) (crypto.SignerOpts, error) {
+	pssOpts, err := pssSignerOpts(signMethod)
+	if err != nil {
+		return nil, err
+	}
+	if pssOpts != nil {
+		return pssOpts, nil
+	}
+
+	signatureMethod := C.GoString((*C.char)(unsafe.Pointer(signMethod.id.href)))
+	hash, ok := signatureMethodHashes[signatureMethod]
+	if !ok {
+		return nil, fmt.Errorf("signature method %s is not supported by SignWithSigner", signatureMethod)
+	}
+	return hash, nil
+}
+
+// signWithSigner returns the XMLDSIG signature value of signedInfo computed
+// by signer.
+func signWithSigner(signer crypto.Signer, signedInfo []byte, opts crypto.SignerOpts) ([]byte, error) {
+	message := signedInfo
+	if hash := opts.HashFunc(); hash != 0 {
+		h := hash.New()
+		h.Write(signedInfo)
+		message = h.Sum(nil)
+	}
+	signature, err := signer.Sign(rand.Reader, message, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// crypto.Signer returns ASN.1 encoded ECDSA signatures but XMLDSIG
+	// wants the concatenation of r and s (RFC 4050, section 3.3)
+	if pub, ok := signer.Public().(*ecdsa.PublicKey); ok {
+		var sig struct {
+			R, S *big.Int
+		}
+		if _, err := asn1.Unmarshal(signature, &sig); err != nil {
+			return nil, err
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		signature = make([]byte, 2*size)
+		sig.R.FillBytes(signature[:size])
+		sig.S.FillBytes(signature[size:])
+	}
+
+	return signature, nil
+}
+
+// replaceSignatureValue replaces the SignatureValue that xmlsec computed
+// in ctx with one computed by signer. ctx must have been signed with the
+// XMLSEC_DSIG_FLAGS_STORE_SIGNATURE flag set.
+func replaceSignatureValue(ctx *C.xmlSecDSigCtx, signer crypto.Signer) error {
+	buf := C.xmlSecDSigCtxGetPreSignBuffer(ctx)
+	if buf == nil || ctx.signMethod == nil || ctx.signValueNode == nil {
+		return errors.New("cannot find signed info")
+	}
+	signedInfo := C.GoBytes(unsafe.Pointer(C.xmlSecBufferGetData(buf)),
+		C.int(C.xmlSecBufferGetSize(buf)))
+	opts, err := signerOpts(ctx.signMethod)
+	if err != nil {
+		return err
+	}
+
+	signature, err := signWithSigner(signer, signedInfo, opts)
+	if err != nil {
+		return err
+	}
+
+	content := C.CString(formatBase64(signature))
+	defer C.free(unsafe.Pointer(content))
+	C.xmlNodeSetContent(ctx.signValueNode, (*C.xmlChar)(unsafe.Pointer(content)))
+	return nil
+}
+
+// formatBase64 returns the base64 encoding of buf wrapped the same way
+// xmlsec wraps the values it writes.
+func formatBase64(buf []byte) string {
+	encoded := base64.StdEncoding.EncodeToString(buf)
+	lineSize := int(C.xmlSecBase64GetDefaultLineSize())
+	if lineSize <= 0 {
+		return encoded
+	}
+	lines := []string{}
+	for len(encoded) > lineSize {
+		lines = append(lines, encoded[:lineSize])
+		encoded = encoded[lineSize:]
+	}
+	lines = append(lines, encoded)
+	return strings.Join(lines, "\n")
+}
+
+// placeholderKeys holds the PKCS#8 encoded keys returned by placeholderKey.
+var placeholderKeys = struct {
+	sync.Mutex
+	keys map[string][]byte
+}{keys: map[string][]byte{}}
+
+// placeholderKey returns a private key of the same type as pub. xmlsec needs
+// a key of the right type in order to produce the signature template, but
+// the signature value it computes with the key is discarded.
+func placeholderKey(pub crypto.PublicKey) ([]byte, error) {
+	var keyType string
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		keyType = "rsa"
+	case *ecdsa.PublicKey:
+		keyType = "ecdsa"
+	case ed25519.PublicKey:
+		keyType = "ed25519"
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+
+	placeholderKeys.Lock()
+	defer placeholderKeys.Unlock()
+	if key, ok := placeholderKeys.keys[keyType]; ok {
+		return key, nil
+	}
+
+	var key crypto.Signer
+	var err error
+	switch keyType {
+	case "rsa":
+		// large enough for RSASSA-PSS with SHA-512 and the default salt
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ecdsa":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ed25519":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	placeholderKeys.keys[keyType] = buf
+	return buf, nil
+}
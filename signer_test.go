@@ -0,0 +1,127 @@
+package xmlsec
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+// countingSigner is a crypto.Signer that records how many times it is used,
+// standing in for a key held in an HSM or KMS.
+type countingSigner struct {
+	Signer crypto.Signer
+	Count  int
+}
+
+func (s *countingSigner) Public() crypto.PublicKey {
+	return s.Signer.Public()
+}
+
+func (s *countingSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.Count++
+	return s.Signer.Sign(rand, digest, opts)
+}
+
+type SignerTest struct {
+	DSig XMLDSigTest
+}
+
+var _ = Suite(&SignerTest{})
+
+func (testSuite *SignerTest) SetUpTest(c *C) {
+	testSuite.DSig.SetUpTest(c)
+}
+
+func (testSuite *SignerTest) TestSignWithRSASigner(c *C) {
+	// XMLDSigTest.Key is too small for crypto/rsa, which rejects keys of
+	// less than 1024 bits
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, IsNil)
+	keyPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+
+	signer := &countingSigner{Signer: key}
+	actualSignedString, err := SignWithSigner(signer, nil, testSuite.DSig.DocStr, SignatureOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(signer.Count, Equals, 1)
+
+	// PKCS#1 v1.5 signatures are deterministic, so the result must be
+	// identical to signing with the key directly.
+	expectedSignedString, err := Sign(keyPEM, testSuite.DSig.DocStr, SignatureOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(string(actualSignedString), Equals, string(expectedSignedString))
+
+	err = VerifyWithPublicKey(key.Public(), actualSignedString, SignatureOptions{})
+	c.Assert(err, IsNil)
+}
+
+func (testSuite *SignerTest) TestSignWithECDSASigner(c *C) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	c.Assert(err, IsNil)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "signer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key)
+	c.Assert(err, IsNil)
+	cert, err := x509.ParseCertificate(certDER)
+	c.Assert(err, IsNil)
+
+	docStr := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Envelope xmlns="urn:envelope">
+  <Data>Hello, World!</Data>
+  <Signature xmlns="http://www.w3.org/2000/09/xmldsig#">
+    <SignedInfo>
+      <CanonicalizationMethod Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#"/>
+      <SignatureMethod Algorithm="http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha384"/>
+      <Reference URI="">
+        <Transforms>
+          <Transform Algorithm="http://www.w3.org/2000/09/xmldsig#enveloped-signature"/>
+        </Transforms>
+        <DigestMethod Algorithm="http://www.w3.org/2001/04/xmlenc#sha256"/>
+        <DigestValue></DigestValue>
+      </Reference>
+    </SignedInfo>
+    <SignatureValue></SignatureValue>
+  </Signature>
+</Envelope>
+`)
+
+	signer := &countingSigner{Signer: key}
+	signedDoc, err := SignWithSigner(signer, cert, docStr, SignatureOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(signer.Count, Equals, 1)
+	c.Assert(strings.Count(string(signedDoc), "<X509Certificate>"), Equals, 1)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	err = Verify(certPEM, signedDoc, SignatureOptions{})
+	c.Assert(err, IsNil)
+
+	signedDoc = []byte(strings.Replace(string(signedDoc), "Hello", "Goodbye", 1))
+	err = Verify(certPEM, signedDoc, SignatureOptions{})
+	c.Assert(err, Equals, ErrVerificationFailed)
+}
+
+func (testSuite *SignerTest) TestSignerKeyMismatch(c *C) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+
+	// the template requires an RSA key
+	_, err = SignWithSigner(key, nil, testSuite.DSig.DocStr, SignatureOptions{})
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,325 @@
+package xmlsec
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+)
+
+// #include <stdlib.h>
+// #include <xmlsec/xmlsec.h>
+// #include <xmlsec/xmltree.h>
+// #include <xmlsec/transforms.h>
+// #include <xmlsec/templates.h>
+import "C"
+
+// #include <libxml/tree.h>
+import "C"
+
+// SignatureTemplate describes a Signature element that InsertSignatureTemplate
+// adds to a document, so that it can then be signed with Sign. Algorithms are
+// identified by their URIs, for example
+// "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256".
+type SignatureTemplate struct {
+	// ID is the value of the Id attribute of the Signature element, if any.
+	ID string
+
+	// Prefix is the namespace prefix used for the XMLDSIG elements, for
+	// example "ds". If empty the XMLDSIG namespace is the default namespace
+	// of the Signature element.
+	Prefix string
+
+	// CanonicalizationMethod is the algorithm used to canonicalize the
+	// SignedInfo element. If empty, exclusive canonicalization
+	// (http://www.w3.org/2001/10/xml-exc-c14n#) is used.
+	CanonicalizationMethod string
+
+	// SignatureMethod is the algorithm used to compute the SignatureValue.
+	SignatureMethod string
+
+	// HMACOutputLength, if not zero, is the number of bits that an HMAC
+	// SignatureMethod is truncated to.
+	HMACOutputLength int
+
+	// RSAPSSParams, if not nil, is written as the parameters of an
+	// RSASSA-PSS SignatureMethod.
+	RSAPSSParams *RSAPSSParams
+
+	// References lists the Reference elements of SignedInfo. There must be
+	// at least one.
+	References []ReferenceTemplate
+
+	// KeyInfo describes the contents of the KeyInfo element. If nil, the
+	// signature does not have a KeyInfo element.
+	KeyInfo *KeyInfoTemplate
+}
+
+// ReferenceTemplate describes a Reference element of a SignatureTemplate.
+type ReferenceTemplate struct {
+	// ID is the value of the Id attribute of the Reference element, if any.
+	ID string
+
+	// URI identifies the data that is signed, for example "" for the whole
+	// document or "#foo" for the element with ID foo.
+	URI string
+
+	// Type is the value of the Type attribute of the Reference element, if any.
+	Type string
+
+	// DigestMethod is the algorithm used to compute the DigestValue.
+	DigestMethod string
+
+	// Transforms lists the transforms applied to the referenced data
+	// before it is digested, in order.
+	Transforms []TransformTemplate
+}
+
+// TransformTemplate describes a Transform element of a ReferenceTemplate.
+type TransformTemplate struct {
+	// Algorithm identifies the transform, for example
+	// "http://www.w3.org/2000/09/xmldsig#enveloped-signature".
+	Algorithm string
+
+	// InclusiveNamespaces is the PrefixList of the InclusiveNamespaces
+	// element of an exclusive canonicalization transform. It is ignored if
+	// empty.
+	InclusiveNamespaces string
+}
+
+// KeyInfoTemplate describes the KeyInfo element of a SignatureTemplate. Sign
+// fills in the elements of KeyInfo from the signing key.
+type KeyInfoTemplate struct {
+	// ID is the value of the Id attribute of the KeyInfo element, if any.
+	ID string
+
+	// KeyName, if not empty, adds a KeyName element with this value.
+	KeyName string
+
+	// KeyValue adds a KeyValue element containing the public key.
+	KeyValue bool
+
+	// X509Data adds an X509Data element containing the certificate of the
+	// signing key.
+	X509Data bool
+}
+
+// TemplateLocation specifies where InsertSignatureTemplate places the
+// Signature element.
+type TemplateLocation struct {
+	// ParentID is the ID of the element that the Signature element is added
+	// to. If empty, it is added to the root element.
+	ParentID string
+
+	// After is the local name of a child element of the parent after which
+	// the Signature element is inserted, for example "Issuer" for a SAML
+	// assertion. If empty, the Signature element is added as the last child
+	// of the parent.
+	After string
+}
+
+// excC14NMethod is the URI of exclusive XML canonicalization.
+const excC14NMethod = "http://www.w3.org/2001/10/xml-exc-c14n#"
+
+// InsertSignatureTemplate returns a copy of doc in which the Signature element
+// described by tmpl has been inserted at loc. The resulting document can be
+// passed to Sign. The XMLID field of opts is used to find elements by ID.
+func InsertSignatureTemplate(doc []byte, tmpl SignatureTemplate, loc TemplateLocation, opts SignatureOptions) ([]byte, error) {
+	if len(tmpl.References) == 0 {
+		return nil, errors.New("signature template must contain at least one reference")
+	}
+
+	startProcessingXML()
+	defer stopProcessingXML()
+
+	parsedDoc, err := newDoc(doc, opts.XMLID, opts.Parser)
+	if err != nil {
+		return nil, err
+	}
+	defer closeDoc(parsedDoc)
+
+	parentNode := C.xmlDocGetRootElement(parsedDoc)
+	if loc.ParentID != "" {
+		parentNode = findElementByID(parsedDoc, loc.ParentID)
+		if parentNode == nil {
+			return nil, fmt.Errorf("cannot find element with ID %q", loc.ParentID)
+		}
+	}
+
+	var afterNode *C.xmlNode
+	if loc.After != "" {
+		for cur := C.xmlSecGetNextElementNode(parentNode.children); cur != nil; cur = C.xmlSecGetNextElementNode(cur.next) {
+			if C.GoString((*C.char)(unsafe.Pointer(cur.name))) == loc.After {
+				afterNode = cur
+				break
+			}
+		}
+		if afterNode == nil {
+			return nil, fmt.Errorf("cannot find element %s to insert the signature after", loc.After)
+		}
+	}
+
+	signatureNode, err := createSignatureTemplate(parsedDoc, tmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	if afterNode != nil {
+		C.xmlAddNextSibling(afterNode, signatureNode)
+	} else {
+		C.xmlAddChild(parentNode, signatureNode)
+	}
+
+	return dumpDoc(parsedDoc), nil
+}
+
+// createSignatureTemplate returns a new Signature element for doc described
+// by tmpl. The caller owns the returned node.
+func createSignatureTemplate(doc *C.xmlDoc, tmpl SignatureTemplate) (*C.xmlNode, error) {
+	c14nMethod := tmpl.CanonicalizationMethod
+	if c14nMethod == "" {
+		c14nMethod = excC14NMethod
+	}
+	c14nMethodID := findTransformID(c14nMethod, C.xmlSecTransformUsageC14NMethod)
+	if c14nMethodID == nil {
+		return nil, fmt.Errorf("unsupported canonicalization method %q", c14nMethod)
+	}
+	signMethodID := findTransformID(tmpl.SignatureMethod, C.xmlSecTransformUsageSignatureMethod)
+	if signMethodID == nil {
+		return nil, fmt.Errorf("unsupported signature method %q", tmpl.SignatureMethod)
+	}
+
+	id := newXMLChar(tmpl.ID)
+	defer C.free(unsafe.Pointer(id))
+	prefix := newXMLChar(tmpl.Prefix)
+	defer C.free(unsafe.Pointer(prefix))
+
+	signatureNode := C.xmlSecTmplSignatureCreateNsPref(doc, c14nMethodID, signMethodID, id, prefix)
+	if signatureNode == nil {
+		return nil, mustPopError()
+	}
+
+	if tmpl.HMACOutputLength != 0 {
+		if rv := C.xmlSecTmplTransformAddHmacOutputLength(
+			C.xmlSecTmplSignatureGetSignMethodNode(signatureNode),
+			C.xmlSecSize(tmpl.HMACOutputLength)); rv < 0 {
+			C.xmlFreeNode(signatureNode)
+			return nil, mustPopError()
+		}
+	}
+
+	if tmpl.RSAPSSParams != nil {
+		if err := addRSAPSSParams(C.xmlSecTmplSignatureGetSignMethodNode(signatureNode),
+			*tmpl.RSAPSSParams); err != nil {
+			C.xmlFreeNode(signatureNode)
+			return nil, err
+		}
+	}
+
+	if err := addReferenceTemplates(signatureNode, tmpl.References); err != nil {
+		C.xmlFreeNode(signatureNode)
+		return nil, err
+	}
+
+	if tmpl.KeyInfo != nil {
+		if err := addKeyInfoTemplate(signatureNode, *tmpl.KeyInfo); err != nil {
+			C.xmlFreeNode(signatureNode)
+			return nil, err
+		}
+	}
+
+	return signatureNode, nil
+}
+
+func addReferenceTemplates(signatureNode *C.xmlNode, references []ReferenceTemplate) error {
+	for _, reference := range references {
+		digestMethodID := findTransformID(reference.DigestMethod, C.xmlSecTransformUsageDigestMethod)
+		if digestMethodID == nil {
+			return fmt.Errorf("unsupported digest method %q", reference.DigestMethod)
+		}
+
+		id := newXMLChar(reference.ID)
+		uri := C.CString(reference.URI)
+		referenceType := newXMLChar(reference.Type)
+		referenceNode := C.xmlSecTmplSignatureAddReference(signatureNode, digestMethodID,
+			id, (*C.xmlChar)(unsafe.Pointer(uri)), referenceType)
+		C.free(unsafe.Pointer(id))
+		C.free(unsafe.Pointer(uri))
+		C.free(unsafe.Pointer(referenceType))
+		if referenceNode == nil {
+			return mustPopError()
+		}
+
+		for _, transform := range reference.Transforms {
+			transformID := findTransformID(transform.Algorithm, C.xmlSecTransformUsageDSigTransform)
+			if transformID == nil {
+				return fmt.Errorf("unsupported transform %q", transform.Algorithm)
+			}
+			transformNode := C.xmlSecTmplReferenceAddTransform(referenceNode, transformID)
+			if transformNode == nil {
+				return mustPopError()
+			}
+
+			if transform.InclusiveNamespaces != "" {
+				prefixList := C.CString(transform.InclusiveNamespaces)
+				rv := C.xmlSecTmplTransformAddC14NInclNamespaces(transformNode,
+					(*C.xmlChar)(unsafe.Pointer(prefixList)))
+				C.free(unsafe.Pointer(prefixList))
+				if rv < 0 {
+					return mustPopError()
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func addKeyInfoTemplate(signatureNode *C.xmlNode, keyInfo KeyInfoTemplate) error {
+	id := newXMLChar(keyInfo.ID)
+	defer C.free(unsafe.Pointer(id))
+
+	keyInfoNode := C.xmlSecTmplSignatureEnsureKeyInfo(signatureNode, id)
+	if keyInfoNode == nil {
+		return mustPopError()
+	}
+
+	if keyInfo.KeyName != "" {
+		name := C.CString(keyInfo.KeyName)
+		defer C.free(unsafe.Pointer(name))
+		if C.xmlSecTmplKeyInfoAddKeyName(keyInfoNode, (*C.xmlChar)(unsafe.Pointer(name))) == nil {
+			return mustPopError()
+		}
+	}
+	if keyInfo.KeyValue {
+		if C.xmlSecTmplKeyInfoAddKeyValue(keyInfoNode) == nil {
+			return mustPopError()
+		}
+	}
+	if keyInfo.X509Data {
+		if C.xmlSecTmplKeyInfoAddX509Data(keyInfoNode) == nil {
+			return mustPopError()
+		}
+	}
+	return nil
+}
+
+// findTransformID returns the xmlsec transform that implements the algorithm
+// identified by href for usage, or nil if there is no such transform.
+func findTransformID(href string, usage C.xmlSecTransformUsage) C.xmlSecTransformId {
+	if href == "" {
+		return nil
+	}
+	cHref := C.CString(href)
+	defer C.free(unsafe.Pointer(cHref))
+	return C.xmlSecTransformIdListFindByHref(C.xmlSecTransformIdsGet(),
+		(*C.xmlChar)(unsafe.Pointer(cHref)), usage)
+}
+
+// newXMLChar returns s as a C string allocated with malloc, or nil if s is
+// empty, for the optional arguments of the xmlsec template functions. The
+// caller must free the result.
+func newXMLChar(s string) *C.xmlChar {
+	if s == "" {
+		return nil
+	}
+	return (*C.xmlChar)(unsafe.Pointer(C.CString(s)))
+}
@@ -0,0 +1,161 @@
+package xmlsec
+
+import (
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type TemplateTest struct {
+	DSig XMLDSigTest
+}
+
+var _ = Suite(&TemplateTest{})
+
+func (testSuite *TemplateTest) SetUpTest(c *C) {
+	testSuite.DSig.SetUpTest(c)
+}
+
+func (testSuite *TemplateTest) TestEnvelopedTemplate(c *C) {
+	doc := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Envelope xmlns="urn:envelope"><Data>Hello, World!</Data></Envelope>
+`)
+	tmpl := SignatureTemplate{
+		CanonicalizationMethod: "http://www.w3.org/TR/2001/REC-xml-c14n-20010315",
+		SignatureMethod:        "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256",
+		References: []ReferenceTemplate{{
+			DigestMethod: "http://www.w3.org/2001/04/xmlenc#sha256",
+			Transforms: []TransformTemplate{
+				{Algorithm: "http://www.w3.org/2000/09/xmldsig#enveloped-signature"},
+			},
+		}},
+		KeyInfo: &KeyInfoTemplate{KeyName: "alice", X509Data: true},
+	}
+
+	docWithTemplate, err := InsertSignatureTemplate(doc, tmpl, TemplateLocation{}, SignatureOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(string(docWithTemplate), Equals, `<?xml version="1.0" encoding="UTF-8"?>
+<Envelope xmlns="urn:envelope"><Data>Hello, World!</Data><Signature xmlns="http://www.w3.org/2000/09/xmldsig#">
+<SignedInfo>
+<CanonicalizationMethod Algorithm="http://www.w3.org/TR/2001/REC-xml-c14n-20010315"/>
+<SignatureMethod Algorithm="http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"/>
+<Reference URI="">
+<Transforms>
+<Transform Algorithm="http://www.w3.org/2000/09/xmldsig#enveloped-signature"/>
+</Transforms>
+<DigestMethod Algorithm="http://www.w3.org/2001/04/xmlenc#sha256"/>
+<DigestValue/>
+</Reference>
+</SignedInfo>
+<SignatureValue/>
+<KeyInfo>
+<KeyName>alice</KeyName>
+<X509Data/>
+</KeyInfo>
+</Signature></Envelope>
+`)
+
+	signed, err := Sign(testSuite.DSig.Key, docWithTemplate, SignatureOptions{})
+	c.Assert(err, IsNil)
+
+	err = Verify(testSuite.DSig.Cert, signed, SignatureOptions{})
+	c.Assert(err, IsNil)
+}
+
+func (testSuite *TemplateTest) TestInsertAfterElement(c *C) {
+	doc := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="response-id"><saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" xmlns:xs="http://www.w3.org/2001/XMLSchema" ID="assertion-id"><saml:Issuer>https://idp.example.com/</saml:Issuer><saml:Subject><saml:NameID>alice</saml:NameID></saml:Subject></saml:Assertion></samlp:Response>
+`)
+	xmlID := []XMLIDOption{{
+		ElementName:      "Assertion",
+		ElementNamespace: "urn:oasis:names:tc:SAML:2.0:assertion",
+		AttributeName:    "ID",
+	}}
+	tmpl := SignatureTemplate{
+		Prefix:          "ds",
+		SignatureMethod: "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256",
+		References: []ReferenceTemplate{{
+			URI:          "#assertion-id",
+			DigestMethod: "http://www.w3.org/2001/04/xmlenc#sha256",
+			Transforms: []TransformTemplate{
+				{Algorithm: "http://www.w3.org/2000/09/xmldsig#enveloped-signature"},
+				{Algorithm: "http://www.w3.org/2001/10/xml-exc-c14n#", InclusiveNamespaces: "xs"},
+			},
+		}},
+	}
+
+	docWithTemplate, err := InsertSignatureTemplate(doc, tmpl, TemplateLocation{
+		ParentID: "assertion-id",
+		After:    "Issuer",
+	}, SignatureOptions{XMLID: xmlID})
+	c.Assert(err, IsNil)
+	c.Assert(string(docWithTemplate), Matches, `(?s).*</saml:Issuer><ds:Signature xmlns:ds="http://www.w3.org/2000/09/xmldsig#">.*`+
+		`<ds:CanonicalizationMethod Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#"/>.*`+
+		`<InclusiveNamespaces xmlns="http://www.w3.org/2001/10/xml-exc-c14n#" PrefixList="xs"/>.*`+
+		`</ds:Signature><saml:Subject>.*`)
+
+	signed, err := Sign(testSuite.DSig.Key, docWithTemplate, SignatureOptions{XMLID: xmlID})
+	c.Assert(err, IsNil)
+
+	err = Verify(testSuite.DSig.Cert, signed, SignatureOptions{XMLID: xmlID})
+	c.Assert(err, IsNil)
+}
+
+func (testSuite *TemplateTest) TestMultipleReferences(c *C) {
+	doc := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Envelope xmlns="urn:envelope"><Data Id="first">Hello</Data><Data Id="second">World</Data></Envelope>
+`)
+	xmlID := []XMLIDOption{{ElementName: "Data", AttributeName: "Id"}}
+	tmpl := SignatureTemplate{
+		SignatureMethod: "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256",
+		References: []ReferenceTemplate{
+			{URI: "#first", DigestMethod: "http://www.w3.org/2001/04/xmlenc#sha256"},
+			{URI: "#second", DigestMethod: "http://www.w3.org/2001/04/xmlenc#sha256"},
+		},
+	}
+
+	docWithTemplate, err := InsertSignatureTemplate(doc, tmpl, TemplateLocation{}, SignatureOptions{XMLID: xmlID})
+	c.Assert(err, IsNil)
+
+	signed, err := Sign(testSuite.DSig.Key, docWithTemplate, SignatureOptions{XMLID: xmlID})
+	c.Assert(err, IsNil)
+
+	err = Verify(testSuite.DSig.Cert, signed, SignatureOptions{XMLID: xmlID})
+	c.Assert(err, IsNil)
+
+	// modifying either of the referenced elements invalidates the signature
+	for _, data := range []string{"Hello", "World"} {
+		tampered := []byte(strings.Replace(string(signed), ">"+data+"<", ">Tampered<", 1))
+		err = Verify(testSuite.DSig.Cert, tampered, SignatureOptions{XMLID: xmlID})
+		c.Assert(err, Equals, ErrVerificationFailed)
+	}
+}
+
+func (testSuite *TemplateTest) TestInvalidTemplate(c *C) {
+	reference := ReferenceTemplate{DigestMethod: "http://www.w3.org/2001/04/xmlenc#sha256"}
+
+	_, err := InsertSignatureTemplate(testSuite.DSig.DocStr, SignatureTemplate{
+		SignatureMethod: "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256",
+	}, TemplateLocation{}, SignatureOptions{})
+	c.Assert(err, ErrorMatches, "signature template must contain at least one reference")
+
+	_, err = InsertSignatureTemplate(testSuite.DSig.DocStr, SignatureTemplate{
+		SignatureMethod: "urn:example:unknown",
+		References:      []ReferenceTemplate{reference},
+	}, TemplateLocation{}, SignatureOptions{})
+	c.Assert(err, ErrorMatches, `unsupported signature method "urn:example:unknown"`)
+
+	_, err = InsertSignatureTemplate(testSuite.DSig.DocStr, SignatureTemplate{
+		SignatureMethod: "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256",
+		References: []ReferenceTemplate{{
+			DigestMethod: "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256",
+		}},
+	}, TemplateLocation{}, SignatureOptions{})
+	c.Assert(err, ErrorMatches, `unsupported digest method "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"`)
+
+	_, err = InsertSignatureTemplate(testSuite.DSig.DocStr, SignatureTemplate{
+		SignatureMethod: "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256",
+		References:      []ReferenceTemplate{reference},
+	}, TemplateLocation{ParentID: "missing"}, SignatureOptions{})
+	c.Assert(err, ErrorMatches, `cannot find element with ID "missing"`)
+}
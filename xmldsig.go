@@ -1,7 +1,13 @@
 package xmlsec
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/x509"
 	"errors"
+	"fmt"
+	"sort"
+	"time"
 	"unsafe"
 )
 
@@ -11,6 +17,10 @@ import (
 // #include <xmlsec/xmldsig.h>
 // #include <xmlsec/errors.h>
 // #include <xmlsec/crypto.h>
+// #include <xmlsec/templates.h>
+//
+// static inline xmlSecKeyDataId MY_xmlSecKeyDataX509Id(void) { return xmlSecKeyDataX509Id; }
+// static inline xmlSecKeyDataId MY_xmlSecKeyDataNameId(void) { return xmlSecKeyDataNameId; }
 import "C"
 
 // SignatureOptions represents additional, less commonly used, options for Sign and
@@ -24,58 +34,427 @@ type SignatureOptions struct {
 	// http://www.w3.org/TR/xml-id/
 	// http://xmlsoft.org/html/libxml-valid.html#xmlAddID
 	XMLID []XMLIDOption
+
+	// Password is used to decrypt the private key passed to Sign if it is
+	// encrypted, for example a PEM encoded `ENCRYPTED PRIVATE KEY`. It is
+	// ignored for unencrypted keys.
+	Password string
+
+	// SignAllTemplates causes Sign to sign every Signature template in the
+	// document rather than only the first one. Templates are signed innermost
+	// first, so that the digests of outer signatures cover the inner ones,
+	// as required for example for a SAML Response that contains a signed
+	// Assertion.
+	SignAllTemplates bool
+
+	// TemplateID selects which Signature template Sign uses, either by the
+	// Id attribute of the Signature element or by the ID of its parent
+	// element. IDs of parent elements are matched using the attributes
+	// declared in XMLID, or else attributes named ID, Id or id.
+	TemplateID string
+
+	// AlgorithmPolicy restricts the algorithms that Verify accepts. If nil,
+	// there is no policy: any algorithm supported by xmlsec is accepted,
+	// including weak ones such as rsa-sha1 and MD5. SecureAlgorithmPolicy
+	// returns a policy suitable for most applications.
+	AlgorithmPolicy *AlgorithmPolicy
+
+	// AllowExternalReferences lets Verify follow the URIs of References,
+	// and of RetrievalMethods in the KeyInfo, that refer outside of the
+	// document, for example to files or http URLs. By default only empty
+	// URIs and fragments such as "#id" are allowed, and Verify returns a
+	// ReferenceNotAllowedError for others, because fetching them on behalf
+	// of an untrusted document is dangerous. Sign is not restricted.
+	AllowExternalReferences bool
+
+	// Certificates lists the certificate of the signing key followed by the
+	// intermediate certificates of its chain. Sign and the related functions
+	// write them to an X509Data element in the signature's KeyInfo, so that
+	// relying parties can build a path to a trusted root without obtaining
+	// the intermediates separately.
+	Certificates []*x509.Certificate
+
+	// X509Data selects the elements that Sign writes to the X509Data element
+	// of the signature's KeyInfo to identify the certificate of the signing
+	// key, in addition to the certificates themselves.
+	X509Data X509DataOptions
+
+	// KeyStore, if not nil, holds the keys and trusted certificates used by
+	// Verify and VerifyTrusted, whose key and certificate arguments must
+	// then be empty. Verify tries the key named by the KeyName element of
+	// the signature first, then each of the keys in the store, and reports
+	// the name of the key that matched in VerificationResult.KeyName.
+	// VerifyTrusted uses only the keys of the certificates in the signature
+	// that the trusted certificates of the store validate.
+	KeyStore *KeyStore
+
+	// KeyResolver, if not nil, is asked for the key of the signature by
+	// Verify and VerifyTrusted, whose key argument may then be empty. It is
+	// ignored by VerifyHMAC.
+	KeyResolver KeyResolver
+
+	// Intermediates lists PEM encoded certificates that VerifyTrusted may
+	// use to build the chain from the certificate of the signature to one of
+	// its trusted certificates, in addition to those in the signature. They
+	// are not themselves trusted.
+	Intermediates [][]byte
+
+	// CRLs lists PEM or DER encoded certificate revocation lists that
+	// VerifyTrusted checks the certificates of the signature against, in
+	// addition to the X509CRL elements of the signature. If a certificate is
+	// revoked, VerifyTrusted returns a CertificateRevokedError.
+	//
+	// xmlsec consults only the first CRL of each issuer, so pass only the
+	// latest one. X509CRL elements must be issued by a trusted certificate,
+	// otherwise the signature is rejected.
+	CRLs [][]byte
+
+	// VerificationTime, if not zero, is the time at which VerifyTrusted
+	// checks that certificates are valid, instead of the current time. It
+	// allows archived documents to be checked as of the time they were
+	// signed, after their certificates have expired.
+	VerificationTime time.Time
+
+	// CertificatePolicy, if not nil, restricts the certificates that
+	// VerifyTrusted accepts. If they do not satisfy it, VerifyTrusted
+	// returns a CertificatePolicyError.
+	CertificatePolicy *CertificatePolicy
+
+	// OCSP, if not nil, makes VerifyTrusted check with OCSP that the
+	// signing certificate is not revoked, as of the current time. If it is,
+	// VerifyTrusted returns a CertificateRevokedError, and if its status
+	// cannot be determined, an OCSPError.
+	OCSP *OCSPChecker
+
+	// Parser configures the parser of the documents passed to Sign,
+	// Verify, InsertSignatureTemplate and the related functions. The zero
+	// value is suitable for untrusted documents.
+	Parser ParserOptions
+}
+
+// X509DataOptions selects the optional elements that Sign writes to an
+// X509Data element. As required by XMLDSIG, they identify the certificate
+// containing the signing key, which is the first of Certificates if set.
+type X509DataOptions struct {
+	// IssuerSerial adds an X509IssuerSerial element with the issuer name
+	// and serial number of the certificate.
+	IssuerSerial bool
+
+	// SubjectName adds an X509SubjectName element with the subject name of
+	// the certificate.
+	SubjectName bool
+
+	// SKI adds an X509SKI element with the subject key identifier of the
+	// certificate. Signing fails if the certificate does not have a subject
+	// key identifier extension.
+	SKI bool
+}
+
+// anySelected returns true if any of the optional elements are selected.
+func (o X509DataOptions) anySelected() bool {
+	return o.IssuerSerial || o.SubjectName || o.SKI
 }
 
 // XMLIDOption represents the definition of an XML reference element
 // (See http://www.w3.org/TR/xml-id/)
+//
+// The attributes named AttributeName of the elements named ElementName are
+// IDs. ElementName may be "*" to match any element. If ElementNamespace is
+// set, elements in other namespaces are not matched, and if
+// AttributeNamespace is set, only attributes in that namespace are matched.
+// The presets such as SAML2ProtocolID cover common document types.
 type XMLIDOption struct {
-	ElementName      string
-	ElementNamespace string
-	AttributeName    string
+	ElementName        string
+	ElementNamespace   string
+	AttributeName      string
+	AttributeNamespace string
 }
 
+// Presets of XMLIDOption for common document types.
+var (
+	// SAML2ProtocolID declares the ID attribute of SAML 2.0 protocol
+	// messages, such as Response and AuthnRequest.
+	SAML2ProtocolID = XMLIDOption{
+		ElementName:      "*",
+		ElementNamespace: "urn:oasis:names:tc:SAML:2.0:protocol",
+		AttributeName:    "ID",
+	}
+
+	// SAML2AssertionID declares the ID attribute of SAML 2.0 assertions.
+	SAML2AssertionID = XMLIDOption{
+		ElementName:      "Assertion",
+		ElementNamespace: "urn:oasis:names:tc:SAML:2.0:assertion",
+		AttributeName:    "ID",
+	}
+
+	// SAML11AssertionID declares the AssertionID attribute of SAML 1.1
+	// assertions.
+	SAML11AssertionID = XMLIDOption{
+		ElementName:      "Assertion",
+		ElementNamespace: "urn:oasis:names:tc:SAML:1.0:assertion",
+		AttributeName:    "AssertionID",
+	}
+
+	// SAML11ResponseID declares the ResponseID attribute of SAML 1.1
+	// responses.
+	SAML11ResponseID = XMLIDOption{
+		ElementName:      "Response",
+		ElementNamespace: "urn:oasis:names:tc:SAML:1.0:protocol",
+		AttributeName:    "ResponseID",
+	}
+
+	// WSSecurityID declares the wsu:Id attribute of WS-Security, on any
+	// element.
+	WSSecurityID = XMLIDOption{
+		ElementName:        "*",
+		AttributeName:      "Id",
+		AttributeNamespace: "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd",
+	}
+
+	// XMLNamespaceID declares the xml:id attribute, on any element.
+	XMLNamespaceID = XMLIDOption{
+		ElementName:        "*",
+		AttributeName:      "id",
+		AttributeNamespace: xmlNamespace,
+	}
+)
+
 // Sign returns a version of doc signed with key according to
 // the XMLDSIG standard. doc is a template document meaning
 // that it contains an `http://www.w3.org/2000/09/xmldsig#Signature`
 // element whose properties define how and what to sign.
+//
+// key may be a PKCS#1, SEC1 or PKCS#8 private key in either PEM or DER
+// form. If the key is encrypted, opts.Password must contain its password,
+// otherwise ErrIncorrectPassword is returned.
 func Sign(key []byte, doc []byte, opts SignatureOptions) ([]byte, error) {
 	startProcessingXML()
 	defer stopProcessingXML()
 
+	signKey, err := loadPrivateKey(key, opts.Password)
+	if err == ErrIncorrectPassword {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.New("failed to load pem key")
+	}
+
+	return signWithKey(signKey, doc, opts, nil)
+}
+
+// SignPKCS12 is like Sign except that the private key and certificates are
+// read from pkcs12, a PKCS#12 (.p12 or .pfx) bundle protected by
+// opts.Password. The certificate and any chain certificates in the bundle are
+// written to an X509Data element in the signature's KeyInfo.
+//
+// If the password is incorrect, ErrIncorrectPassword is returned.
+func SignPKCS12(pkcs12 []byte, doc []byte, opts SignatureOptions) ([]byte, error) {
+	startProcessingXML()
+	defer stopProcessingXML()
+
+	signKey, err := loadPKCS12(pkcs12, opts.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	return signWithKey(signKey, doc, opts, nil)
+}
+
+// signWithKey signs doc using signKey. It takes ownership of signKey. If
+// signer is not nil, it is used to compute the final signature value and
+// signKey only serves as a placeholder of the same type.
+func signWithKey(signKey *C.xmlSecKey, doc []byte, opts SignatureOptions, signer crypto.Signer) ([]byte, error) {
+	defer C.xmlSecKeyDestroy(signKey)
+
+	for _, cert := range opts.Certificates {
+		if rv := C.xmlSecCryptoAppKeyCertLoadMemory(signKey,
+			(*C.xmlSecByte)(unsafe.Pointer(&cert.Raw[0])),
+			C.xmlSecSize(len(cert.Raw)),
+			C.xmlSecKeyDataFormatCertDer); rv < 0 {
+			return nil, mustPopError()
+		}
+	}
+
+	var keyCert *x509.Certificate
+	if opts.X509Data.anySelected() {
+		if len(opts.Certificates) > 0 {
+			keyCert = opts.Certificates[0]
+		} else if _, keyCert = keyPublicParts(signKey); keyCert == nil {
+			return nil, errors.New("X509Data options require the certificate of the signing key")
+		}
+	}
+
+	parsedDoc, err := newDoc(doc, opts.XMLID, opts.Parser)
+	if err != nil {
+		return nil, err
+	}
+	defer closeDoc(parsedDoc)
+
+	nodes, err := findSignatureTemplates(parsedDoc, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, node := range nodes {
+		if err := signNode(signKey, node, signer, opts.X509Data, keyCert); err != nil {
+			return nil, err
+		}
+	}
+
+	return dumpDoc(parsedDoc), nil
+}
+
+// signNode signs the signature template node using a copy of signKey, or
+// using signer if it is not nil. x509Opts selects the elements that identify
+// keyCert, the certificate of the signing key, in X509Data.
+func signNode(signKey *C.xmlSecKey, node *C.xmlNode, signer crypto.Signer, x509Opts X509DataOptions, keyCert *x509.Certificate) error {
 	ctx := C.xmlSecDSigCtxCreate(nil)
 	if ctx == nil {
-		return nil, errors.New("failed to create signature context")
+		return errors.New("failed to create signature context")
 	}
 	defer C.xmlSecDSigCtxDestroy(ctx)
 
-	ctx.signKey = C.xmlSecCryptoAppKeyLoadMemory(
-		(*C.xmlSecByte)(unsafe.Pointer(&key[0])),
-		C.xmlSecSize(len(key)),
-		C.xmlSecKeyDataFormatPem,
-		nil, nil, nil)
+	ctx.signKey = C.xmlSecKeyDuplicate(signKey)
 	if ctx.signKey == nil {
-		return nil, errors.New("failed to load pem key")
+		return mustPopError()
 	}
 
-	parsedDoc, err := newDoc(doc, opts.XMLID)
-	if err != nil {
-		return nil, err
+	if C.xmlSecKeyGetData(signKey, C.MY_xmlSecKeyDataX509Id()) != nil {
+		x509DataNode, err := ensureX509Data(node)
+		if err != nil {
+			return err
+		}
+		if x509Opts.anySelected() {
+			if err := writeX509Identifiers(x509DataNode, keyCert, x509Opts); err != nil {
+				return err
+			}
+		}
 	}
-	defer closeDoc(parsedDoc)
 
-	node := C.xmlSecFindNode(C.xmlDocGetRootElement(parsedDoc),
-		(*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeSignature)),
-		(*C.xmlChar)(unsafe.Pointer(&C.xmlSecDSigNs)))
-	if node == nil {
-		return nil, errors.New("cannot find start node")
+	// xmlsec cannot write the KeyValue of EC keys, and would write the
+	// placeholder key when using signer, so in those cases we write the
+	// KeyValue elements ourselves.
+	var publicKey crypto.PublicKey
+	if signer != nil {
+		publicKey = signer.Public()
+	} else {
+		publicKey, _ = keyPublicParts(signKey)
+	}
+	var keyValueNodes []detachedNode
+	if _, isEC := publicKey.(*ecdsa.PublicKey); isEC || signer != nil {
+		keyValueNodes = detachKeyValueNodes(node)
+	}
+	defer func() {
+		for _, keyValueNode := range keyValueNodes {
+			keyValueNode.Restore()
+		}
+	}()
+
+	if signer != nil {
+		ctx.flags |= C.XMLSEC_DSIG_FLAGS_STORE_SIGNATURE
 	}
 
 	if rv := C.xmlSecDSigCtxSign(ctx, node); rv < 0 {
-		return nil, errors.New("failed to sign")
+		return errors.New("failed to sign")
 	}
 
-	return dumpDoc(parsedDoc), nil
+	if signer != nil {
+		if err := replaceSignatureValue(ctx, signer); err != nil {
+			return err
+		}
+	}
+
+	for _, keyValueNode := range keyValueNodes {
+		if err := writeKeyValue(keyValueNode.Node, publicKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findSignatureTemplates returns the Signature elements in doc that should
+// be signed according to opts, in the order they should be signed.
+func findSignatureTemplates(doc *C.xmlDoc, opts SignatureOptions) ([]*C.xmlNode, error) {
+	type template struct {
+		Node  *C.xmlNode
+		Depth int
+	}
+	templates := []template{}
+
+	var walk func(node *C.xmlNode, depth int)
+	walk = func(node *C.xmlNode, depth int) {
+		for cur := C.xmlSecGetNextElementNode(node); cur != nil; cur = C.xmlSecGetNextElementNode(cur.next) {
+			if C.xmlSecCheckNodeName(cur,
+				(*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeSignature)),
+				(*C.xmlChar)(unsafe.Pointer(&C.xmlSecDSigNs))) == 1 {
+				if opts.TemplateID == "" || isSignatureTemplateWithID(cur, opts.TemplateID) {
+					templates = append(templates, template{Node: cur, Depth: depth})
+				}
+			}
+			walk(cur.children, depth+1)
+		}
+	}
+	walk(C.xmlDocGetRootElement(doc), 0)
+
+	if len(templates) == 0 {
+		if opts.TemplateID != "" {
+			return nil, fmt.Errorf("cannot find signature template with ID %q", opts.TemplateID)
+		}
+		return nil, errors.New("cannot find start node")
+	}
+
+	if !opts.SignAllTemplates {
+		return []*C.xmlNode{templates[0].Node}, nil
+	}
+
+	// sign the innermost templates first so that the digests computed for
+	// the outer signatures cover the final value of the inner ones.
+	sort.SliceStable(templates, func(i, j int) bool {
+		return templates[i].Depth > templates[j].Depth
+	})
+	nodes := make([]*C.xmlNode, len(templates))
+	for i, t := range templates {
+		nodes[i] = t.Node
+	}
+	return nodes, nil
+}
+
+// isSignatureTemplateWithID returns true if the Signature element node has
+// an Id attribute equal to id, or if its parent element has that ID.
+func isSignatureTemplateWithID(node *C.xmlNode, id string) bool {
+	if getAttr(node, "Id") == id {
+		return true
+	}
+
+	parent := node.parent
+	return parent != nil && parent == findElementByID(node.doc, id)
+}
+
+// ensureX509Data makes sure that the KeyInfo of the signature template
+// signatureNode contains an X509Data element, so that xmlsec writes the
+// certificates associated with the signing key into it, and returns it.
+func ensureX509Data(signatureNode *C.xmlNode) (*C.xmlNode, error) {
+	keyInfoNode := C.xmlSecFindChild(signatureNode,
+		(*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeKeyInfo)),
+		(*C.xmlChar)(unsafe.Pointer(&C.xmlSecDSigNs)))
+	if keyInfoNode == nil {
+		keyInfoNode = C.xmlSecTmplSignatureEnsureKeyInfo(signatureNode, nil)
+		if keyInfoNode == nil {
+			return nil, mustPopError()
+		}
+	}
 
+	x509DataNode := C.xmlSecFindChild(keyInfoNode,
+		(*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeX509Data)),
+		(*C.xmlChar)(unsafe.Pointer(&C.xmlSecDSigNs)))
+	if x509DataNode != nil {
+		return x509DataNode, nil
+	}
+	x509DataNode = C.xmlSecTmplKeyInfoAddX509Data(keyInfoNode)
+	if x509DataNode == nil {
+		return nil, mustPopError()
+	}
+	return x509DataNode, nil
 }
 
 // ErrVerificationFailed is returned from Verify when the signature is incorrect
@@ -90,109 +469,225 @@ const (
 
 // Verify checks that the signature in doc is valid according
 // to the XMLDSIG specification. publicKey is the public part of
-// the key used to sign doc. If the signature is not correct,
-// this function returns ErrVerificationFailed.
+// the key used to sign doc: a certificate, a SubjectPublicKeyInfo or a
+// PKCS#1 RSA public key, PEM or DER encoded, or a JSON Web Key. Use
+// VerifyWithPublicKey to pass a Go value instead. If the signature is not
+// correct, this function returns ErrVerificationFailed.
 func Verify(publicKey []byte, doc []byte, opts SignatureOptions) error {
+	_, err := VerifyDetailed(publicKey, doc, opts)
+	return err
+}
+
+// VerifyDetailed checks that the signature in doc is valid like Verify, and
+// returns a description of what the signature covers. Callers should check
+// that the References in the result cover the elements they rely on, to
+// protect against signature wrapping attacks.
+//
+// If the signature is not correct, this function returns ErrVerificationFailed
+// along with a result describing as much of the signature as was processed.
+func VerifyDetailed(publicKey []byte, doc []byte, opts SignatureOptions) (*VerificationResult, error) {
 	startProcessingXML()
 	defer stopProcessingXML()
 
-	keysMngr := C.xmlSecKeysMngrCreate()
-	if keysMngr == nil {
-		return mustPopError()
+	if opts.KeyStore != nil {
+		if len(publicKey) != 0 {
+			return nil, errKeyAndKeyStore
+		}
+		return verifyWithKeyStore(opts.KeyStore, doc, opts, verifyAnyKey)
 	}
-	defer C.xmlSecKeysMngrDestroy(keysMngr)
 
-	if rv := C.xmlSecCryptoAppDefaultKeysMngrInit(keysMngr); rv < 0 {
-		return mustPopError()
+	keysMngr, err := newKeysMngr()
+	if err != nil {
+		return nil, err
 	}
+	defer C.xmlSecKeysMngrDestroy(keysMngr)
 
-	key := C.xmlSecCryptoAppKeyLoadMemory(
-		(*C.xmlSecByte)(unsafe.Pointer(&publicKey[0])),
-		C.xmlSecSize(len(publicKey)),
-		C.xmlSecKeyDataFormatCertPem,
-		nil, nil, nil)
-	if key == nil {
-		return mustPopError()
-	}
+	if len(publicKey) != 0 || opts.KeyResolver == nil {
+		key, err := loadPublicKey(publicKey)
+		if err != nil {
+			return nil, err
+		}
 
-	if rv := C.xmlSecCryptoAppKeyCertLoadMemory(key,
-		(*C.xmlSecByte)(unsafe.Pointer(&publicKey[0])),
-		C.xmlSecSize(len(publicKey)),
-		C.xmlSecKeyDataFormatCertPem); rv < 0 {
-		C.xmlSecKeyDestroy(key)
-		return mustPopError()
+		if rv := C.xmlSecCryptoAppDefaultKeysMngrAdoptKey(keysMngr, key); rv < 0 {
+			C.xmlSecKeyDestroy(key)
+			return nil, mustPopError()
+		}
 	}
 
-	if rv := C.xmlSecCryptoAppDefaultKeysMngrAdoptKey(keysMngr, key); rv < 0 {
-		return mustPopError()
-	}
+	return verifyWithKeysMngr(keysMngr, doc, opts, verifyAnyKey)
+}
 
-	dsigCtx := C.xmlSecDSigCtxCreate(keysMngr)
-	if dsigCtx == nil {
-		return mustPopError()
+// VerifyTrusted checks that the signature in doc is valid according
+// to the XMLDSIG specification. certs is an array of trusted certificates,
+// typically roots. Intermediate certificates that should not be trusted in
+// their own right belong in opts.Intermediates instead.
+// If the signature is not correct, this function returns ErrVerificationFailed.
+func VerifyTrusted(certs [][]byte, doc []byte, opts SignatureOptions) error {
+	_, err := VerifyTrustedDetailed(certs, doc, opts)
+	return err
+}
+
+// VerifyTrustedDetailed checks that the signature in doc is valid like
+// VerifyTrusted, and returns a description of what the signature covers,
+// like VerifyDetailed. The Certificate of the result is the validated
+// certificate of the signer, and its Chain links it to the trusted
+// certificate that issued it.
+func VerifyTrustedDetailed(certs [][]byte, doc []byte, opts SignatureOptions) (*VerificationResult, error) {
+	startProcessingXML()
+	defer stopProcessingXML()
+
+	if opts.KeyStore != nil {
+		if len(certs) != 0 || len(opts.Intermediates) != 0 {
+			return nil, errKeyAndKeyStore
+		}
+		result, err := verifyWithKeyStore(opts.KeyStore, doc, opts, verifyTrustedKey)
+		if err != nil {
+			return result, err
+		}
+		trusted, intermediates := opts.KeyStore.certificates()
+		result.buildChain(trusted, intermediates)
+		return result, checkCertificates(result, opts)
 	}
-	defer C.xmlSecDSigCtxDestroy(dsigCtx)
 
-	parsedDoc, err := newDoc(doc, opts.XMLID)
+	keysMngr, err := newKeysMngr()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer closeDoc(parsedDoc)
+	defer C.xmlSecKeysMngrDestroy(keysMngr)
 
-	node := C.xmlSecFindNode(C.xmlDocGetRootElement(parsedDoc),
-		(*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeSignature)),
-		(*C.xmlChar)(unsafe.Pointer(&C.xmlSecDSigNs)))
-	if node == nil {
-		return errors.New("cannot find start node")
+	for _, cert := range certs {
+		if err := loadX509StoreCert(keysMngr, cert, C.xmlSecKeyDataTypeTrusted); err != nil {
+			return nil, err
+		}
+	}
+	for _, cert := range opts.Intermediates {
+		if err := loadX509StoreCert(keysMngr, cert, C.xmlSecKeyDataTypeNone); err != nil {
+			return nil, err
+		}
+	}
+	for _, crl := range opts.CRLs {
+		if _, err := loadCRL(keysMngr, crl); err != nil {
+			return nil, err
+		}
 	}
 
-	if rv := C.xmlSecDSigCtxVerify(dsigCtx, node); rv < 0 {
-		return ErrVerificationFailed
+	result, err := verifyWithKeysMngr(keysMngr, doc, opts, verifyTrustedKey)
+	if err != nil {
+		return result, err
 	}
+	result.buildChain(parsePEMCertificates(certs), parsePEMCertificates(opts.Intermediates))
+	return result, checkCertificates(result, opts)
+}
 
-	if dsigCtx.status != xmlSecDSigStatusSucceeded {
-		return ErrVerificationFailed
+// checkCertificates returns an error if the certificates of result do not
+// satisfy the CertificatePolicy of opts, if any, or if the OCSPChecker of
+// opts finds that the signing certificate is revoked.
+func checkCertificates(result *VerificationResult, opts SignatureOptions) error {
+	if opts.CertificatePolicy != nil {
+		if err := opts.CertificatePolicy.check(result); err != nil {
+			return err
+		}
+	}
+	if opts.OCSP != nil {
+		return opts.OCSP.check(result)
 	}
 	return nil
 }
 
-// Verify checks that the signature in doc is valid according
-// to the XMLDSIG specification. certs is an array of trusted certificates.
-// If the signature is not correct, this function returns ErrVerificationFailed.
-func VerifyTrusted(certs [][]byte, doc []byte, opts SignatureOptions) error {
-	startProcessingXML()
-	defer stopProcessingXML()
-
-	keysMngr := C.xmlSecKeysMngrCreate()
-	if keysMngr == nil {
-		return mustPopError()
+// verifyWithKeyStore verifies the first signature in doc using the keys
+// in keyStore, as allowed by mode. If mode is verifyAnyKey and the key that
+// xmlsec selects, typically by the KeyName of the signature, does not verify
+// the signature, we try each of the keys in the store in turn.
+func verifyWithKeyStore(keyStore *KeyStore, doc []byte, opts SignatureOptions, mode verifyMode) (*VerificationResult, error) {
+	keysMngr, err := keyStore.acquire()
+	if err != nil {
+		return nil, err
 	}
-	defer C.xmlSecKeysMngrDestroy(keysMngr)
+	defer keyStore.release()
 
-	if rv := C.xmlSecCryptoAppDefaultKeysMngrInit(keysMngr); rv < 0 {
-		return mustPopError()
+	result, err := verifyWithKeysMngr(keysMngr, doc, opts, mode)
+	if err != ErrVerificationFailed || mode != verifyAnyKey {
+		return result, err
 	}
-
-	for _, cert := range certs {
-		if rv := C.xmlSecCryptoAppKeysMngrCertLoadMemory(
-			keysMngr,
-			(*C.xmlSecByte)(unsafe.Pointer(&cert[0])),
-			C.xmlSecSize(len(cert)),
-			C.xmlSecKeyDataFormatCertPem,
-			C.xmlSecKeyDataTypeTrusted); rv < 0 {
-			return mustPopError()
+	for _, key := range storeKeys(keysMngr) {
+		signKey := C.xmlSecKeyDuplicate(key)
+		if signKey == nil {
+			return nil, mustPopError()
+		}
+		if keyResult, err := verifyWithSignKey(keysMngr, signKey, doc, opts, verifyAnyKey); err == nil {
+			return keyResult, nil
 		}
 	}
+	return result, ErrVerificationFailed
+}
+
+// verifyMode restricts the keys that may verify a signature.
+type verifyMode int
 
+const (
+	// verifyAnyKey accepts the keys of the keys manager, selected by the
+	// KeyName of the signature, and those of the certificates in its
+	// X509Data that the X509 store of the keys manager validates. Keys
+	// carried in the KeyInfo, such as a KeyValue, are ignored, since
+	// whoever made the document chose them.
+	verifyAnyKey verifyMode = iota
+
+	// verifyHMACKey accepts only the HMAC keys of the keys manager, and
+	// signatures whose SignatureMethod is an HMAC.
+	verifyHMACKey
+
+	// verifyTrustedKey accepts only the keys of the certificates in the
+	// X509Data of the signature that the X509 store of the keys manager
+	// validates.
+	verifyTrustedKey
+)
+
+// verifyWithKeysMngr verifies the first signature in doc using the keys
+// in keysMngr.
+func verifyWithKeysMngr(keysMngr *C.xmlSecKeysMngr, doc []byte, opts SignatureOptions, mode verifyMode) (*VerificationResult, error) {
+	return verifyWithSignKey(keysMngr, nil, doc, opts, mode)
+}
+
+// verifyWithSignKey verifies the first signature in doc. If signKey is not
+// nil it is used to check the signature, and this function takes ownership
+// of it. Otherwise the key is found using the keys in keysMngr, as allowed
+// by mode.
+func verifyWithSignKey(keysMngr *C.xmlSecKeysMngr, signKey *C.xmlSecKey, doc []byte, opts SignatureOptions, mode verifyMode) (*VerificationResult, error) {
 	dsigCtx := C.xmlSecDSigCtxCreate(keysMngr)
 	if dsigCtx == nil {
-		return mustPopError()
+		if signKey != nil {
+			C.xmlSecKeyDestroy(signKey)
+		}
+		return nil, mustPopError()
 	}
 	defer C.xmlSecDSigCtxDestroy(dsigCtx)
+	dsigCtx.signKey = signKey
+
+	if !opts.VerificationTime.IsZero() {
+		dsigCtx.keyInfoReadCtx.certsVerificationTime = C.time_t(opts.VerificationTime.Unix())
+	}
+
+	var resolverCall *keyResolverCall
+	if opts.KeyResolver != nil || mode == verifyTrustedKey {
+		resolverCall = attachKeyResolver(&dsigCtx.keyInfoReadCtx, opts.KeyResolver, loadPublicKey)
+		defer resolverCall.detach()
+	}
+	switch mode {
+	case verifyAnyKey:
+		if err := enableKeyData(&dsigCtx.keyInfoReadCtx, C.MY_xmlSecKeyDataNameId(), C.MY_xmlSecKeyDataX509Id()); err != nil {
+			return nil, err
+		}
+	case verifyTrustedKey:
+		resolverCall.exclusive = true
+		resolverCall.trusted = true
+		if err := enableKeyData(&dsigCtx.keyInfoReadCtx, C.MY_xmlSecKeyDataX509Id()); err != nil {
+			return nil, err
+		}
+	}
 
-	parsedDoc, err := newDoc(doc, opts.XMLID)
+	parsedDoc, err := newDoc(doc, opts.XMLID, opts.Parser)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer closeDoc(parsedDoc)
 
@@ -200,15 +695,49 @@ func VerifyTrusted(certs [][]byte, doc []byte, opts SignatureOptions) error {
 		(*C.xmlChar)(unsafe.Pointer(&C.xmlSecNodeSignature)),
 		(*C.xmlChar)(unsafe.Pointer(&C.xmlSecDSigNs)))
 	if node == nil {
-		return errors.New("cannot find start node")
+		return nil, errors.New("cannot find start node")
+	}
+
+	if err := checkHMACOutputLength(node); err != nil {
+		return nil, err
 	}
 
-	if rv := C.xmlSecDSigCtxVerify(dsigCtx, node); rv < 0 {
-		return ErrVerificationFailed
+	if !opts.AllowExternalReferences {
+		if err := checkSameDocumentReferences(node); err != nil {
+			return nil, err
+		}
+		restrictToSameDocument(dsigCtx)
 	}
 
-	if dsigCtx.status != xmlSecDSigStatusSucceeded {
-		return ErrVerificationFailed
+	policy := opts.AlgorithmPolicy
+	if mode == verifyHMACKey {
+		if err := checkHMACSignatureMethod(node); err != nil {
+			return nil, err
+		}
+		if err := restrictToKeysMngrKeys(dsigCtx); err != nil {
+			return nil, err
+		}
+		policy = policy.hmacOnly()
 	}
-	return nil
+	if policy != nil {
+		if err := policy.check(node); err != nil {
+			return nil, err
+		}
+		if err := policy.apply(dsigCtx); err != nil {
+			return nil, err
+		}
+	}
+
+	rv := C.xmlSecDSigCtxVerify(dsigCtx, node)
+	result := newVerificationResult(dsigCtx, node)
+	if resolverCall != nil && resolverCall.err != nil {
+		return result, resolverCall.err
+	}
+	if rv < 0 || dsigCtx.status != xmlSecDSigStatusSucceeded {
+		if hasLibraryError(C.XMLSEC_ERRORS_R_CERT_REVOKED) {
+			return result, revocationError(node, opts)
+		}
+		return result, ErrVerificationFailed
+	}
+	return result, nil
 }
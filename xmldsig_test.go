@@ -1,7 +1,13 @@
 package xmlsec
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
 	"encoding/xml"
+	"regexp"
 	"strings"
 
 	. "gopkg.in/check.v1"
@@ -278,3 +284,156 @@ Geka8nz8JjwxpUjAiSWYKLtJhGEaTqCYxCCX2Dw+dOTqUzHOZ7WKv4JXPK5G/Uhr
 	})
 	c.Assert(err, IsNil)
 }
+
+const nestedSignaturesDocStr = `<?xml version="1.0" encoding="UTF-8"?>
+<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="response-id" Version="2.0">
+  <ds:Signature xmlns:ds="http://www.w3.org/2000/09/xmldsig#" Id="response-signature">
+    <ds:SignedInfo>
+      <ds:CanonicalizationMethod Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#"/>
+      <ds:SignatureMethod Algorithm="http://www.w3.org/2000/09/xmldsig#rsa-sha1"/>
+      <ds:Reference URI="#response-id">
+        <ds:Transforms>
+          <ds:Transform Algorithm="http://www.w3.org/2000/09/xmldsig#enveloped-signature"/>
+          <ds:Transform Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#"/>
+        </ds:Transforms>
+        <ds:DigestMethod Algorithm="http://www.w3.org/2000/09/xmldsig#sha1"/>
+        <ds:DigestValue></ds:DigestValue>
+      </ds:Reference>
+    </ds:SignedInfo>
+    <ds:SignatureValue></ds:SignatureValue>
+  </ds:Signature>
+  <saml:Assertion ID="assertion-id" Version="2.0">
+    <saml:Issuer>https://idp.example.com/</saml:Issuer>
+    <ds:Signature xmlns:ds="http://www.w3.org/2000/09/xmldsig#">
+      <ds:SignedInfo>
+        <ds:CanonicalizationMethod Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#"/>
+        <ds:SignatureMethod Algorithm="http://www.w3.org/2000/09/xmldsig#rsa-sha1"/>
+        <ds:Reference URI="#assertion-id">
+          <ds:Transforms>
+            <ds:Transform Algorithm="http://www.w3.org/2000/09/xmldsig#enveloped-signature"/>
+            <ds:Transform Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#"/>
+          </ds:Transforms>
+          <ds:DigestMethod Algorithm="http://www.w3.org/2000/09/xmldsig#sha1"/>
+          <ds:DigestValue></ds:DigestValue>
+        </ds:Reference>
+      </ds:SignedInfo>
+      <ds:SignatureValue></ds:SignatureValue>
+    </ds:Signature>
+    <saml:Subject>
+      <saml:NameID>alice</saml:NameID>
+    </saml:Subject>
+  </saml:Assertion>
+</samlp:Response>
+`
+
+var nestedSignaturesXMLID = []XMLIDOption{
+	{
+		ElementName:      "Response",
+		ElementNamespace: "urn:oasis:names:tc:SAML:2.0:protocol",
+		AttributeName:    "ID",
+	},
+	{
+		ElementName:      "Assertion",
+		ElementNamespace: "urn:oasis:names:tc:SAML:2.0:assertion",
+		AttributeName:    "ID",
+	},
+}
+
+// forgedSignatures returns documents signed with keys chosen by an attacker,
+// which carry them in their KeyInfo: one signed by an arbitrary RSA key with
+// its RSAKeyValue, and one signed with an HMAC secret with its HMACKeyValue.
+// Neither may verify against keys that the caller holds.
+func forgedSignatures(c *C) (rsaKeyValue, hmacKeyValue []byte) {
+	doc := []byte(`<Envelope xmlns="urn:envelope"><Data>Hello, World!</Data></Envelope>`)
+	template := func(signatureMethod string, keyInfo *KeyInfoTemplate) []byte {
+		doc, err := InsertSignatureTemplate(doc, SignatureTemplate{
+			SignatureMethod: signatureMethod,
+			References: []ReferenceTemplate{{
+				DigestMethod: "http://www.w3.org/2001/04/xmlenc#sha256",
+				Transforms: []TransformTemplate{
+					{Algorithm: "http://www.w3.org/2000/09/xmldsig#enveloped-signature"},
+				},
+			}},
+			KeyInfo: keyInfo,
+		}, TemplateLocation{}, SignatureOptions{})
+		c.Assert(err, IsNil)
+		return doc
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, IsNil)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	rsaKeyValue, err = Sign(keyPEM, template("http://www.w3.org/2001/04/xmldsig-more#rsa-sha256",
+		&KeyInfoTemplate{KeyValue: true}), SignatureOptions{})
+	c.Assert(err, IsNil)
+
+	// the KeyInfo is not signed, so the secret can be added after signing
+	secret := []byte("chosen by the attacker")
+	hmacKeyValue, err = SignHMAC(secret, template("http://www.w3.org/2001/04/xmldsig-more#hmac-sha256", nil), SignatureOptions{})
+	c.Assert(err, IsNil)
+	hmacKeyValue = []byte(strings.Replace(string(hmacKeyValue), "</SignatureValue>",
+		"</SignatureValue><KeyInfo><KeyValue>"+
+			`<HMACKeyValue xmlns="http://www.aleksey.com/xmlsec/2002">`+base64.StdEncoding.EncodeToString(secret)+"</HMACKeyValue>"+
+			"</KeyValue></KeyInfo>", 1))
+	return rsaKeyValue, hmacKeyValue
+}
+
+// removeFirstSignature returns doc without its first Signature element, so
+// that Verify checks the next one.
+func removeFirstSignature(doc []byte) []byte {
+	re := regexp.MustCompile(`(?s)<ds:Signature .*?</ds:Signature>`)
+	loc := re.FindIndex(doc)
+	if loc == nil {
+		return doc
+	}
+	return append(append([]byte{}, doc[:loc[0]]...), doc[loc[1]:]...)
+}
+
+func (testSuite *XMLDSigTest) TestSignAllTemplates(c *C) {
+	signed, err := Sign(testSuite.Key, []byte(nestedSignaturesDocStr), SignatureOptions{
+		XMLID:            nestedSignaturesXMLID,
+		SignAllTemplates: true,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(strings.Count(string(signed), "<ds:SignatureValue/>"), Equals, 0)
+
+	// the response signature covers the signed assertion
+	err = Verify(testSuite.Cert, signed, SignatureOptions{XMLID: nestedSignaturesXMLID})
+	c.Assert(err, IsNil)
+
+	// the assertion signature is valid on its own
+	err = Verify(testSuite.Cert, removeFirstSignature(signed), SignatureOptions{XMLID: nestedSignaturesXMLID})
+	c.Assert(err, IsNil)
+}
+
+func (testSuite *XMLDSigTest) TestSignTemplateByParentID(c *C) {
+	signed, err := Sign(testSuite.Key, []byte(nestedSignaturesDocStr), SignatureOptions{
+		XMLID:      nestedSignaturesXMLID,
+		TemplateID: "assertion-id",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(strings.Count(string(signed), "<ds:SignatureValue/>"), Equals, 1)
+
+	err = Verify(testSuite.Cert, removeFirstSignature(signed), SignatureOptions{XMLID: nestedSignaturesXMLID})
+	c.Assert(err, IsNil)
+}
+
+func (testSuite *XMLDSigTest) TestSignTemplateBySignatureID(c *C) {
+	signed, err := Sign(testSuite.Key, []byte(nestedSignaturesDocStr), SignatureOptions{
+		XMLID:      nestedSignaturesXMLID,
+		TemplateID: "response-signature",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(strings.Count(string(signed), "<ds:SignatureValue/>"), Equals, 1)
+
+	err = Verify(testSuite.Cert, signed, SignatureOptions{XMLID: nestedSignaturesXMLID})
+	c.Assert(err, IsNil)
+}
+
+func (testSuite *XMLDSigTest) TestSignTemplateNotFound(c *C) {
+	_, err := Sign(testSuite.Key, []byte(nestedSignaturesDocStr), SignatureOptions{
+		XMLID:      nestedSignaturesXMLID,
+		TemplateID: "no-such-id",
+	})
+	c.Assert(err, ErrorMatches, `cannot find signature template with ID "no-such-id"`)
+}
@@ -18,7 +18,9 @@ import "C"
 
 // #include <libxml/parser.h>
 // #include <libxml/parserInternals.h>
+// #include <libxml/valid.h>
 // #include <libxml/xmlmemory.h>
+// #include <stdlib.h>
 //
 // // xmlFree is a macro, so we need to wrap it in order to be able to call
 // // it from go code.
@@ -39,65 +41,108 @@ func init() {
 	if rv := C.xmlSecCryptoInit(); rv < 0 {
 		panic("xmlsec crypto initialization failed.")
 	}
-}
-
-func newDoc(buf []byte, idattrs []XMLIDOption) (*C.xmlDoc, error) {
-	ctx := C.xmlCreateMemoryParserCtxt((*C.char)(unsafe.Pointer(&buf[0])),
-		C.int(len(buf)))
-	if ctx == nil {
-		return nil, mustPopError()
-	}
-	defer C.xmlFreeParserCtxt(ctx)
-
-	C.xmlParseDocument(ctx)
-
-	if ctx.wellFormed == C.int(0) {
-		return nil, mustPopError()
-	}
-
-	doc := ctx.myDoc
-	if doc == nil {
-		return nil, mustPopError()
+	if err := registerPSSTransforms(); err != nil {
+		panic("xmlsec RSASSA-PSS initialization failed.")
 	}
-
-	for _, idattr := range idattrs {
-		addIDAttr(C.xmlDocGetRootElement(doc),
-			idattr.AttributeName, idattr.ElementName, idattr.ElementNamespace)
+	if err := registerEdDSATransforms(); err != nil {
+		panic("xmlsec EdDSA initialization failed.")
 	}
-	return doc, nil
 }
 
-func addIDAttr(node *C.xmlNode, attrName, nodeName, nsHref string) {
+// addIDAttr registers the attributes of node and its descendants that are
+// described by idattr as IDs.
+func addIDAttr(node *C.xmlNode, idattr XMLIDOption) {
 	// process children first because it does not matter much but does simplify code
 	cur := C.xmlSecGetNextElementNode(node.children)
 	for {
 		if cur == nil {
 			break
 		}
-		addIDAttr(cur, attrName, nodeName, nsHref)
+		addIDAttr(cur, idattr)
 		cur = C.xmlSecGetNextElementNode(cur.next)
 	}
 
-	if C.GoString((*C.char)(unsafe.Pointer(node.name))) != nodeName {
+	if idattr.ElementName != "*" && C.GoString((*C.char)(unsafe.Pointer(node.name))) != idattr.ElementName {
 		return
 	}
-	if nsHref != "" && node.ns != nil && C.GoString((*C.char)(unsafe.Pointer(node.ns.href))) != nsHref {
+	if idattr.ElementNamespace != "" &&
+		(node.ns == nil || C.GoString((*C.char)(unsafe.Pointer(node.ns.href))) != idattr.ElementNamespace) {
 		return
 	}
 
-	// the attribute with name equal to attrName should exist
+	// the attribute with name equal to AttributeName should exist
 	for attr := node.properties; attr != nil; attr = attr.next {
-		if C.GoString((*C.char)(unsafe.Pointer(attr.name))) == attrName {
-			id := C.xmlNodeListGetString(node.doc, attr.children, 1)
-			if id == nil {
-				continue
-			}
-			C.xmlAddID(nil, node.doc, id, attr)
+		if C.GoString((*C.char)(unsafe.Pointer(attr.name))) != idattr.AttributeName {
+			continue
+		}
+		if idattr.AttributeNamespace != "" &&
+			(attr.ns == nil || C.GoString((*C.char)(unsafe.Pointer(attr.ns.href))) != idattr.AttributeNamespace) {
+			continue
+		}
+		if attr.atype == C.XML_ATTRIBUTE_ID {
+			continue // already an ID, for example an xml:id
 		}
+		id := C.xmlNodeListGetString(node.doc, attr.children, 1)
+		if id == nil {
+			continue
+		}
+		C.xmlAddID(nil, node.doc, id, attr)
+		C.MY_xmlFree(unsafe.Pointer(id))
 	}
+}
+
+// getAttr returns the value of the attribute of node named name that is
+// not in any namespace, or an empty string if there is no such attribute.
+func getAttr(node *C.xmlNode, name string) string {
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
 
-	return
+	value := C.xmlGetNoNsProp(node, (*C.xmlChar)(unsafe.Pointer(cName)))
+	if value == nil {
+		return ""
+	}
+	defer C.MY_xmlFree(unsafe.Pointer(value))
+	return C.GoString((*C.char)(unsafe.Pointer(value)))
+}
+
+// getContent returns the text content of node.
+func getContent(node *C.xmlNode) string {
+	content := C.xmlNodeGetContent(node)
+	if content == nil {
+		return ""
+	}
+	defer C.MY_xmlFree(unsafe.Pointer(content))
+	return C.GoString((*C.char)(unsafe.Pointer(content)))
 }
+
+// findElementByID returns the element of doc whose ID is id, or nil. IDs
+// registered with the document, for example those declared in XMLIDOption,
+// are used first. Otherwise we look for an element having an attribute named
+// ID, Id or id with the value id.
+func findElementByID(doc *C.xmlDoc, id string) *C.xmlNode {
+	cID := C.CString(id)
+	defer C.free(unsafe.Pointer(cID))
+	if attr := C.xmlGetID(doc, (*C.xmlChar)(unsafe.Pointer(cID))); attr != nil {
+		return attr.parent
+	}
+
+	var walk func(node *C.xmlNode) *C.xmlNode
+	walk = func(node *C.xmlNode) *C.xmlNode {
+		for cur := C.xmlSecGetNextElementNode(node); cur != nil; cur = C.xmlSecGetNextElementNode(cur.next) {
+			for _, name := range []string{"ID", "Id", "id"} {
+				if getAttr(cur, name) == id {
+					return cur
+				}
+			}
+			if found := walk(cur.children); found != nil {
+				return found
+			}
+		}
+		return nil
+	}
+	return walk(C.xmlDocGetRootElement(doc))
+}
+
 func closeDoc(doc *C.xmlDoc) {
 	C.xmlFreeDoc(doc)
 }